@@ -0,0 +1,157 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+	"knative.dev/hack/schema/docs"
+	"knative.dev/hack/schema/schema"
+
+	channelsv1alpha1 "github.com/aliok/websocket-channel/pkg/apis/channels/v1alpha1"
+	channelsv1beta1 "github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+)
+
+// types are the WebSocketChannel types of every version served by the CRD.
+var types = map[string]reflect.Type{
+	"v1alpha1": reflect.TypeOf(channelsv1alpha1.WebSocketChannel{}),
+	"v1beta1":  reflect.TypeOf(channelsv1beta1.WebSocketChannel{}),
+}
+
+// schema generates the OpenAPI schema of every version of the WebSocketChannel CRD from the Go
+// types, and writes it into the CRD. It must run from the root of the repository, as the docs of
+// the types are read from the sources. See hack/update-schemas.sh.
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: schema <crd file>")
+	}
+	file := os.Args[1]
+
+	docs.SetRoot("github.com/aliok/websocket-channel")
+
+	in, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal("Error reading the CRD: ", err)
+	}
+	var crd yaml.Node
+	if err := yaml.Unmarshal(in, &crd); err != nil {
+		log.Fatal("Error parsing the CRD: ", err)
+	}
+
+	versions := lookup(crd.Content[0], "spec", "versions")
+	if versions == nil {
+		log.Fatal("The CRD has no spec.versions")
+	}
+	for _, version := range versions.Content {
+		name := lookup(version, "name")
+		if name == nil {
+			log.Fatal("A version of the CRD has no name")
+		}
+		t, ok := types[name.Value]
+		if !ok {
+			log.Fatalf("Unknown version %q", name.Value)
+		}
+		s := schema.GenerateForType(t)
+		fixRequired(&s)
+
+		var node yaml.Node
+		if err := node.Encode(s); err != nil {
+			log.Fatal("Error encoding the schema: ", err)
+		}
+		openAPIV3Schema := lookup(version, "schema", "openAPIV3Schema")
+		if openAPIV3Schema == nil {
+			log.Fatalf("Version %q of the CRD has no schema.openAPIV3Schema", name.Value)
+		}
+		*openAPIV3Schema = node
+	}
+
+	// Keep the license header as it is, the encoder drops the blank line after it.
+	crd.HeadComment = ""
+	crd.Content[0].HeadComment = ""
+	crd.Content[0].Content[0].HeadComment = ""
+	out := bytes.NewBuffer(header(in))
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&crd); err != nil {
+		log.Fatal("Error encoding the CRD: ", err)
+	}
+	if err := ioutil.WriteFile(file, out.Bytes(), 0644); err != nil {
+		log.Fatal("Error writing the CRD: ", err)
+	}
+	fmt.Println("Updated", file)
+}
+
+// header returns the leading comment lines of a file, including the blank lines among and after them.
+func header(in []byte) []byte {
+	var n int
+	for _, line := range bytes.SplitAfter(in, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) > 0 && trimmed[0] != '#' {
+			break
+		}
+		n += len(line)
+	}
+	return append([]byte(nil), in[:n]...)
+}
+
+// lookup returns the value of the nested keys of a mapping node, or nil.
+func lookup(node *yaml.Node, keys ...string) *yaml.Node {
+	for _, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var value *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				value = node.Content[i+1]
+				break
+			}
+		}
+		if value == nil {
+			return nil
+		}
+		node = value
+	}
+	return node
+}
+
+// fixRequired drops the fields that the generator assumes are required because they are
+// pointers, while the docs of the duck types don't say they are optional.
+func fixRequired(s *schema.JSONSchemaProps) {
+	status := s.Properties["status"]
+	address := status.Properties["address"]
+	address.Required = without(address.Required, "url")
+	status.Properties["address"] = address
+	status.Required = without(status.Required, "address")
+	s.Properties["status"] = status
+}
+
+func without(fields []string, field string) []string {
+	var ret []string
+	for _, f := range fields {
+		if f != field {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
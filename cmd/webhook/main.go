@@ -20,14 +20,19 @@ import (
 	"context"
 
 	channelsv1alpha1 "github.com/aliok/websocket-channel/pkg/apis/channels/v1alpha1"
+	channelsv1beta1 "github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	"github.com/aliok/websocket-channel/pkg/apis/config"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/controller"
 	"knative.dev/pkg/injection/sharedmain"
+	"knative.dev/pkg/logging"
 	"knative.dev/pkg/signals"
 	"knative.dev/pkg/webhook"
 	"knative.dev/pkg/webhook/certificates"
+	"knative.dev/pkg/webhook/configmaps"
 	"knative.dev/pkg/webhook/resourcesemantics"
+	"knative.dev/pkg/webhook/resourcesemantics/conversion"
 	"knative.dev/pkg/webhook/resourcesemantics/defaulting"
 	"knative.dev/pkg/webhook/resourcesemantics/validation"
 )
@@ -36,11 +41,16 @@ var ourTypes = map[schema.GroupVersionKind]resourcesemantics.GenericCRD{
 	// For group channels.aliok.github.com
 	// v1alpha1
 	channelsv1alpha1.SchemeGroupVersion.WithKind("WebSocketChannel"): &channelsv1alpha1.WebSocketChannel{},
+	// v1beta1
+	channelsv1beta1.SchemeGroupVersion.WithKind("WebSocketChannel"): &channelsv1beta1.WebSocketChannel{},
 }
 
 var callbacks = map[schema.GroupVersionKind]validation.Callback{}
 
-func NewDefaultingAdmissionController(ctx context.Context, _ configmap.Watcher) *controller.Impl {
+func NewDefaultingAdmissionController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+	// Decorate contexts with the current state of the config.
+	store := config.NewStore(logging.FromContext(ctx).Named("config-store"))
+	store.WatchConfigs(cmw)
 
 	return defaulting.NewAdmissionController(ctx,
 
@@ -54,7 +64,7 @@ func NewDefaultingAdmissionController(ctx context.Context, _ configmap.Watcher)
 		ourTypes,
 
 		// A function that infuses the context passed to Validate/SetDefaults with custom metadata.
-		nil,
+		store.ToContext,
 
 		// Whether to disallow unknown fields.
 		true,
@@ -85,6 +95,53 @@ func NewValidationAdmissionController(ctx context.Context, cmw configmap.Watcher
 	)
 }
 
+func NewConfigValidationController(ctx context.Context, _ configmap.Watcher) *controller.Impl {
+	return configmaps.NewAdmissionController(ctx,
+
+		// Name of the configmap webhook.
+		"config.webhook.channels.aliok.github.com",
+
+		// The path on which to serve the webhook.
+		"/config-validation",
+
+		// The configmaps to validate.
+		configmap.Constructors{
+			config.ChannelConfigName: config.NewChannelConfigFromConfigMap,
+		},
+	)
+}
+
+func NewConversionController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+	// Decorate contexts with the current state of the config.
+	store := config.NewStore(logging.FromContext(ctx).Named("config-store"))
+	store.WatchConfigs(cmw)
+
+	var (
+		channelsv1alpha1_ = channelsv1alpha1.SchemeGroupVersion.Version
+		channelsv1beta1_  = channelsv1beta1.SchemeGroupVersion.Version
+	)
+
+	return conversion.NewConversionController(ctx,
+		// The path on which to serve the webhook
+		"/resource-conversion",
+
+		// Specify the types of custom resource definitions that should be converted
+		map[schema.GroupKind]conversion.GroupKindConversion{
+			channelsv1alpha1.Kind("WebSocketChannel"): {
+				DefinitionName: channelsv1alpha1.Resource("websocketchannels").String(),
+				HubVersion:     channelsv1alpha1_,
+				Zygotes: map[string]conversion.ConvertibleObject{
+					channelsv1alpha1_: &channelsv1alpha1.WebSocketChannel{},
+					channelsv1beta1_:  &channelsv1beta1.WebSocketChannel{},
+				},
+			},
+		},
+
+		// A function that infuses the context passed to ConvertTo/ConvertFrom/SetDefaults with custom metadata.
+		store.ToContext,
+	)
+}
+
 func main() {
 	// Set up a signal context with our webhook options
 	ctx := webhook.WithOptions(signals.NewContext(), webhook.Options{
@@ -98,5 +155,7 @@ func main() {
 		certificates.NewController,
 		NewValidationAdmissionController,
 		NewDefaultingAdmissionController,
+		NewConfigValidationController,
+		NewConversionController,
 	)
 }
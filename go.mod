@@ -3,13 +3,20 @@ module github.com/aliok/websocket-channel
 go 1.15
 
 require (
+	github.com/cloudevents/sdk-go/v2 v2.2.0
 	github.com/google/go-cmp v0.5.4
+	github.com/google/uuid v1.2.0
 	github.com/kelseyhightower/envconfig v1.4.0
+	github.com/rickb777/date v1.13.0
+	go.opencensus.io v0.22.6
 	go.uber.org/zap v1.16.0
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 	k8s.io/api v0.19.7
 	k8s.io/apimachinery v0.19.7
 	k8s.io/client-go v0.19.7
 	knative.dev/eventing v0.21.1
 	knative.dev/hack v0.0.0-20210203173706-8368e1f6eacf
+	knative.dev/hack/schema v0.0.0-20210609124042-e35bcb8f21ec
 	knative.dev/pkg v0.0.0-20210303192215-8fbab7ebb77b
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -39,17 +39,14 @@ group "Kubernetes Codegen"
 #                  instead of the $GOPATH directly. For normal projects this can be dropped.
 ${CODEGEN_PKG}/generate-groups.sh "deepcopy,client,informer,lister" \
   "github.com/aliok/websocket-channel/pkg/client" "github.com/aliok/websocket-channel/pkg/apis" \
-  "channels:v1alpha1" \
+  "channels:v1alpha1,v1beta1" \
   --go-header-file ${REPO_ROOT_DIR}/hack/boilerplate/boilerplate.go.txt
 
-# DO NOT DO THE FOLLOWING! No default config available yet!
-#
 # Deep copy config
-#${GOPATH}/bin/deepcopy-gen \
-#  -O zz_generated.deepcopy \
-#  --go-header-file ${REPO_ROOT_DIR}/hack/boilerplate/boilerplate.go.txt \
-#  -i github.com/aliok/websocket-channel/pkg/apis/config \
-#  -i github.com/aliok/websocket-channel/pkg/apis/messaging/config \
+${GOPATH}/bin/deepcopy-gen \
+  -O zz_generated.deepcopy \
+  --go-header-file ${REPO_ROOT_DIR}/hack/boilerplate/boilerplate.go.txt \
+  -i github.com/aliok/websocket-channel/pkg/apis/config
 
 # DO NOT DO THE FOLLOWING! No duck types available yet!
 #
@@ -64,7 +61,7 @@ group "Knative Codegen"
 ## Knative Injection
 ${KNATIVE_CODEGEN_PKG}/hack/generate-knative.sh "injection" \
   "github.com/aliok/websocket-channel/pkg/client" "github.com/aliok/websocket-channel/pkg/apis" \
-  "channels:v1alpha1" \
+  "channels:v1alpha1,v1beta1" \
   --go-header-file ${REPO_ROOT_DIR}/hack/boilerplate/boilerplate.go.txt
 
 group "Deepcopy Gen"
@@ -78,3 +75,7 @@ group "Update deps post-codegen"
 
 # Make sure our dependencies are up-to-date
 ${REPO_ROOT_DIR}/hack/update-deps.sh
+
+group "Update CRD Schema"
+
+${REPO_ROOT_DIR}/hack/update-schemas.sh
@@ -0,0 +1,198 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	"knative.dev/pkg/apis"
+)
+
+// V1beta1FieldsAnnotation holds the fields of a v1beta1 channel that v1alpha1 has no place for,
+// as JSON, so they survive a conversion to v1alpha1 and back.
+var V1beta1FieldsAnnotation = SchemeGroupVersion.Group + "/v1beta1-fields"
+
+// v1beta1Fields are the fields kept in the V1beta1FieldsAnnotation.
+type v1beta1Fields struct {
+	Spec   v1beta1SpecFields   `json:"spec"`
+	Status v1beta1StatusFields `json:"status"`
+}
+
+type v1beta1SpecFields struct {
+	Durable     bool                                 `json:"durable,omitempty"`
+	Expiry      *v1beta1.WebSocketChannelExpiry      `json:"expiry,omitempty"`
+	FlowControl *v1beta1.WebSocketChannelFlowControl `json:"flowControl,omitempty"`
+	Ordering    v1beta1.DeliveryOrdering             `json:"ordering,omitempty"`
+}
+
+type v1beta1StatusFields struct {
+	SubscriberStats []v1beta1.SubscriberDeliveryStats `json:"subscriberStats,omitempty"`
+}
+
+func (f *v1beta1Fields) isEmpty() bool {
+	return !f.Spec.Durable && f.Spec.Expiry == nil && f.Spec.FlowControl == nil && f.Spec.Ordering == "" &&
+		len(f.Status.SubscriberStats) == 0
+}
+
+// ConvertTo implements apis.Convertible.
+// Converts source (from v1alpha1.WebSocketChannel) into v1beta1.WebSocketChannel.
+func (source *WebSocketChannel) ConvertTo(ctx context.Context, to apis.Convertible) error {
+	switch sink := to.(type) {
+	case *v1beta1.WebSocketChannel:
+		sink.ObjectMeta = source.ObjectMeta
+		source.Spec.ConvertTo(ctx, &sink.Spec)
+		source.Status.ConvertTo(ctx, &sink.Status)
+
+		raw, ok := source.Annotations[V1beta1FieldsAnnotation]
+		if !ok {
+			return nil
+		}
+		var fields v1beta1Fields
+		if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+			return fmt.Errorf("invalid %s annotation: %w", V1beta1FieldsAnnotation, err)
+		}
+		sink.Spec.Durable = fields.Spec.Durable
+		sink.Spec.Expiry = fields.Spec.Expiry
+		sink.Spec.FlowControl = fields.Spec.FlowControl
+		sink.Spec.Ordering = fields.Spec.Ordering
+		sink.Status.SubscriberStats = fields.Status.SubscriberStats
+
+		sink.Annotations = make(map[string]string, len(source.Annotations)-1)
+		for k, v := range source.Annotations {
+			if k != V1beta1FieldsAnnotation {
+				sink.Annotations[k] = v
+			}
+		}
+		if len(sink.Annotations) == 0 {
+			sink.Annotations = nil
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown version, got: %T", sink)
+	}
+}
+
+// ConvertTo helps implement apis.Convertible for the spec.
+func (source *WebSocketChannelSpec) ConvertTo(_ context.Context, sink *v1beta1.WebSocketChannelSpec) {
+	sink.ChannelableSpec = source.ChannelableSpec
+	sink.Exposure = nil
+	if e := source.Exposure; e != nil {
+		sink.Exposure = &v1beta1.WebSocketChannelExposure{Host: e.Host}
+		if e.Ingress != nil {
+			sink.Exposure.Ingress = &v1beta1.IngressExposure{
+				ClassName:     e.Ingress.ClassName,
+				TLSSecretName: e.Ingress.TLSSecretName,
+			}
+		}
+		if e.HTTPRoute != nil {
+			sink.Exposure.HTTPRoute = &v1beta1.HTTPRouteExposure{
+				GatewayName:      e.HTTPRoute.GatewayName,
+				GatewayNamespace: e.HTTPRoute.GatewayNamespace,
+				TLS:              e.HTTPRoute.TLS,
+			}
+		}
+	}
+}
+
+// ConvertTo helps implement apis.Convertible for the status.
+func (source *WebSocketChannelStatus) ConvertTo(_ context.Context, sink *v1beta1.WebSocketChannelStatus) {
+	sink.ChannelableStatus = source.ChannelableStatus
+	sink.DeadLetterSinkURI = source.DeadLetterSinkURI
+	sink.ExternalURL = source.ExternalURL
+	sink.Addresses = nil
+	for _, address := range source.Addresses {
+		sink.Addresses = append(sink.Addresses, v1beta1.WebSocketChannelAddress{
+			Name: address.Name,
+			URL:  address.URL,
+		})
+	}
+}
+
+// ConvertFrom implements apis.Convertible.
+// Converts obj from v1beta1.WebSocketChannel into v1alpha1.WebSocketChannel.
+func (sink *WebSocketChannel) ConvertFrom(ctx context.Context, from apis.Convertible) error {
+	switch source := from.(type) {
+	case *v1beta1.WebSocketChannel:
+		sink.ObjectMeta = source.ObjectMeta
+		sink.Spec.ConvertFrom(ctx, &source.Spec)
+		sink.Status.ConvertFrom(ctx, &source.Status)
+
+		fields := v1beta1Fields{
+			Spec: v1beta1SpecFields{
+				Durable:     source.Spec.Durable,
+				Expiry:      source.Spec.Expiry,
+				FlowControl: source.Spec.FlowControl,
+				Ordering:    source.Spec.Ordering,
+			},
+			Status: v1beta1StatusFields{SubscriberStats: source.Status.SubscriberStats},
+		}
+		if fields.isEmpty() {
+			return nil
+		}
+		raw, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		sink.Annotations = make(map[string]string, len(source.Annotations)+1)
+		for k, v := range source.Annotations {
+			sink.Annotations[k] = v
+		}
+		sink.Annotations[V1beta1FieldsAnnotation] = string(raw)
+		return nil
+	default:
+		return fmt.Errorf("unknown version, got: %T", source)
+	}
+}
+
+// ConvertFrom helps implement apis.Convertible for the spec.
+func (sink *WebSocketChannelSpec) ConvertFrom(_ context.Context, source *v1beta1.WebSocketChannelSpec) {
+	sink.ChannelableSpec = source.ChannelableSpec
+	sink.Exposure = nil
+	if e := source.Exposure; e != nil {
+		sink.Exposure = &WebSocketChannelExposure{Host: e.Host}
+		if e.Ingress != nil {
+			sink.Exposure.Ingress = &IngressExposure{
+				ClassName:     e.Ingress.ClassName,
+				TLSSecretName: e.Ingress.TLSSecretName,
+			}
+		}
+		if e.HTTPRoute != nil {
+			sink.Exposure.HTTPRoute = &HTTPRouteExposure{
+				GatewayName:      e.HTTPRoute.GatewayName,
+				GatewayNamespace: e.HTTPRoute.GatewayNamespace,
+				TLS:              e.HTTPRoute.TLS,
+			}
+		}
+	}
+}
+
+// ConvertFrom helps implement apis.Convertible for the status.
+func (sink *WebSocketChannelStatus) ConvertFrom(_ context.Context, source *v1beta1.WebSocketChannelStatus) {
+	sink.ChannelableStatus = source.ChannelableStatus
+	sink.DeadLetterSinkURI = source.DeadLetterSinkURI
+	sink.ExternalURL = source.ExternalURL
+	sink.Addresses = nil
+	for _, address := range source.Addresses {
+		sink.Addresses = append(sink.Addresses, WebSocketChannelAddress{
+			Name: address.Name,
+			URL:  address.URL,
+		})
+	}
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/ptr"
+)
+
+// testObject is an apis.Convertible of an unknown version.
+type testObject struct{}
+
+func (*testObject) ConvertTo(context.Context, apis.Convertible) error   { return nil }
+func (*testObject) ConvertFrom(context.Context, apis.Convertible) error { return nil }
+
+func TestWebSocketChannelConversionBadType(t *testing.T) {
+	good, bad := &WebSocketChannel{}, &testObject{}
+
+	if err := good.ConvertTo(context.Background(), bad); err == nil {
+		t.Errorf("ConvertTo() = %#v, wanted error", bad)
+	}
+	if err := good.ConvertFrom(context.Background(), bad); err == nil {
+		t.Errorf("ConvertFrom() = %#v, wanted error", good)
+	}
+}
+
+// TestWebSocketChannelConversion converts v1alpha1 objects to v1beta1 and back.
+func TestWebSocketChannelConversion(t *testing.T) {
+	for name, in := range conversionTestChannels() {
+		t.Run(name, func(t *testing.T) {
+			beta := &v1beta1.WebSocketChannel{}
+			if err := in.ConvertTo(context.Background(), beta); err != nil {
+				t.Fatal("ConvertTo() =", err)
+			}
+			assertSameJSON(t, in, beta)
+
+			got := &WebSocketChannel{}
+			if err := got.ConvertFrom(context.Background(), beta); err != nil {
+				t.Fatal("ConvertFrom() =", err)
+			}
+			if diff := cmp.Diff(in, got); diff != "" {
+				t.Error("roundtrip (-want, +got) =", diff)
+			}
+		})
+	}
+}
+
+// TestWebSocketChannelConversionFromV1beta1 converts v1beta1 objects to v1alpha1 and back.
+func TestWebSocketChannelConversionFromV1beta1(t *testing.T) {
+	for name, channel := range conversionTestChannels() {
+		t.Run(name, func(t *testing.T) {
+			// The v1beta1 object is decoded from the JSON of the v1alpha1 one, v1beta1 has all
+			// the fields of v1alpha1.
+			in := &v1beta1.WebSocketChannel{}
+			data, err := json.Marshal(channel)
+			if err != nil {
+				t.Fatal("Marshal() =", err)
+			}
+			if err := json.Unmarshal(data, in); err != nil {
+				t.Fatal("Unmarshal() =", err)
+			}
+
+			hub := &WebSocketChannel{}
+			if err := hub.ConvertFrom(context.Background(), in); err != nil {
+				t.Fatal("ConvertFrom() =", err)
+			}
+			assertSameJSON(t, in, hub)
+
+			got := &v1beta1.WebSocketChannel{}
+			if err := hub.ConvertTo(context.Background(), got); err != nil {
+				t.Fatal("ConvertTo() =", err)
+			}
+			if diff := cmp.Diff(in, got); diff != "" {
+				t.Error("roundtrip (-want, +got) =", diff)
+			}
+		})
+	}
+}
+
+// TestWebSocketChannelConversionV1beta1Fields converts v1beta1 objects with fields v1alpha1 has
+// no place for to v1alpha1 and back.
+func TestWebSocketChannelConversionV1beta1Fields(t *testing.T) {
+	now := metav1.Unix(1600000000, 0)
+	stats := []v1beta1.SubscriberDeliveryStats{{
+		UID:                 "uid-1",
+		LastSuccessTime:     &now,
+		LastFailureTime:     &now,
+		LastFailureReason:   "503 Service Unavailable",
+		ConsecutiveFailures: 2,
+		DeadLettered:        1,
+	}}
+
+	tests := map[string]func() *v1beta1.WebSocketChannel{
+		"without annotations": func() *v1beta1.WebSocketChannel {
+			in := &v1beta1.WebSocketChannel{ObjectMeta: metav1.ObjectMeta{Name: "channel", Namespace: "ns"}}
+			in.Status.SubscriberStats = stats
+			return in
+		},
+		"spec only": func() *v1beta1.WebSocketChannel {
+			in := &v1beta1.WebSocketChannel{ObjectMeta: metav1.ObjectMeta{Name: "channel", Namespace: "ns"}}
+			in.Spec.Durable = true
+			return in
+		},
+		"with annotations": func() *v1beta1.WebSocketChannel {
+			in := &v1beta1.WebSocketChannel{ObjectMeta: metav1.ObjectMeta{
+				Name:        "channel",
+				Namespace:   "ns",
+				Annotations: map[string]string{"messaging.knative.dev/subscribable": "v1"},
+			}}
+			in.Spec.Durable = true
+			in.Spec.Expiry = &v1beta1.WebSocketChannelExpiry{TTL: ptr.String("PT1M"), DeadLetter: true}
+			in.Spec.FlowControl = &v1beta1.WebSocketChannelFlowControl{
+				MaxInFlight: ptr.Int32(10),
+				QueueLength: ptr.Int32(100),
+				Overflow:    v1beta1.OverflowDeadLetter,
+				Subscribers: []v1beta1.SubscriberFlowControl{{UID: "uid-1", MaxInFlight: ptr.Int32(1)}},
+			}
+			in.Spec.Ordering = v1beta1.OrderingPartitionKey
+			in.Status.SubscriberStats = stats
+			return in
+		},
+	}
+	for name, newChannel := range tests {
+		t.Run(name, func(t *testing.T) {
+			in := newChannel()
+			hub := &WebSocketChannel{}
+			if err := hub.ConvertFrom(context.Background(), in); err != nil {
+				t.Fatal("ConvertFrom() =", err)
+			}
+			if _, ok := hub.Annotations[V1beta1FieldsAnnotation]; !ok {
+				t.Errorf("Annotations = %v, want the %s annotation", hub.Annotations, V1beta1FieldsAnnotation)
+			}
+			if diff := cmp.Diff(newChannel(), in); diff != "" {
+				t.Error("ConvertFrom() changed its source (-want, +got) =", diff)
+			}
+
+			got := &v1beta1.WebSocketChannel{}
+			if err := hub.ConvertTo(context.Background(), got); err != nil {
+				t.Fatal("ConvertTo() =", err)
+			}
+			if diff := cmp.Diff(in, got); diff != "" {
+				t.Error("roundtrip (-want, +got) =", diff)
+			}
+		})
+	}
+}
+
+func TestWebSocketChannelConversionInvalidV1beta1Fields(t *testing.T) {
+	in := &WebSocketChannel{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{V1beta1FieldsAnnotation: "{"},
+	}}
+	if err := in.ConvertTo(context.Background(), &v1beta1.WebSocketChannel{}); err == nil {
+		t.Error("ConvertTo() = nil, wanted error")
+	}
+}
+
+// assertSameJSON checks that a converted object serializes like its source, so no field is lost
+// in the conversion.
+func assertSameJSON(t *testing.T, want, got interface{}) {
+	t.Helper()
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal("Marshal() =", err)
+	}
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal("Marshal() =", err)
+	}
+	if diff := cmp.Diff(string(wantJSON), string(gotJSON)); diff != "" {
+		t.Error("converted JSON (-want, +got) =", diff)
+	}
+}
+
+func conversionTestChannels() map[string]*WebSocketChannel {
+	withIngress := fullWebSocketChannel()
+	withHTTPRoute := fullWebSocketChannel()
+	withHTTPRoute.Spec.Exposure.Ingress = nil
+	withHTTPRoute.Spec.Exposure.HTTPRoute = &HTTPRouteExposure{
+		GatewayName:      "gateway",
+		GatewayNamespace: "gateway-ns",
+		TLS:              true,
+	}
+
+	return map[string]*WebSocketChannel{
+		"empty": {},
+		"min": {
+			ObjectMeta: metav1.ObjectMeta{Name: "channel", Namespace: "ns"},
+		},
+		"full with ingress":   withIngress,
+		"full with httproute": withHTTPRoute,
+	}
+}
+
+// fullWebSocketChannel returns a channel with every field of its spec and status set.
+func fullWebSocketChannel() *WebSocketChannel {
+	linear := eventingduckv1.BackoffPolicyLinear
+	deliverySpec := &eventingduckv1.DeliverySpec{
+		DeadLetterSink: &duckv1.Destination{
+			Ref: &duckv1.KReference{Kind: "Service", Namespace: "ns", Name: "dls", APIVersion: "serving.knative.dev/v1"},
+			URI: apis.HTTP("dls.example.com"),
+		},
+		Retry:         ptr.Int32(5),
+		BackoffPolicy: &linear,
+		BackoffDelay:  ptr.String("PT1S"),
+	}
+	now := metav1.Unix(1600000000, 0)
+
+	return &WebSocketChannel{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "channel",
+			Namespace:   "ns",
+			Generation:  3,
+			Annotations: map[string]string{"messaging.knative.dev/subscribable": "v1"},
+		},
+		Spec: WebSocketChannelSpec{
+			ChannelableSpec: eventingduckv1.ChannelableSpec{
+				SubscribableSpec: eventingduckv1.SubscribableSpec{
+					Subscribers: []eventingduckv1.SubscriberSpec{{
+						UID:           "uid-1",
+						Generation:    2,
+						SubscriberURI: apis.HTTP("subscriber.example.com"),
+						ReplyURI:      apis.HTTP("reply.example.com"),
+						Delivery:      deliverySpec.DeepCopy(),
+					}},
+				},
+				Delivery: deliverySpec.DeepCopy(),
+			},
+			Exposure: &WebSocketChannelExposure{
+				Host: "events.example.com",
+				Ingress: &IngressExposure{
+					ClassName:     ptr.String("nginx"),
+					TLSSecretName: "events-tls",
+				},
+			},
+		},
+		Status: WebSocketChannelStatus{
+			ChannelableStatus: eventingduckv1.ChannelableStatus{
+				Status: duckv1.Status{
+					ObservedGeneration: 3,
+					Conditions: duckv1.Conditions{{
+						Type:               apis.ConditionReady,
+						Status:             corev1.ConditionTrue,
+						LastTransitionTime: apis.VolatileTime{Inner: now},
+					}},
+					Annotations: map[string]string{"key": "value"},
+				},
+				AddressStatus: duckv1.AddressStatus{
+					Address: &duckv1.Addressable{URL: apis.HTTP("channel-kn-channel.ns.svc.cluster.local")},
+				},
+				SubscribableStatus: eventingduckv1.SubscribableStatus{
+					Subscribers: []eventingduckv1.SubscriberStatus{{
+						UID:                "uid-1",
+						ObservedGeneration: 2,
+						Ready:              corev1.ConditionFalse,
+						Message:            "failing",
+					}},
+				},
+				DeadLetterChannel: &duckv1.KReference{Kind: "Service", Namespace: "ns", Name: "dls", APIVersion: "serving.knative.dev/v1"},
+			},
+			DeadLetterSinkURI: apis.HTTP("dls.ns.svc.cluster.local"),
+			ExternalURL:       &apis.URL{Scheme: "https", Host: "events.example.com"},
+			Addresses: []WebSocketChannelAddress{{
+				Name: AddressNameHTTP,
+				URL:  apis.HTTP("channel-kn-channel.ns.svc.cluster.local"),
+			}, {
+				Name: AddressNameExternal,
+				URL:  &apis.URL{Scheme: "https", Host: "events.example.com"},
+			}},
+		},
+	}
+}
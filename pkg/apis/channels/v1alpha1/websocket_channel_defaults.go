@@ -19,25 +19,22 @@ package v1alpha1
 import (
 	"context"
 
-	"knative.dev/eventing/pkg/apis/messaging"
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
 )
 
+// SetDefaults defaults the channel as a v1beta1.WebSocketChannel, see Validate.
 func (wsc *WebSocketChannel) SetDefaults(ctx context.Context) {
-	// Set the duck subscription to the stored version of the duck
-	// we support. Reason for this is that the stored version will
-	// not get a chance to get modified, but for newer versions
-	// conversion webhook will be able to take a crack at it and
-	// can modify it to match the duck shape.
-	if wsc.Annotations == nil {
-		wsc.Annotations = make(map[string]string)
-	}
-	if _, ok := wsc.Annotations[messaging.SubscribableDuckVersionAnnotation]; !ok {
-		wsc.Annotations[messaging.SubscribableDuckVersionAnnotation] = "v1"
-	}
-
-	wsc.Spec.SetDefaults(ctx)
+	sink := &v1beta1.WebSocketChannel{}
+	// Converting to v1beta1 and back never fails.
+	_ = wsc.ConvertTo(ctx, sink)
+	sink.SetDefaults(ctx)
+	_ = wsc.ConvertFrom(ctx, sink)
 }
 
-func (wscs *WebSocketChannelSpec) SetDefaults(_ context.Context) {
-	// TODO: Nothing to default here...
+// SetDefaults defaults the spec as a v1beta1.WebSocketChannelSpec, see Validate.
+func (wscs *WebSocketChannelSpec) SetDefaults(ctx context.Context) {
+	sink := &v1beta1.WebSocketChannelSpec{}
+	wscs.ConvertTo(ctx, sink)
+	sink.SetDefaults(ctx)
+	wscs.ConvertFrom(ctx, sink)
 }
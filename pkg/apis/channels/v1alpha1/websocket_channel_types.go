@@ -26,7 +26,6 @@ import (
 )
 
 // +genclient
-// +genreconciler
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 type WebSocketChannel struct {
@@ -48,6 +47,9 @@ var (
 	_ apis.Validatable = (*WebSocketChannel)(nil)
 	_ apis.Defaultable = (*WebSocketChannel)(nil)
 
+	// Check that WebSocketChannel can be converted between versions.
+	_ apis.Convertible = (*WebSocketChannel)(nil)
+
 	// Check that WebSocketChannel can return its spec untyped.
 	_ apis.HasSpec = (*WebSocketChannel)(nil)
 
@@ -66,12 +68,92 @@ var (
 type WebSocketChannelSpec struct {
 	// Channel conforms to Duck type Channelable.
 	eventingduckv1.ChannelableSpec `json:",inline"`
+
+	// Exposure makes the channel reachable from outside the cluster.
+	// +optional
+	Exposure *WebSocketChannelExposure `json:"exposure,omitempty"`
+}
+
+// WebSocketChannelExposure defines how a WebSocketChannel is exposed outside the cluster.
+// Exactly one of Ingress and HTTPRoute must be set.
+type WebSocketChannelExposure struct {
+	// Host is the external host name the channel is reachable on.
+	Host string `json:"host"`
+
+	// Ingress exposes the channel through an Ingress.
+	// +optional
+	Ingress *IngressExposure `json:"ingress,omitempty"`
+
+	// HTTPRoute exposes the channel through a Gateway API HTTPRoute. Most Gateway implementations
+	// can't route to ExternalName Services, so this requires ClusterIP channel Services.
+	// +optional
+	HTTPRoute *HTTPRouteExposure `json:"httpRoute,omitempty"`
+}
+
+// IngressExposure configures the Ingress exposing a WebSocketChannel.
+type IngressExposure struct {
+	// ClassName is the name of the IngressClass of the Ingress.
+	// +optional
+	ClassName *string `json:"className,omitempty"`
+
+	// TLSSecretName is the name of the Secret holding the certificate of the host.
+	// When set, the channel is exposed over TLS.
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+}
+
+// HTTPRouteExposure configures the Gateway API HTTPRoute exposing a WebSocketChannel.
+type HTTPRouteExposure struct {
+	// GatewayName is the name of the Gateway the HTTPRoute attaches to.
+	GatewayName string `json:"gatewayName"`
+
+	// GatewayNamespace is the namespace of the Gateway. Defaults to the namespace of the channel.
+	// +optional
+	GatewayNamespace string `json:"gatewayNamespace,omitempty"`
+
+	// TLS tells that the Gateway listener for the host terminates TLS.
+	// +optional
+	TLS bool `json:"tls,omitempty"`
 }
 
 // ChannelStatus represents the current state of a Channel.
 type WebSocketChannelStatus struct {
 	// Channel conforms to Duck type Channelable.
 	eventingduckv1.ChannelableStatus `json:",inline"`
+
+	// DeadLetterSinkURI is the resolved URI of spec.delivery.deadLetterSink. Events that
+	// could not be delivered to a subscriber without its own dead letter sink are sent here.
+	// +optional
+	DeadLetterSinkURI *apis.URL `json:"deadLetterSinkUri,omitempty"`
+
+	// ExternalURL is the URL the channel is reachable on from outside the cluster, see spec.exposure.
+	// +optional
+	ExternalURL *apis.URL `json:"externalUrl,omitempty"`
+
+	// Addresses lists every address the channel is reachable on, so clients can pick the one
+	// matching their transport and network. Address holds the internal HTTP address too.
+	// The dispatcher has no WebSocket subscribe endpoint, so no ws or wss address is listed
+	// until it serves WebSocket connections.
+	// +optional
+	Addresses []WebSocketChannelAddress `json:"addresses,omitempty"`
+}
+
+const (
+	// AddressNameHTTP names the cluster-local address events are published on over HTTP.
+	AddressNameHTTP = "http"
+
+	// AddressNameExternal names the address the channel is exposed on outside the cluster.
+	AddressNameExternal = "external"
+)
+
+// WebSocketChannelAddress is a named address of a WebSocketChannel.
+type WebSocketChannelAddress struct {
+	// Name identifies the address, see AddressNameHTTP and AddressNameExternal.
+	Name string `json:"name"`
+
+	// URL of the address.
+	// +optional
+	URL *apis.URL `json:"url,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -18,26 +18,29 @@ package v1alpha1
 
 import (
 	"context"
-	"fmt"
 
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
 	"knative.dev/pkg/apis"
 )
 
+// Validate validates the channel as a v1beta1.WebSocketChannel. v1alpha1 converts to v1beta1
+// without loss, so both versions share a single validation that can't drift apart.
 func (wsc *WebSocketChannel) Validate(ctx context.Context) *apis.FieldError {
-	errs := wsc.Spec.Validate(ctx).ViaField("spec")
-
-	return errs
-}
-
-func (wsc *WebSocketChannelSpec) Validate(_ context.Context) *apis.FieldError {
-	var errs *apis.FieldError
-	for i, subscriber := range wsc.SubscribableSpec.Subscribers {
-		if subscriber.ReplyURI == nil && subscriber.SubscriberURI == nil {
-			fe := apis.ErrMissingField("replyURI", "subscriberURI")
-			fe.Details = "expected at least one of, got none"
-			errs = errs.Also(fe.ViaField(fmt.Sprintf("subscriber[%d]", i)).ViaField("subscribable"))
+	if apis.IsInUpdate(ctx) {
+		original := &v1beta1.WebSocketChannel{}
+		if err := apis.GetBaseline(ctx).(*WebSocketChannel).ConvertTo(ctx, original); err != nil {
+			return &apis.FieldError{Message: err.Error()}
+		}
+		if apis.IsInStatusUpdate(ctx) {
+			ctx = apis.WithinSubResourceUpdate(ctx, original, "status")
+		} else {
+			ctx = apis.WithinUpdate(ctx, original)
 		}
 	}
 
-	return errs
+	sink := &v1beta1.WebSocketChannel{}
+	if err := wsc.ConvertTo(ctx, sink); err != nil {
+		return &apis.FieldError{Message: err.Error()}
+	}
+	return sink.Validate(ctx)
 }
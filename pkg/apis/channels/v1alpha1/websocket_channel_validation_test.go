@@ -0,0 +1,75 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/eventing/pkg/apis/messaging"
+	"knative.dev/pkg/apis"
+)
+
+// The validation and defaults of v1alpha1 are the ones of v1beta1, these tests only cover that
+// v1alpha1 channels get them.
+
+func TestWebSocketChannelValidation(t *testing.T) {
+	tests := map[string]struct {
+		wsc  *WebSocketChannel
+		want string
+	}{
+		"valid": {
+			wsc: &WebSocketChannel{Spec: WebSocketChannelSpec{Exposure: &WebSocketChannelExposure{
+				Host:    "events.example.com",
+				Ingress: &IngressExposure{},
+			}}},
+		},
+		"invalid exposure": {
+			wsc: &WebSocketChannel{Spec: WebSocketChannelSpec{Exposure: &WebSocketChannelExposure{
+				Ingress: &IngressExposure{},
+			}}},
+			want: apis.ErrMissingField("spec.exposure.host").Error(),
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got string
+			if err := test.wsc.Validate(context.Background()); err != nil {
+				got = err.Error()
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Error("Validate (-want, +got) =", diff)
+			}
+		})
+	}
+}
+
+func TestWebSocketChannelDefaults(t *testing.T) {
+	wsc := &WebSocketChannel{}
+	wsc.SetDefaults(context.Background())
+
+	want := &WebSocketChannel{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{messaging.SubscribableDuckVersionAnnotation: "v1"},
+		},
+	}
+	if diff := cmp.Diff(want, wsc); diff != "" {
+		t.Error("SetDefaults (-want, +got) =", diff)
+	}
+}
@@ -22,8 +22,46 @@ package v1alpha1
 
 import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	apis "knative.dev/pkg/apis"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPRouteExposure) DeepCopyInto(out *HTTPRouteExposure) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPRouteExposure.
+func (in *HTTPRouteExposure) DeepCopy() *HTTPRouteExposure {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPRouteExposure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressExposure) DeepCopyInto(out *IngressExposure) {
+	*out = *in
+	if in.ClassName != nil {
+		in, out := &in.ClassName, &out.ClassName
+		*out = new(string)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressExposure.
+func (in *IngressExposure) DeepCopy() *IngressExposure {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressExposure)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WebSocketChannel) DeepCopyInto(out *WebSocketChannel) {
 	*out = *in
@@ -52,6 +90,53 @@ func (in *WebSocketChannel) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketChannelAddress) DeepCopyInto(out *WebSocketChannelAddress) {
+	*out = *in
+	if in.URL != nil {
+		in, out := &in.URL, &out.URL
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketChannelAddress.
+func (in *WebSocketChannelAddress) DeepCopy() *WebSocketChannelAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketChannelAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketChannelExposure) DeepCopyInto(out *WebSocketChannelExposure) {
+	*out = *in
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(IngressExposure)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HTTPRoute != nil {
+		in, out := &in.HTTPRoute, &out.HTTPRoute
+		*out = new(HTTPRouteExposure)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketChannelExposure.
+func (in *WebSocketChannelExposure) DeepCopy() *WebSocketChannelExposure {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketChannelExposure)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WebSocketChannelList) DeepCopyInto(out *WebSocketChannelList) {
 	*out = *in
@@ -89,6 +174,11 @@ func (in *WebSocketChannelList) DeepCopyObject() runtime.Object {
 func (in *WebSocketChannelSpec) DeepCopyInto(out *WebSocketChannelSpec) {
 	*out = *in
 	in.ChannelableSpec.DeepCopyInto(&out.ChannelableSpec)
+	if in.Exposure != nil {
+		in, out := &in.Exposure, &out.Exposure
+		*out = new(WebSocketChannelExposure)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -106,6 +196,23 @@ func (in *WebSocketChannelSpec) DeepCopy() *WebSocketChannelSpec {
 func (in *WebSocketChannelStatus) DeepCopyInto(out *WebSocketChannelStatus) {
 	*out = *in
 	in.ChannelableStatus.DeepCopyInto(&out.ChannelableStatus)
+	if in.DeadLetterSinkURI != nil {
+		in, out := &in.DeadLetterSinkURI, &out.DeadLetterSinkURI
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExternalURL != nil {
+		in, out := &in.ExternalURL, &out.ExternalURL
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]WebSocketChannelAddress, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
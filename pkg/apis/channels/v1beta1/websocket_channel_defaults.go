@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	"knative.dev/eventing/pkg/apis/messaging"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/ptr"
+)
+
+const (
+	// DefaultMaxInFlight is the default number of events delivered to a subscriber at once.
+	DefaultMaxInFlight = 100
+
+	// DefaultQueueLength is the default number of events waiting for a subscriber.
+	DefaultQueueLength = 1000
+)
+
+// httpSchemes maps the WebSocket schemes subscribers and replies may be given with to the
+// HTTP schemes events are delivered with.
+var httpSchemes = map[string]string{
+	"ws":  "http",
+	"wss": "https",
+}
+
+func (wsc *WebSocketChannel) SetDefaults(ctx context.Context) {
+	// Set the duck subscription to the stored version of the duck
+	// we support. Reason for this is that the stored version will
+	// not get a chance to get modified, but for newer versions
+	// conversion webhook will be able to take a crack at it and
+	// can modify it to match the duck shape.
+	if wsc.Annotations == nil {
+		wsc.Annotations = make(map[string]string)
+	}
+	if _, ok := wsc.Annotations[messaging.SubscribableDuckVersionAnnotation]; !ok {
+		wsc.Annotations[messaging.SubscribableDuckVersionAnnotation] = "v1"
+	}
+
+	wsc.Spec.SetDefaults(ctx)
+}
+
+func (wscs *WebSocketChannelSpec) SetDefaults(ctx context.Context) {
+	// The delivery spec is left unset rather than defaulted to the cluster-wide one from
+	// config-websocket-channel, which the reconcilers fall back to, so changes to it apply to
+	// existing channels too.
+
+	for i := range wscs.Subscribers {
+		wscs.Subscribers[i].SubscriberURI = toHTTPScheme(wscs.Subscribers[i].SubscriberURI)
+		wscs.Subscribers[i].ReplyURI = toHTTPScheme(wscs.Subscribers[i].ReplyURI)
+	}
+
+	if wscs.FlowControl != nil {
+		wscs.FlowControl.SetDefaults(ctx)
+	}
+}
+
+func (fc *WebSocketChannelFlowControl) SetDefaults(_ context.Context) {
+	if fc.MaxInFlight == nil {
+		fc.MaxInFlight = ptr.Int32(DefaultMaxInFlight)
+	}
+	if fc.QueueLength == nil {
+		fc.QueueLength = ptr.Int32(DefaultQueueLength)
+	}
+	if fc.Overflow == "" {
+		fc.Overflow = OverflowReject
+	}
+}
+
+// toHTTPScheme returns u with a ws or wss scheme replaced by http or https, keeping the
+// rest of the URI.
+func toHTTPScheme(u *apis.URL) *apis.URL {
+	if u == nil {
+		return nil
+	}
+	scheme, ok := httpSchemes[u.Scheme]
+	if !ok {
+		return u
+	}
+	mapped := *u
+	mapped.Scheme = scheme
+	return &mapped
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"knative.dev/pkg/apis"
+	v1 "knative.dev/pkg/apis/duck/v1"
+)
+
+// wscCondSet holds the conditions of both versions. Only v1beta1 is reconciled, so only its
+// status has the helpers setting them.
+var wscCondSet = apis.NewLivingConditionSet(
+	WebsocketChannelConditionDispatcherReady,
+	WebsocketChannelConditionServiceReady,
+	WebsocketChannelConditionEndpointsReady,
+	WebsocketChannelConditionAddressable,
+	WebsocketChannelConditionChannelServiceReady,
+	WebsocketChannelConditionDeadLetterSinkResolved,
+	WebsocketChannelConditionDispatcherLoaded,
+	WebsocketChannelConditionExposed,
+)
+
+const (
+	// WebsocketChannelConditionReady has status True when all subconditions below have been set to True.
+	WebsocketChannelConditionReady = apis.ConditionReady
+
+	// WebsocketChannelConditionDispatcherReady has status True when a Dispatcher deployment is ready
+	// Keyed off appsv1.DeploymentAvailable, which means minimum available replicas required are up
+	// and running for at least minReadySeconds.
+	WebsocketChannelConditionDispatcherReady apis.ConditionType = "DispatcherReady"
+
+	// WebsocketChannelConditionServiceReady has status True when a k8s Service is ready. This
+	// basically just means it exists because there's no meaningful status in Service. See Endpoints
+	// below.
+	WebsocketChannelConditionServiceReady apis.ConditionType = "ServiceReady"
+
+	// WebsocketChannelConditionEndpointsReady has status True when a k8s Service Endpoints are backed
+	// by at least one endpoint.
+	WebsocketChannelConditionEndpointsReady apis.ConditionType = "EndpointsReady"
+
+	// WebsocketChannelConditionAddressable has status true when this WebSocketChannel meets
+	// the Addressable contract and all of its addresses have a non-empty hostname.
+	WebsocketChannelConditionAddressable apis.ConditionType = "Addressable"
+
+	// WebsocketChannelConditionServiceReady has status True when a k8s Service representing the channel is ready.
+	// The Service is either of type ExternalName, or of type ClusterIP with endpoints mirrored from the dispatcher.
+	WebsocketChannelConditionChannelServiceReady apis.ConditionType = "ChannelServiceReady"
+
+	// WebsocketChannelConditionDeadLetterSinkResolved has status True when the dead letter sink
+	// configured in spec.delivery has been resolved to a URI, or when no dead letter sink is configured.
+	WebsocketChannelConditionDeadLetterSinkResolved apis.ConditionType = "DeadLetterSinkResolved"
+
+	// WebsocketChannelConditionDispatcherLoaded has status True when every dispatcher replica reports
+	// that it has loaded the current generation of the channel, so it accepts events for it.
+	WebsocketChannelConditionDispatcherLoaded apis.ConditionType = "DispatcherLoaded"
+
+	// WebsocketChannelConditionExposed has status True when the Ingress or HTTPRoute exposing the
+	// channel outside the cluster is reconciled, or when the channel is not exposed.
+	WebsocketChannelConditionExposed apis.ConditionType = "Exposed"
+)
+
+// GetConditionSet retrieves the condition set for this resource. Implements the KRShaped interface.
+func (*WebSocketChannel) GetConditionSet() apis.ConditionSet {
+	return wscCondSet
+}
+
+// GetGroupVersionKind returns GroupVersionKind for WebsocketChannels
+func (*WebSocketChannel) GetGroupVersionKind() schema.GroupVersionKind {
+	return SchemeGroupVersion.WithKind("WebSocketChannel")
+}
+
+// GetUntypedSpec returns the spec of the WebSocketChannel.
+func (i *WebSocketChannel) GetUntypedSpec() interface{} {
+	return i.Spec
+}
+
+// GetCondition returns the condition currently associated with the given type, or nil.
+func (wscs *WebSocketChannelStatus) GetCondition(t apis.ConditionType) *apis.Condition {
+	return wscCondSet.Manage(wscs).GetCondition(t)
+}
+
+// IsReady returns true if the resource is ready overall.
+func (wscs *WebSocketChannelStatus) IsReady() bool {
+	return wscCondSet.Manage(wscs).IsHappy()
+}
+
+// InitializeConditions sets relevant unset conditions to Unknown state.
+func (wscs *WebSocketChannelStatus) InitializeConditions() {
+	wscCondSet.Manage(wscs).InitializeConditions()
+}
+
+func (wscs *WebSocketChannelStatus) SetAddress(url *apis.URL) {
+	wscs.Address = &v1.Addressable{URL: url}
+	wscs.updateAddresses()
+}
+
+// updateAddresses lists the internal address and, once the channel is exposed, its external
+// address in Addresses. The channel is Addressable when all of them have a host name.
+func (wscs *WebSocketChannelStatus) updateAddresses() {
+	var addresses []WebSocketChannelAddress
+	if wscs.Address != nil && wscs.Address.URL != nil {
+		addresses = append(addresses, WebSocketChannelAddress{Name: AddressNameHTTP, URL: wscs.Address.URL})
+	}
+	if wscs.ExternalURL != nil && wscCondSet.Manage(wscs).GetCondition(WebsocketChannelConditionExposed).IsTrue() {
+		addresses = append(addresses, WebSocketChannelAddress{Name: AddressNameExternal, URL: wscs.ExternalURL})
+	}
+	wscs.Addresses = addresses
+
+	if len(addresses) == 0 {
+		wscCondSet.Manage(wscs).MarkFalse(WebsocketChannelConditionAddressable, "emptyHostname", "hostname is the empty string")
+		return
+	}
+	for _, address := range addresses {
+		if address.URL.Host == "" {
+			wscCondSet.Manage(wscs).MarkFalse(WebsocketChannelConditionAddressable, "emptyHostname", "hostname of the %s address is the empty string", address.Name)
+			return
+		}
+	}
+	wscCondSet.Manage(wscs).MarkTrue(WebsocketChannelConditionAddressable)
+}
+
+func (wscs *WebSocketChannelStatus) MarkDispatcherFailed(reason, messageFormat string, messageA ...interface{}) {
+	wscCondSet.Manage(wscs).MarkFalse(WebsocketChannelConditionDispatcherReady, reason, messageFormat, messageA...)
+}
+
+func (wscs *WebSocketChannelStatus) MarkDispatcherUnknown(reason, messageFormat string, messageA ...interface{}) {
+	wscCondSet.Manage(wscs).MarkUnknown(WebsocketChannelConditionDispatcherReady, reason, messageFormat, messageA...)
+}
+
+// TODO: Unify this with the ones from Eventing. Say: Broker, Trigger.
+func (wscs *WebSocketChannelStatus) PropagateDispatcherStatus(ds *appsv1.DeploymentStatus) {
+	for _, cond := range ds.Conditions {
+		if cond.Type == appsv1.DeploymentAvailable {
+			if cond.Status == corev1.ConditionTrue {
+				wscCondSet.Manage(wscs).MarkTrue(WebsocketChannelConditionDispatcherReady)
+			} else if cond.Status == corev1.ConditionFalse {
+				wscs.MarkDispatcherFailed("DispatcherDeploymentFalse", "The status of Dispatcher Deployment is False: %s : %s", cond.Reason, cond.Message)
+			} else if cond.Status == corev1.ConditionUnknown {
+				wscs.MarkDispatcherUnknown("DispatcherDeploymentUnknown", "The status of Dispatcher Deployment is Unknown: %s : %s", cond.Reason, cond.Message)
+			}
+		}
+	}
+}
+
+// PropagateDispatcherStatefulSetStatus propagates the status of a dispatcher StatefulSet, used
+// instead of a Deployment when the dispatcher replicas keep the logs of durable channels.
+func (wscs *WebSocketChannelStatus) PropagateDispatcherStatefulSetStatus(ss *appsv1.StatefulSet) {
+	replicas := int32(1)
+	if ss.Spec.Replicas != nil {
+		replicas = *ss.Spec.Replicas
+	}
+	switch {
+	case ss.Status.ReadyReplicas > 0:
+		wscCondSet.Manage(wscs).MarkTrue(WebsocketChannelConditionDispatcherReady)
+	case replicas == 0:
+		wscs.MarkDispatcherFailed("DispatcherStatefulSetScaledDown", "The dispatcher StatefulSet has no replicas")
+	default:
+		wscs.MarkDispatcherUnknown("DispatcherStatefulSetNotReady", "None of the %d replicas of the dispatcher StatefulSet is ready", replicas)
+	}
+}
+
+func (wscs *WebSocketChannelStatus) MarkServiceFailed(reason, messageFormat string, messageA ...interface{}) {
+	wscCondSet.Manage(wscs).MarkFalse(WebsocketChannelConditionServiceReady, reason, messageFormat, messageA...)
+}
+
+func (wscs *WebSocketChannelStatus) MarkServiceUnknown(reason, messageFormat string, messageA ...interface{}) {
+	wscCondSet.Manage(wscs).MarkUnknown(WebsocketChannelConditionServiceReady, reason, messageFormat, messageA...)
+}
+
+func (wscs *WebSocketChannelStatus) MarkServiceTrue() {
+	wscCondSet.Manage(wscs).MarkTrue(WebsocketChannelConditionServiceReady)
+}
+
+func (wscs *WebSocketChannelStatus) MarkChannelServiceFailed(reason, messageFormat string, messageA ...interface{}) {
+	wscCondSet.Manage(wscs).MarkFalse(WebsocketChannelConditionChannelServiceReady, reason, messageFormat, messageA...)
+}
+
+func (wscs *WebSocketChannelStatus) MarkChannelServiceUnknown(reason, messageFormat string, messageA ...interface{}) {
+	wscCondSet.Manage(wscs).MarkUnknown(WebsocketChannelConditionChannelServiceReady, reason, messageFormat, messageA...)
+}
+
+func (wscs *WebSocketChannelStatus) MarkChannelServiceTrue() {
+	wscCondSet.Manage(wscs).MarkTrue(WebsocketChannelConditionChannelServiceReady)
+}
+
+func (wscs *WebSocketChannelStatus) MarkEndpointsFailed(reason, messageFormat string, messageA ...interface{}) {
+	wscCondSet.Manage(wscs).MarkFalse(WebsocketChannelConditionEndpointsReady, reason, messageFormat, messageA...)
+}
+
+func (wscs *WebSocketChannelStatus) MarkEndpointsUnknown(reason, messageFormat string, messageA ...interface{}) {
+	wscCondSet.Manage(wscs).MarkUnknown(WebsocketChannelConditionEndpointsReady, reason, messageFormat, messageA...)
+}
+
+func (wscs *WebSocketChannelStatus) MarkEndpointsTrue() {
+	wscCondSet.Manage(wscs).MarkTrue(WebsocketChannelConditionEndpointsReady)
+}
+
+func (wscs *WebSocketChannelStatus) MarkDeadLetterSinkNotConfigured() {
+	wscs.DeadLetterSinkURI = nil
+	wscs.DeadLetterChannel = nil
+	wscCondSet.Manage(wscs).MarkTrueWithReason(WebsocketChannelConditionDeadLetterSinkResolved, "DeadLetterSinkNotConfigured", "No dead letter sink is configured.")
+}
+
+// MarkDeadLetterSinkResolvedSucceeded records the resolved dead letter sink. dlc is only set when the
+// dead letter sink is itself a WebSocketChannel, so tooling can discover the native dead letter channel.
+func (wscs *WebSocketChannelStatus) MarkDeadLetterSinkResolvedSucceeded(uri *apis.URL, dlc *v1.KReference) {
+	wscs.DeadLetterSinkURI = uri
+	wscs.DeadLetterChannel = dlc
+	wscCondSet.Manage(wscs).MarkTrue(WebsocketChannelConditionDeadLetterSinkResolved)
+}
+
+func (wscs *WebSocketChannelStatus) MarkDeadLetterSinkResolvedFailed(reason, messageFormat string, messageA ...interface{}) {
+	wscs.DeadLetterSinkURI = nil
+	wscs.DeadLetterChannel = nil
+	wscCondSet.Manage(wscs).MarkFalse(WebsocketChannelConditionDeadLetterSinkResolved, reason, messageFormat, messageA...)
+}
+
+func (wscs *WebSocketChannelStatus) MarkDispatcherLoadedUnknown(reason, messageFormat string, messageA ...interface{}) {
+	wscCondSet.Manage(wscs).MarkUnknown(WebsocketChannelConditionDispatcherLoaded, reason, messageFormat, messageA...)
+}
+
+func (wscs *WebSocketChannelStatus) MarkDispatcherLoadedTrue() {
+	wscCondSet.Manage(wscs).MarkTrue(WebsocketChannelConditionDispatcherLoaded)
+}
+
+func (wscs *WebSocketChannelStatus) MarkExposureNotConfigured() {
+	wscs.ExternalURL = nil
+	wscCondSet.Manage(wscs).MarkTrueWithReason(WebsocketChannelConditionExposed, "ExposureNotConfigured", "The channel is not exposed outside the cluster.")
+	wscs.updateAddresses()
+}
+
+func (wscs *WebSocketChannelStatus) MarkExposed(url *apis.URL) {
+	wscs.ExternalURL = url
+	wscCondSet.Manage(wscs).MarkTrue(WebsocketChannelConditionExposed)
+	wscs.updateAddresses()
+}
+
+// MarkExposureFailed keeps the external URL, so resources left over from a previous exposure are
+// still cleaned up on the next reconciliation.
+func (wscs *WebSocketChannelStatus) MarkExposureFailed(reason, messageFormat string, messageA ...interface{}) {
+	wscCondSet.Manage(wscs).MarkFalse(WebsocketChannelConditionExposed, reason, messageFormat, messageA...)
+	wscs.updateAddresses()
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/kmeta"
+)
+
+// +genclient
+// +genreconciler
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type WebSocketChannel struct {
+	metav1.TypeMeta `json:",inline"`
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec defines the desired state of the Channel.
+	Spec WebSocketChannelSpec `json:"spec,omitempty"`
+
+	// Status represents the current state of the Channel. This data may be out of
+	// date.
+	// +optional
+	Status WebSocketChannelStatus `json:"status,omitempty"`
+}
+
+var (
+	// Check that WebSocketChannel can be validated and defaulted.
+	_ apis.Validatable = (*WebSocketChannel)(nil)
+	_ apis.Defaultable = (*WebSocketChannel)(nil)
+
+	// Check that WebSocketChannel can be converted between versions.
+	_ apis.Convertible = (*WebSocketChannel)(nil)
+
+	// Check that WebSocketChannel can return its spec untyped.
+	_ apis.HasSpec = (*WebSocketChannel)(nil)
+
+	_ runtime.Object = (*WebSocketChannel)(nil)
+
+	// Check that we can create OwnerReferences to an WebSocketChannel.
+	_ kmeta.OwnerRefable = (*WebSocketChannel)(nil)
+
+	// Check that the type conforms to the duck Knative Resource shape.
+	_ duckv1.KRShaped = (*WebSocketChannel)(nil)
+)
+
+// WebSocketChannelSpec defines which subscribers have expressed interest in
+// receiving events from this WebSocketChannel.
+// arguments for a Channel.
+type WebSocketChannelSpec struct {
+	// Channel conforms to Duck type Channelable.
+	eventingduckv1.ChannelableSpec `json:",inline"`
+
+	// Exposure makes the channel reachable from outside the cluster.
+	// +optional
+	Exposure *WebSocketChannelExposure `json:"exposure,omitempty"`
+
+	// Durable makes the dispatcher append every accepted event to a write-ahead log on disk before
+	// acknowledging it, and deliver the events to each subscriber in order from that log. Events
+	// survive a crash of the dispatcher replica that accepted them, and are delivered at least once.
+	// An event that fails after the retries of a subscription goes to its dead letter sink, or is
+	// given up. It requires the dispatcher replicas to have a persistent volume, and can't be changed.
+	// +optional
+	Durable bool `json:"durable,omitempty"`
+
+	// Expiry drops events that could not be delivered in time, instead of retrying them. Events
+	// setting a time to live in their ttl extension attribute expire even without it.
+	// +optional
+	Expiry *WebSocketChannelExpiry `json:"expiry,omitempty"`
+
+	// FlowControl bounds the events the dispatcher holds for every subscriber, so a slow
+	// subscriber can't pile them up. Durable channels deliver the events of their log to every
+	// subscriber one at a time, and ignore it.
+	// +optional
+	FlowControl *WebSocketChannelFlowControl `json:"flowControl,omitempty"`
+
+	// Ordering is the order the events are delivered to every subscriber in: Unordered or
+	// PartitionKey. Defaults to Unordered.
+	// +optional
+	Ordering DeliveryOrdering `json:"ordering,omitempty"`
+}
+
+// DeliveryOrdering is the order the events of a channel are delivered to every subscriber in.
+type DeliveryOrdering string
+
+const (
+	// OrderingUnordered delivers every event independently of the others.
+	OrderingUnordered DeliveryOrdering = "Unordered"
+
+	// OrderingPartitionKey delivers the events sharing a partition key one at a time, retries
+	// included, in the order the dispatcher replica accepted them. Events with different keys are
+	// still delivered in parallel. The partition key of an event is its partitionkey extension
+	// attribute, or its subject if it has none; events with neither are not ordered. The events of
+	// a key have to be published to the same dispatcher replica to be ordered with each other.
+	OrderingPartitionKey DeliveryOrdering = "PartitionKey"
+)
+
+// OverflowPolicy is what happens to an event a subscriber has no room for.
+type OverflowPolicy string
+
+const (
+	// OverflowReject rejects the event with 503 Service Unavailable if any subscriber has no room
+	// for it, so no subscriber gets it.
+	OverflowReject OverflowPolicy = "Reject"
+
+	// OverflowDeadLetter accepts the event, and sends it to the dead letter sink of the subscribers
+	// that have no room for it, with the knativeerrorcode extension attribute set to "overflow".
+	// It is dropped for those without a dead letter sink.
+	OverflowDeadLetter OverflowPolicy = "DeadLetter"
+)
+
+// WebSocketChannelFlowControl bounds the events the dispatcher holds for every subscriber of a WebSocketChannel.
+type WebSocketChannelFlowControl struct {
+	// MaxInFlight is the number of events delivered to a subscriber at once, retries included.
+	// Defaults to 100.
+	// +optional
+	MaxInFlight *int32 `json:"maxInFlight,omitempty"`
+
+	// QueueLength is the number of events waiting for a subscriber on top of the ones in flight.
+	// Defaults to 1000.
+	// +optional
+	QueueLength *int32 `json:"queueLength,omitempty"`
+
+	// Overflow is what happens to an event a subscriber has no room for: Reject or DeadLetter.
+	// Defaults to Reject.
+	// +optional
+	Overflow OverflowPolicy `json:"overflow,omitempty"`
+
+	// Subscribers overrides the limits for single subscribers.
+	// +optional
+	Subscribers []SubscriberFlowControl `json:"subscribers,omitempty"`
+}
+
+// SubscriberFlowControl overrides the flow control limits of the channel for a single subscriber.
+type SubscriberFlowControl struct {
+	// UID of the subscriber, matching the entry in spec.subscribers.
+	UID types.UID `json:"uid"`
+
+	// MaxInFlight overrides the maxInFlight of the channel.
+	// +optional
+	MaxInFlight *int32 `json:"maxInFlight,omitempty"`
+
+	// QueueLength overrides the queueLength of the channel.
+	// +optional
+	QueueLength *int32 `json:"queueLength,omitempty"`
+}
+
+// WebSocketChannelExpiry configures when the events of a WebSocketChannel expire.
+type WebSocketChannelExpiry struct {
+	// TTL is the time to live of the events, as an ISO 8601 duration. It is counted from the time
+	// attribute of an event, or from its first delivery attempt if it has none. An event can set
+	// a shorter time to live in its ttl extension attribute, also as an ISO 8601 duration.
+	// +optional
+	TTL *string `json:"ttl,omitempty"`
+
+	// DeadLetter sends expired events to the dead letter sink, with the knativeerrorcode extension
+	// attribute set to "expired". Expired events are dropped otherwise.
+	// +optional
+	DeadLetter bool `json:"deadLetter,omitempty"`
+}
+
+// WebSocketChannelExposure defines how a WebSocketChannel is exposed outside the cluster.
+// Exactly one of Ingress and HTTPRoute must be set.
+type WebSocketChannelExposure struct {
+	// Host is the external host name the channel is reachable on.
+	Host string `json:"host"`
+
+	// Ingress exposes the channel through an Ingress.
+	// +optional
+	Ingress *IngressExposure `json:"ingress,omitempty"`
+
+	// HTTPRoute exposes the channel through a Gateway API HTTPRoute. Most Gateway implementations
+	// can't route to ExternalName Services, so this requires ClusterIP channel Services.
+	// +optional
+	HTTPRoute *HTTPRouteExposure `json:"httpRoute,omitempty"`
+}
+
+// IngressExposure configures the Ingress exposing a WebSocketChannel.
+type IngressExposure struct {
+	// ClassName is the name of the IngressClass of the Ingress.
+	// +optional
+	ClassName *string `json:"className,omitempty"`
+
+	// TLSSecretName is the name of the Secret holding the certificate of the host.
+	// When set, the channel is exposed over TLS.
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+}
+
+// HTTPRouteExposure configures the Gateway API HTTPRoute exposing a WebSocketChannel.
+type HTTPRouteExposure struct {
+	// GatewayName is the name of the Gateway the HTTPRoute attaches to.
+	GatewayName string `json:"gatewayName"`
+
+	// GatewayNamespace is the namespace of the Gateway. Defaults to the namespace of the channel.
+	// +optional
+	GatewayNamespace string `json:"gatewayNamespace,omitempty"`
+
+	// TLS tells that the Gateway listener for the host terminates TLS.
+	// +optional
+	TLS bool `json:"tls,omitempty"`
+}
+
+// ChannelStatus represents the current state of a Channel.
+type WebSocketChannelStatus struct {
+	// Channel conforms to Duck type Channelable.
+	eventingduckv1.ChannelableStatus `json:",inline"`
+
+	// DeadLetterSinkURI is the resolved URI of spec.delivery.deadLetterSink. Events that
+	// could not be delivered to a subscriber without its own dead letter sink are sent here.
+	// +optional
+	DeadLetterSinkURI *apis.URL `json:"deadLetterSinkUri,omitempty"`
+
+	// ExternalURL is the URL the channel is reachable on from outside the cluster, see spec.exposure.
+	// +optional
+	ExternalURL *apis.URL `json:"externalUrl,omitempty"`
+
+	// Addresses lists every address the channel is reachable on, so clients can pick the one
+	// matching their transport and network. Address holds the internal HTTP address too.
+	// +optional
+	Addresses []WebSocketChannelAddress `json:"addresses,omitempty"`
+
+	// SubscriberStats holds the delivery statistics of every subscriber in status.subscribers,
+	// aggregated over all dispatcher replicas. It is refreshed periodically rather than on every
+	// delivery, so it lags behind the subscriber readiness.
+	// +optional
+	SubscriberStats []SubscriberDeliveryStats `json:"subscriberStats,omitempty"`
+}
+
+const (
+	// AddressNameHTTP names the cluster-local address events are published on over HTTP.
+	AddressNameHTTP = "http"
+
+	// AddressNameExternal names the address the channel is exposed on outside the cluster.
+	AddressNameExternal = "external"
+)
+
+// WebSocketChannelAddress is a named address of a WebSocketChannel.
+type WebSocketChannelAddress struct {
+	// Name identifies the address, see AddressNameHTTP and AddressNameExternal.
+	Name string `json:"name"`
+
+	// URL of the address.
+	// +optional
+	URL *apis.URL `json:"url,omitempty"`
+}
+
+// SubscriberDeliveryStats are the delivery statistics of a single subscriber of a WebSocketChannel.
+type SubscriberDeliveryStats struct {
+	// UID of the subscriber, matching the entry in status.subscribers.
+	UID types.UID `json:"uid"`
+
+	// LastSuccessTime is the time of the last successful delivery to the subscriber.
+	// +optional
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+
+	// LastFailureTime is the time of the last failed delivery to the subscriber, after all retries.
+	// +optional
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
+
+	// LastFailureReason describes why the last failed delivery failed.
+	// +optional
+	LastFailureReason string `json:"lastFailureReason,omitempty"`
+
+	// ConsecutiveFailures is the number of deliveries that failed since the last successful one.
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// DeadLettered is the number of events sent to the dead letter sink instead of the subscriber
+	// since the dispatcher replicas started.
+	// +optional
+	DeadLettered int64 `json:"deadLettered,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// WebSocketChannelList is a collection of WebsocketChannels.
+type WebSocketChannelList struct {
+	metav1.TypeMeta `json:",inline"`
+	// +optional
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WebSocketChannel `json:"items"`
+}
+
+// GetStatus retrieves the status of the WebSocketChannel. Implements the KRShaped interface.
+func (wsc *WebSocketChannel) GetStatus() *duckv1.Status {
+	return &wsc.Status.Status
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/rickb777/date/period"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/kmeta"
+	"knative.dev/pkg/network"
+)
+
+// allowedSchemes are the schemes subscribers and replies can be given with. SetDefaults maps
+// ws and wss to http and https, the schemes events are delivered with.
+var allowedSchemes = sets.NewString("http", "https", "ws", "wss")
+
+func (wsc *WebSocketChannel) Validate(ctx context.Context) *apis.FieldError {
+	errs := wsc.Spec.Validate(ctx).ViaField("spec")
+
+	// Subscribers or replies sending events back to the channel would loop forever.
+	hostNames := wsc.hostNames()
+	for i, subscriber := range wsc.Spec.Subscribers {
+		if isAnyHost(subscriber.SubscriberURI, hostNames) {
+			errs = errs.Also(errSelfLoop(subscriber.SubscriberURI, "subscriberUri").ViaFieldIndex("subscribers", i).ViaField("spec"))
+		}
+		if isAnyHost(subscriber.ReplyURI, hostNames) {
+			errs = errs.Also(errSelfLoop(subscriber.ReplyURI, "replyUri").ViaFieldIndex("subscribers", i).ViaField("spec"))
+		}
+	}
+
+	if apis.IsInUpdate(ctx) {
+		original := apis.GetBaseline(ctx).(*WebSocketChannel)
+		errs = errs.Also(wsc.CheckImmutableFields(ctx, original))
+	}
+
+	return errs
+}
+
+// CheckImmutableFields checks that the fields that can't change once the channel is created are
+// unchanged. The external host of an exposed channel can't change, as clients and DNS records
+// point at it. The exposure can be removed and added back with another host instead. A channel
+// can't become durable or stop being durable, as the events already accepted would be lost.
+func (wsc *WebSocketChannel) CheckImmutableFields(_ context.Context, original *WebSocketChannel) *apis.FieldError {
+	if original == nil {
+		return nil
+	}
+
+	var errs *apis.FieldError
+	if original.Spec.Durable != wsc.Spec.Durable {
+		errs = errs.Also((&apis.FieldError{
+			Message: "Immutable field changed",
+			Paths:   []string{"durable"},
+			Details: fmt.Sprintf("%t cannot be changed to %t", original.Spec.Durable, wsc.Spec.Durable),
+		}).ViaField("spec"))
+	}
+
+	if original.Spec.Exposure != nil && wsc.Spec.Exposure != nil && original.Spec.Exposure.Host != wsc.Spec.Exposure.Host {
+		errs = errs.Also((&apis.FieldError{
+			Message: "Immutable field changed",
+			Paths:   []string{"host"},
+			Details: fmt.Sprintf("%q cannot be changed to %q, remove spec.exposure first to expose the channel on another host",
+				original.Spec.Exposure.Host, wsc.Spec.Exposure.Host),
+		}).ViaField("exposure").ViaField("spec"))
+	}
+	return errs
+}
+
+func (wsc *WebSocketChannelSpec) Validate(ctx context.Context) *apis.FieldError {
+	var errs *apis.FieldError
+
+	if wsc.Delivery != nil {
+		errs = errs.Also(wsc.Delivery.Validate(ctx).ViaField("delivery"))
+	}
+
+	uids := make(map[types.UID]int, len(wsc.Subscribers))
+	for i, subscriber := range wsc.Subscribers {
+		if subscriber.ReplyURI == nil && subscriber.SubscriberURI == nil {
+			fe := apis.ErrMissingField("replyUri", "subscriberUri")
+			fe.Details = "expected at least one of, got none"
+			errs = errs.Also(fe.ViaIndex(i).ViaField("subscribers"))
+		}
+		errs = errs.Also(validateScheme(subscriber.SubscriberURI, "subscriberUri").ViaIndex(i).ViaField("subscribers"))
+		errs = errs.Also(validateScheme(subscriber.ReplyURI, "replyUri").ViaIndex(i).ViaField("subscribers"))
+
+		if subscriber.Delivery != nil {
+			errs = errs.Also(subscriber.Delivery.Validate(ctx).ViaField("delivery").ViaIndex(i).ViaField("subscribers"))
+		}
+
+		if subscriber.UID != "" {
+			if first, ok := uids[subscriber.UID]; ok {
+				fe := apis.ErrInvalidValue(subscriber.UID, "uid")
+				fe.Details = fmt.Sprintf("duplicate of spec.subscribers[%d].uid", first)
+				errs = errs.Also(fe.ViaIndex(i).ViaField("subscribers"))
+			} else {
+				uids[subscriber.UID] = i
+			}
+		}
+	}
+
+	if wsc.Expiry != nil {
+		errs = errs.Also(wsc.Expiry.Validate(ctx).ViaField("expiry"))
+	}
+
+	if wsc.FlowControl != nil {
+		errs = errs.Also(wsc.FlowControl.Validate(ctx).ViaField("flowControl"))
+	}
+
+	switch wsc.Ordering {
+	case "", OrderingUnordered, OrderingPartitionKey:
+	default:
+		errs = errs.Also(apis.ErrInvalidValue(wsc.Ordering, "ordering"))
+	}
+
+	if wsc.Exposure != nil {
+		errs = errs.Also(wsc.Exposure.Validate(ctx).ViaField("exposure"))
+	}
+
+	return errs
+}
+
+func validateScheme(u *apis.URL, field string) *apis.FieldError {
+	if u == nil || allowedSchemes.Has(u.Scheme) {
+		return nil
+	}
+	fe := apis.ErrInvalidValue(u.String(), field)
+	fe.Details = fmt.Sprintf("scheme must be one of %s", strings.Join(allowedSchemes.List(), ", "))
+	return fe
+}
+
+// hostNames returns the host names the channel is reachable on: the ones of the Service the
+// controller creates for it, its current address and the host it is exposed on.
+func (wsc *WebSocketChannel) hostNames() sets.String {
+	svc := kmeta.ChildName(wsc.Name, "-websocket-channel")
+	hostNames := sets.NewString(
+		svc+"."+wsc.Namespace,
+		svc+"."+wsc.Namespace+".svc",
+		network.GetServiceHostname(svc, wsc.Namespace),
+	)
+	if wsc.Status.Address != nil && wsc.Status.Address.URL != nil {
+		hostNames.Insert(wsc.Status.Address.URL.URL().Hostname())
+	}
+	if wsc.Spec.Exposure != nil && wsc.Spec.Exposure.Host != "" {
+		hostNames.Insert(wsc.Spec.Exposure.Host)
+	}
+	return hostNames
+}
+
+func isAnyHost(u *apis.URL, hostNames sets.String) bool {
+	return u != nil && hostNames.Has(u.URL().Hostname())
+}
+
+func errSelfLoop(u *apis.URL, field string) *apis.FieldError {
+	fe := apis.ErrInvalidValue(u.String(), field)
+	fe.Details = "points back at the channel itself"
+	return fe
+}
+
+func (e *WebSocketChannelExposure) Validate(_ context.Context) *apis.FieldError {
+	var errs *apis.FieldError
+	if e.Host == "" {
+		errs = errs.Also(apis.ErrMissingField("host"))
+	} else if msgs := validation.IsDNS1123Subdomain(e.Host); len(msgs) > 0 {
+		fe := apis.ErrInvalidValue(e.Host, "host")
+		fe.Details = strings.Join(msgs, ", ")
+		errs = errs.Also(fe)
+	} else if clusterDomain := network.GetClusterDomainName(); e.Host == clusterDomain || strings.HasSuffix(e.Host, "."+clusterDomain) {
+		// The hosts of the cluster domain are the internal hosts of channels and other services.
+		fe := apis.ErrInvalidValue(e.Host, "host")
+		fe.Details = fmt.Sprintf("must not be under the cluster domain %q", clusterDomain)
+		errs = errs.Also(fe)
+	}
+
+	switch {
+	case e.Ingress == nil && e.HTTPRoute == nil:
+		errs = errs.Also(apis.ErrMissingOneOf("ingress", "httpRoute"))
+	case e.Ingress != nil && e.HTTPRoute != nil:
+		errs = errs.Also(apis.ErrMultipleOneOf("ingress", "httpRoute"))
+	case e.HTTPRoute != nil && e.HTTPRoute.GatewayName == "":
+		errs = errs.Also(apis.ErrMissingField("gatewayName").ViaField("httpRoute"))
+	}
+	return errs
+}
+
+func (e *WebSocketChannelExpiry) Validate(_ context.Context) *apis.FieldError {
+	if e.TTL == nil {
+		return nil
+	}
+	ttl, err := period.Parse(*e.TTL)
+	if err != nil {
+		return apis.ErrInvalidValue(*e.TTL, "ttl")
+	}
+	if d, _ := ttl.Duration(); d <= 0 {
+		fe := apis.ErrInvalidValue(*e.TTL, "ttl")
+		fe.Details = "expected a positive duration"
+		return fe
+	}
+	return nil
+}
+
+func (fc *WebSocketChannelFlowControl) Validate(_ context.Context) *apis.FieldError {
+	errs := validateFlowControlLimits(fc.MaxInFlight, fc.QueueLength)
+
+	switch fc.Overflow {
+	case "", OverflowReject, OverflowDeadLetter:
+	default:
+		errs = errs.Also(apis.ErrInvalidValue(fc.Overflow, "overflow"))
+	}
+
+	uids := make(map[types.UID]int, len(fc.Subscribers))
+	for i, sub := range fc.Subscribers {
+		if sub.UID == "" {
+			errs = errs.Also(apis.ErrMissingField("uid").ViaIndex(i).ViaField("subscribers"))
+		} else if first, ok := uids[sub.UID]; ok {
+			fe := apis.ErrInvalidValue(sub.UID, "uid")
+			fe.Details = fmt.Sprintf("duplicate of subscribers[%d].uid", first)
+			errs = errs.Also(fe.ViaIndex(i).ViaField("subscribers"))
+		} else {
+			uids[sub.UID] = i
+		}
+		errs = errs.Also(validateFlowControlLimits(sub.MaxInFlight, sub.QueueLength).ViaIndex(i).ViaField("subscribers"))
+	}
+	return errs
+}
+
+func validateFlowControlLimits(maxInFlight, queueLength *int32) *apis.FieldError {
+	var errs *apis.FieldError
+	if maxInFlight != nil && *maxInFlight < 1 {
+		errs = errs.Also(apis.ErrOutOfBoundsValue(*maxInFlight, 1, math.MaxInt32, "maxInFlight"))
+	}
+	if queueLength != nil && *queueLength < 0 {
+		errs = errs.Also(apis.ErrOutOfBoundsValue(*queueLength, 0, math.MaxInt32, "queueLength"))
+	}
+	return errs
+}
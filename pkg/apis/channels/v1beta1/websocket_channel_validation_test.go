@@ -0,0 +1,278 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/eventing/pkg/apis/messaging"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/ptr"
+)
+
+func mustParseURL(t *testing.T, raw string) *apis.URL {
+	t.Helper()
+	u, err := apis.ParseURL(raw)
+	if err != nil {
+		t.Fatal("ParseURL() =", err)
+	}
+	return u
+}
+
+func withSubscribers(subscribers ...eventingduckv1.SubscriberSpec) *WebSocketChannel {
+	wsc := &WebSocketChannel{ObjectMeta: metav1.ObjectMeta{Name: "channel", Namespace: "ns"}}
+	wsc.Spec.Subscribers = subscribers
+	return wsc
+}
+
+func TestWebSocketChannelValidation(t *testing.T) {
+	tests := map[string]struct {
+		wsc  func(t *testing.T) *WebSocketChannel
+		want *apis.FieldError
+	}{
+		"empty": {
+			wsc: func(*testing.T) *WebSocketChannel { return withSubscribers() },
+		},
+		"valid subscribers": {
+			wsc: func(t *testing.T) *WebSocketChannel {
+				return withSubscribers(
+					eventingduckv1.SubscriberSpec{UID: "1", SubscriberURI: mustParseURL(t, "http://subscriber.ns.svc.cluster.local/")},
+					eventingduckv1.SubscriberSpec{UID: "2", SubscriberURI: mustParseURL(t, "wss://subscriber.example.com/events")},
+					eventingduckv1.SubscriberSpec{UID: "3", ReplyURI: mustParseURL(t, "https://reply.example.com/")},
+				)
+			},
+		},
+		"subscriber without URIs": {
+			wsc: func(*testing.T) *WebSocketChannel {
+				return withSubscribers(eventingduckv1.SubscriberSpec{UID: "1"})
+			},
+			want: func() *apis.FieldError {
+				fe := apis.ErrMissingField("spec.subscribers[0].replyUri", "spec.subscribers[0].subscriberUri")
+				fe.Details = "expected at least one of, got none"
+				return fe
+			}(),
+		},
+		"unsupported schemes": {
+			wsc: func(t *testing.T) *WebSocketChannel {
+				return withSubscribers(eventingduckv1.SubscriberSpec{
+					UID:           "1",
+					SubscriberURI: mustParseURL(t, "ftp://subscriber.example.com/"),
+					ReplyURI:      mustParseURL(t, "kafka://reply.example.com/"),
+				})
+			},
+			want: (&apis.FieldError{
+				Message: "invalid value: ftp://subscriber.example.com/",
+				Paths:   []string{"spec.subscribers[0].subscriberUri"},
+				Details: "scheme must be one of http, https, ws, wss",
+			}).Also(&apis.FieldError{
+				Message: "invalid value: kafka://reply.example.com/",
+				Paths:   []string{"spec.subscribers[0].replyUri"},
+				Details: "scheme must be one of http, https, ws, wss",
+			}),
+		},
+		"duplicate subscriber UIDs": {
+			wsc: func(t *testing.T) *WebSocketChannel {
+				return withSubscribers(
+					eventingduckv1.SubscriberSpec{UID: "1", SubscriberURI: mustParseURL(t, "http://a.example.com/")},
+					eventingduckv1.SubscriberSpec{UID: "2", SubscriberURI: mustParseURL(t, "http://b.example.com/")},
+					eventingduckv1.SubscriberSpec{UID: "1", SubscriberURI: mustParseURL(t, "http://c.example.com/")},
+				)
+			},
+			want: &apis.FieldError{
+				Message: "invalid value: 1",
+				Paths:   []string{"spec.subscribers[2].uid"},
+				Details: "duplicate of spec.subscribers[0].uid",
+			},
+		},
+		"subscriber pointing at the channel service": {
+			wsc: func(t *testing.T) *WebSocketChannel {
+				return withSubscribers(eventingduckv1.SubscriberSpec{
+					UID:           "1",
+					SubscriberURI: mustParseURL(t, "http://channel-websocket-channel.ns.svc.cluster.local/"),
+				})
+			},
+			want: &apis.FieldError{
+				Message: "invalid value: http://channel-websocket-channel.ns.svc.cluster.local/",
+				Paths:   []string{"spec.subscribers[0].subscriberUri"},
+				Details: "points back at the channel itself",
+			},
+		},
+		"reply pointing at the channel address": {
+			wsc: func(t *testing.T) *WebSocketChannel {
+				wsc := withSubscribers(eventingduckv1.SubscriberSpec{
+					UID:           "1",
+					SubscriberURI: mustParseURL(t, "http://subscriber.example.com/"),
+					ReplyURI:      mustParseURL(t, "http://dispatcher.knative-eventing.svc.cluster.local/ns/channel"),
+				})
+				wsc.Status.Address = &duckv1.Addressable{URL: mustParseURL(t, "http://dispatcher.knative-eventing.svc.cluster.local/ns/channel")}
+				return wsc
+			},
+			want: &apis.FieldError{
+				Message: "invalid value: http://dispatcher.knative-eventing.svc.cluster.local/ns/channel",
+				Paths:   []string{"spec.subscribers[0].replyUri"},
+				Details: "points back at the channel itself",
+			},
+		},
+		"subscriber pointing at the exposed host": {
+			wsc: func(t *testing.T) *WebSocketChannel {
+				wsc := withSubscribers(eventingduckv1.SubscriberSpec{
+					UID:           "1",
+					SubscriberURI: mustParseURL(t, "https://events.example.com/"),
+				})
+				wsc.Spec.Exposure = &WebSocketChannelExposure{Host: "events.example.com", Ingress: &IngressExposure{}}
+				return wsc
+			},
+			want: &apis.FieldError{
+				Message: "invalid value: https://events.example.com/",
+				Paths:   []string{"spec.subscribers[0].subscriberUri"},
+				Details: "points back at the channel itself",
+			},
+		},
+		"invalid channel delivery": {
+			wsc: func(*testing.T) *WebSocketChannel {
+				wsc := withSubscribers()
+				wsc.Spec.Delivery = &eventingduckv1.DeliverySpec{Retry: ptr.Int32(-1)}
+				return wsc
+			},
+			want: apis.ErrInvalidValue(int32(-1), "spec.delivery.retry"),
+		},
+		"invalid subscriber delivery": {
+			wsc: func(t *testing.T) *WebSocketChannel {
+				return withSubscribers(eventingduckv1.SubscriberSpec{
+					UID:           "1",
+					SubscriberURI: mustParseURL(t, "http://subscriber.example.com/"),
+					Delivery:      &eventingduckv1.DeliverySpec{BackoffDelay: ptr.String("soon")},
+				})
+			},
+			want: apis.ErrInvalidValue("soon", "spec.subscribers[0].delivery.backoffDelay"),
+		},
+		"exposure host under the cluster domain": {
+			wsc: func(*testing.T) *WebSocketChannel {
+				wsc := withSubscribers()
+				wsc.Spec.Exposure = &WebSocketChannelExposure{Host: "events.ns.svc.cluster.local", Ingress: &IngressExposure{}}
+				return wsc
+			},
+			want: &apis.FieldError{
+				Message: "invalid value: events.ns.svc.cluster.local",
+				Paths:   []string{"spec.exposure.host"},
+				Details: `must not be under the cluster domain "cluster.local"`,
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := test.wsc(t).Validate(context.Background())
+			if diff := cmp.Diff(test.want.Error(), got.Error()); diff != "" {
+				t.Error("Validate (-want, +got) =", diff)
+			}
+		})
+	}
+}
+
+func TestWebSocketChannelDefaults(t *testing.T) {
+	wsc := withSubscribers(
+		eventingduckv1.SubscriberSpec{
+			UID:           "1",
+			SubscriberURI: mustParseURL(t, "ws://subscriber.example.com/events?x=1"),
+			ReplyURI:      mustParseURL(t, "wss://reply.example.com/replies"),
+		},
+		eventingduckv1.SubscriberSpec{UID: "2", SubscriberURI: mustParseURL(t, "https://subscriber.example.com/")},
+	)
+	wsc.SetDefaults(context.Background())
+
+	want := withSubscribers(
+		eventingduckv1.SubscriberSpec{
+			UID:           "1",
+			SubscriberURI: mustParseURL(t, "http://subscriber.example.com/events?x=1"),
+			ReplyURI:      mustParseURL(t, "https://reply.example.com/replies"),
+		},
+		eventingduckv1.SubscriberSpec{UID: "2", SubscriberURI: mustParseURL(t, "https://subscriber.example.com/")},
+	)
+	want.Annotations = map[string]string{messaging.SubscribableDuckVersionAnnotation: "v1"}
+	if diff := cmp.Diff(want, wsc); diff != "" {
+		t.Error("SetDefaults (-want, +got) =", diff)
+	}
+}
+
+func withExposure(host string) *WebSocketChannel {
+	wsc := withSubscribers()
+	if host != "" {
+		wsc.Spec.Exposure = &WebSocketChannelExposure{Host: host, Ingress: &IngressExposure{}}
+	}
+	return wsc
+}
+
+func TestWebSocketChannelUpdateValidation(t *testing.T) {
+	tests := map[string]struct {
+		original *WebSocketChannel
+		updated  *WebSocketChannel
+		want     *apis.FieldError
+	}{
+		"exposure host unchanged": {
+			original: withExposure("events.example.com"),
+			updated:  withExposure("events.example.com"),
+		},
+		"exposure host changed": {
+			original: withExposure("events.example.com"),
+			updated:  withExposure("other.example.com"),
+			want: &apis.FieldError{
+				Message: "Immutable field changed",
+				Paths:   []string{"spec.exposure.host"},
+				Details: `"events.example.com" cannot be changed to "other.example.com", remove spec.exposure first to expose the channel on another host`,
+			},
+		},
+		"exposure removed": {
+			original: withExposure("events.example.com"),
+			updated:  withExposure(""),
+		},
+		"exposure added back with another host": {
+			original: withExposure(""),
+			updated:  withExposure("other.example.com"),
+		},
+		"durable and exposure host changed": {
+			original: withExposure("events.example.com"),
+			updated: func() *WebSocketChannel {
+				wsc := withExposure("other.example.com")
+				wsc.Spec.Durable = true
+				return wsc
+			}(),
+			want: (&apis.FieldError{
+				Message: "Immutable field changed",
+				Paths:   []string{"spec.durable"},
+				Details: "false cannot be changed to true",
+			}).Also(&apis.FieldError{
+				Message: "Immutable field changed",
+				Paths:   []string{"spec.exposure.host"},
+				Details: `"events.example.com" cannot be changed to "other.example.com", remove spec.exposure first to expose the channel on another host`,
+			}),
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := apis.WithinUpdate(context.Background(), test.original)
+			got := test.updated.Validate(ctx)
+			if diff := cmp.Diff(test.want.Error(), got.Error()); diff != "" {
+				t.Error("Validate (-want, +got) =", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+// +build !ignore_autogenerated
+
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by deepcopy-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	apis "knative.dev/pkg/apis"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPRouteExposure) DeepCopyInto(out *HTTPRouteExposure) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPRouteExposure.
+func (in *HTTPRouteExposure) DeepCopy() *HTTPRouteExposure {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPRouteExposure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressExposure) DeepCopyInto(out *IngressExposure) {
+	*out = *in
+	if in.ClassName != nil {
+		in, out := &in.ClassName, &out.ClassName
+		*out = new(string)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressExposure.
+func (in *IngressExposure) DeepCopy() *IngressExposure {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressExposure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubscriberDeliveryStats) DeepCopyInto(out *SubscriberDeliveryStats) {
+	*out = *in
+	if in.LastSuccessTime != nil {
+		in, out := &in.LastSuccessTime, &out.LastSuccessTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastFailureTime != nil {
+		in, out := &in.LastFailureTime, &out.LastFailureTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubscriberDeliveryStats.
+func (in *SubscriberDeliveryStats) DeepCopy() *SubscriberDeliveryStats {
+	if in == nil {
+		return nil
+	}
+	out := new(SubscriberDeliveryStats)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubscriberFlowControl) DeepCopyInto(out *SubscriberFlowControl) {
+	*out = *in
+	if in.MaxInFlight != nil {
+		in, out := &in.MaxInFlight, &out.MaxInFlight
+		*out = new(int32)
+		**out = **in
+	}
+	if in.QueueLength != nil {
+		in, out := &in.QueueLength, &out.QueueLength
+		*out = new(int32)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubscriberFlowControl.
+func (in *SubscriberFlowControl) DeepCopy() *SubscriberFlowControl {
+	if in == nil {
+		return nil
+	}
+	out := new(SubscriberFlowControl)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketChannel) DeepCopyInto(out *WebSocketChannel) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketChannel.
+func (in *WebSocketChannel) DeepCopy() *WebSocketChannel {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketChannel)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WebSocketChannel) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketChannelAddress) DeepCopyInto(out *WebSocketChannelAddress) {
+	*out = *in
+	if in.URL != nil {
+		in, out := &in.URL, &out.URL
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketChannelAddress.
+func (in *WebSocketChannelAddress) DeepCopy() *WebSocketChannelAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketChannelAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketChannelExpiry) DeepCopyInto(out *WebSocketChannelExpiry) {
+	*out = *in
+	if in.TTL != nil {
+		in, out := &in.TTL, &out.TTL
+		*out = new(string)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketChannelExpiry.
+func (in *WebSocketChannelExpiry) DeepCopy() *WebSocketChannelExpiry {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketChannelExpiry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketChannelExposure) DeepCopyInto(out *WebSocketChannelExposure) {
+	*out = *in
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(IngressExposure)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HTTPRoute != nil {
+		in, out := &in.HTTPRoute, &out.HTTPRoute
+		*out = new(HTTPRouteExposure)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketChannelExposure.
+func (in *WebSocketChannelExposure) DeepCopy() *WebSocketChannelExposure {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketChannelExposure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketChannelFlowControl) DeepCopyInto(out *WebSocketChannelFlowControl) {
+	*out = *in
+	if in.MaxInFlight != nil {
+		in, out := &in.MaxInFlight, &out.MaxInFlight
+		*out = new(int32)
+		**out = **in
+	}
+	if in.QueueLength != nil {
+		in, out := &in.QueueLength, &out.QueueLength
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Subscribers != nil {
+		in, out := &in.Subscribers, &out.Subscribers
+		*out = make([]SubscriberFlowControl, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketChannelFlowControl.
+func (in *WebSocketChannelFlowControl) DeepCopy() *WebSocketChannelFlowControl {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketChannelFlowControl)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketChannelList) DeepCopyInto(out *WebSocketChannelList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WebSocketChannel, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketChannelList.
+func (in *WebSocketChannelList) DeepCopy() *WebSocketChannelList {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketChannelList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WebSocketChannelList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketChannelSpec) DeepCopyInto(out *WebSocketChannelSpec) {
+	*out = *in
+	in.ChannelableSpec.DeepCopyInto(&out.ChannelableSpec)
+	if in.Exposure != nil {
+		in, out := &in.Exposure, &out.Exposure
+		*out = new(WebSocketChannelExposure)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Expiry != nil {
+		in, out := &in.Expiry, &out.Expiry
+		*out = new(WebSocketChannelExpiry)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FlowControl != nil {
+		in, out := &in.FlowControl, &out.FlowControl
+		*out = new(WebSocketChannelFlowControl)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketChannelSpec.
+func (in *WebSocketChannelSpec) DeepCopy() *WebSocketChannelSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketChannelSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketChannelStatus) DeepCopyInto(out *WebSocketChannelStatus) {
+	*out = *in
+	in.ChannelableStatus.DeepCopyInto(&out.ChannelableStatus)
+	if in.DeadLetterSinkURI != nil {
+		in, out := &in.DeadLetterSinkURI, &out.DeadLetterSinkURI
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExternalURL != nil {
+		in, out := &in.ExternalURL, &out.ExternalURL
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]WebSocketChannelAddress, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SubscriberStats != nil {
+		in, out := &in.SubscriberStats, &out.SubscriberStats
+		*out = make([]SubscriberDeliveryStats, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketChannelStatus.
+func (in *WebSocketChannelStatus) DeepCopy() *WebSocketChannelStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketChannelStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/configmap"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ChannelConfigName is the name of the ConfigMap holding the cluster-wide configuration
+	// of WebSocketChannels.
+	ChannelConfigName = "config-websocket-channel"
+
+	readTimeoutKey    = "read-timeout"
+	writeTimeoutKey   = "write-timeout"
+	portKey           = "port"
+	maxMessageSizeKey = "max-message-size"
+	deliveryKey       = "delivery"
+
+	// DefaultReadTimeout is the default time the dispatcher waits to read a request.
+	DefaultReadTimeout = 15 * time.Minute
+
+	// DefaultWriteTimeout is the default time the dispatcher waits to write a response.
+	DefaultWriteTimeout = 15 * time.Minute
+
+	// DefaultPort is the default port the dispatcher accepts events on.
+	DefaultPort = 8080
+)
+
+// Channel is the cluster-wide configuration of WebSocketChannels.
+type Channel struct {
+	// ReadTimeout is the maximum duration for the dispatcher to read a request.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is the maximum duration for the dispatcher to write a response. Events in
+	// flight are given as much time to be delivered when the dispatcher shuts down.
+	WriteTimeout time.Duration
+
+	// Port is the port the dispatcher accepts events on. It must match the ports of the
+	// dispatcher Deployment and Service.
+	Port int
+
+	// MaxMessageSize is the maximum size of a request body in bytes, or 0 for no limit.
+	MaxMessageSize int64
+
+	// Delivery is the delivery spec of channels that don't set spec.delivery.
+	Delivery *eventingduckv1.DeliverySpec
+}
+
+// NewChannelConfigFromMap creates a Channel from the supplied map, falling back to the
+// defaults for missing keys.
+func NewChannelConfigFromMap(data map[string]string) (*Channel, error) {
+	c := &Channel{
+		ReadTimeout:  DefaultReadTimeout,
+		WriteTimeout: DefaultWriteTimeout,
+		Port:         DefaultPort,
+	}
+
+	var maxMessageSize *resource.Quantity
+	if err := configmap.Parse(data,
+		configmap.AsDuration(readTimeoutKey, &c.ReadTimeout),
+		configmap.AsDuration(writeTimeoutKey, &c.WriteTimeout),
+		configmap.AsInt(portKey, &c.Port),
+		configmap.AsQuantity(maxMessageSizeKey, &maxMessageSize),
+	); err != nil {
+		return nil, err
+	}
+
+	if c.ReadTimeout <= 0 {
+		return nil, fmt.Errorf("%s must be positive, was: %v", readTimeoutKey, c.ReadTimeout)
+	}
+	if c.WriteTimeout <= 0 {
+		return nil, fmt.Errorf("%s must be positive, was: %v", writeTimeoutKey, c.WriteTimeout)
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return nil, fmt.Errorf("%s must be between 1 and 65535, was: %d", portKey, c.Port)
+	}
+	if maxMessageSize != nil {
+		if maxMessageSize.Sign() < 0 {
+			return nil, fmt.Errorf("%s must not be negative, was: %v", maxMessageSizeKey, maxMessageSize)
+		}
+		c.MaxMessageSize = maxMessageSize.Value()
+	}
+
+	if value := data[deliveryKey]; value != "" {
+		j, err := yaml.YAMLToJSON([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s could not be converted to JSON: %w", deliveryKey, err)
+		}
+		c.Delivery = &eventingduckv1.DeliverySpec{}
+		if err := json.Unmarshal(j, c.Delivery); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", deliveryKey, err)
+		}
+		if err := c.Delivery.Validate(context.Background()); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", deliveryKey, err)
+		}
+	}
+
+	return c, nil
+}
+
+// NewChannelConfigFromConfigMap creates a Channel from the supplied ConfigMap.
+func NewChannelConfigFromConfigMap(config *corev1.ConfigMap) (*Channel, error) {
+	return NewChannelConfigFromMap(config.Data)
+}
@@ -18,4 +18,19 @@ package apis
 
 const (
 	GroupName = "channels.aliok.github.com"
+
+	// BatchMaxEventsAnnotation on a Subscription to a WebSocketChannel delivers the events to its
+	// subscriber in batches, every batch being a single request with an
+	// application/cloudevents-batch+json body. A batch is sent once it holds the given number of
+	// events, 100 by default. The subscriber accepts the whole batch with a 2xx response. It can
+	// fail some of the events with a 207 Multi-Status response, whose JSON body lists their indexes
+	// in the batch, as in {"failed": [0, 3]}. Only those are retried, and sent to the dead letter
+	// sink if they still fail. Durable channels and channels with PartitionKey ordering deliver
+	// the events one at a time, and ignore it.
+	BatchMaxEventsAnnotation = GroupName + "/batch-max-events"
+
+	// BatchMaxDelayAnnotation on a Subscription to a WebSocketChannel delivers the events to its
+	// subscriber in batches like BatchMaxEventsAnnotation, sent at the latest after the given
+	// duration counted from their first event, 100ms by default.
+	BatchMaxDelayAnnotation = GroupName + "/batch-max-delay"
 )
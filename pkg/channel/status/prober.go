@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// probeTimeout is the time a single dispatcher replica gets to answer a probe.
+const probeTimeout = 2 * time.Second
+
+// Prober checks whether every dispatcher replica has loaded a channel.
+type Prober struct {
+	client *http.Client
+	port   int
+}
+
+// NewProber creates a Prober probing dispatcher replicas on Port.
+func NewProber() *Prober {
+	return &Prober{
+		client: &http.Client{Timeout: probeTimeout},
+		port:   Port,
+	}
+}
+
+// Probe returns nil if every ready address of the dispatcher endpoints reports the channel with
+// the given host name as loaded at the given generation or a later one.
+func (p *Prober) Probe(ctx context.Context, endpoints *corev1.Endpoints, host string, generation int64) error {
+	var ips []string
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			ips = append(ips, address.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("there are no dispatcher replicas to probe")
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(ips))
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			errs[i] = p.probeReplica(ctx, ip, host, generation)
+		}(i, ip)
+	}
+	wg.Wait()
+
+	var messages []string
+	for _, err := range errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		sort.Strings(messages)
+		return fmt.Errorf("%d of %d dispatcher replicas have not loaded the channel: %s", len(messages), len(ips), strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+func (p *Prober) probeReplica(ctx context.Context, ip, host string, generation int64) error {
+	url := "http://" + net.JoinHostPort(ip, strconv.Itoa(p.port)) + Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ip, err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ip, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status code %d", ip, resp.StatusCode)
+	}
+
+	channels := LoadedChannels{}
+	if err := json.NewDecoder(resp.Body).Decode(&channels); err != nil {
+		return fmt.Errorf("%s: %w", ip, err)
+	}
+	loaded, ok := channels[host]
+	if !ok {
+		return fmt.Errorf("%s: channel not loaded", ip)
+	}
+	if loaded < generation {
+		return fmt.Errorf("%s: generation %d loaded, expected %d", ip, loaded, generation)
+	}
+	return nil
+}
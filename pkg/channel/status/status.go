@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package status lets the controller find out which channels every dispatcher replica has loaded.
+// Dispatchers serve the channels they have loaded with a Handler, the controller probes every
+// dispatcher replica with a Prober before marking a channel ready.
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+const (
+	// Port is the port dispatchers serve the status of their channels on.
+	Port = 8081
+
+	// Path is the path dispatchers serve the status of their channels on.
+	Path = "/channels"
+)
+
+// LoadedChannels maps the host name of every channel loaded by a dispatcher replica to the
+// generation of the channel it has loaded.
+type LoadedChannels map[string]int64
+
+// Handler is an http.Handler serving the channels loaded by this dispatcher replica.
+type Handler struct {
+	mu       sync.RWMutex
+	channels LoadedChannels
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+// NewHandler creates a Handler with no loaded channels.
+func NewHandler() *Handler {
+	return &Handler{
+		channels: make(LoadedChannels),
+	}
+}
+
+// SetLoaded records that the given generation of the channel with the given host name is loaded.
+func (h *Handler) SetLoaded(host string, generation int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.channels[host] = generation
+}
+
+// Delete records that the channel with the given host name is not loaded anymore.
+func (h *Handler) Delete(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.channels, host)
+}
+
+// Loaded returns a copy of the channels loaded by this dispatcher replica.
+func (h *Handler) Loaded() LoadedChannels {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	channels := make(LoadedChannels, len(h.channels))
+	for host, generation := range h.channels {
+		channels[host] = generation
+	}
+	return channels
+}
+
+func (h *Handler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		response.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if request.URL.Path != Path {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	h.mu.RLock()
+	body, err := json.Marshal(h.channels)
+	h.mu.RUnlock()
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	_, _ = response.Write(body)
+}
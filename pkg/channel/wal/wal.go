@@ -0,0 +1,437 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package wal is the write-ahead log of a durable channel. Accepted events are appended to a
+// segmented log on disk, and every subscriber commits a cursor into the log once an event is
+// delivered. Committed cursors are saved periodically, so events delivered shortly before a crash
+// are delivered again. Segments are deleted once every saved cursor has moved past them.
+package wal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSegmentSize is the size after which a new segment is started.
+	DefaultSegmentSize = 64 << 20
+
+	// DefaultFlushInterval is the time after which committed cursors are saved.
+	DefaultFlushInterval = time.Second
+
+	segmentSuffix = ".log"
+	cursorsFile   = "cursors.json"
+
+	// headerSize is the size of the header of a record: the length of its data and its CRC-32.
+	headerSize = 8
+)
+
+// ErrCorrupt is returned when a record does not match its checksum.
+var ErrCorrupt = errors.New("corrupt record")
+
+// ErrUnreadable is wrapped by the errors Read returns for records that can never be read: records
+// that were deleted, or that are corrupt or truncated. Skip returns where reading can continue.
+var ErrUnreadable = errors.New("unreadable record")
+
+// Log is a segmented write-ahead log. Records are addressed by their byte offset in the log;
+// every segment is named after the offset of its first record.
+type Log struct {
+	dir         string
+	segmentSize int64
+
+	mu         sync.Mutex
+	segments   []int64
+	active     *os.File
+	activeBase int64
+	end        int64
+	cursors    map[string]int64
+	appended   chan struct{}
+
+	// dirty is whether cursors were committed since they were last saved, and flushErr the error
+	// of the last failed save, returned by the next Commit.
+	dirty    bool
+	flushErr error
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// Open opens the log in the given directory, creating it if needed. A record only partially
+// written before a crash is truncated. Committed cursors are saved every flushInterval.
+func Open(dir string, segmentSize int64, flushInterval time.Duration) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := &Log{
+		dir:         dir,
+		segmentSize: segmentSize,
+		cursors:     make(map[string]int64),
+		appended:    make(chan struct{}),
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), segmentSuffix) {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, base)
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+	if len(l.segments) == 0 {
+		l.segments = []int64{0}
+	}
+
+	l.activeBase = l.segments[len(l.segments)-1]
+	size, err := recoverSegment(l.segmentPath(l.activeBase))
+	if err != nil {
+		return nil, err
+	}
+	l.end = l.activeBase + size
+	l.active, err = os.OpenFile(l.segmentPath(l.activeBase), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, cursorsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &l.cursors); err != nil {
+			return nil, fmt.Errorf("failed to read cursors: %w", err)
+		}
+	}
+	go l.flushPeriodically(flushInterval)
+	return l, nil
+}
+
+// flushPeriodically saves the committed cursors every interval until the log is closed.
+func (l *Log) flushPeriodically(interval time.Duration) {
+	defer close(l.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			l.flushErr = l.flush()
+			l.mu.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// flush saves the cursors if they were committed since they were last saved, and deletes the
+// segments no cursor needs anymore.
+func (l *Log) flush() error {
+	if !l.dirty {
+		return nil
+	}
+	if err := l.saveCursors(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return l.deleteSegments()
+}
+
+// recoverSegment returns the size of the valid records of the segment, truncating anything after them.
+func recoverSegment(path string) (int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for {
+		_, n, err := readRecord(f, size, info.Size())
+		if err != nil {
+			break
+		}
+		size += n
+	}
+	if err := f.Truncate(size); err != nil {
+		return 0, err
+	}
+	return size, f.Sync()
+}
+
+// readRecord reads the record at the given position of a segment of the given size.
+func readRecord(r io.ReaderAt, position, size int64) ([]byte, int64, error) {
+	header := make([]byte, headerSize)
+	if _, err := r.ReadAt(header, position); err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if position+headerSize+length > size {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, position+headerSize); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, ErrCorrupt
+	}
+	return data, int64(headerSize + len(data)), nil
+}
+
+func (l *Log) segmentPath(base int64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", base, segmentSuffix))
+}
+
+// Append writes the record to the log and syncs it to disk. It returns the offset of the record.
+func (l *Log) Append(data []byte) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.end > l.activeBase && l.end-l.activeBase+int64(headerSize+len(data)) > l.segmentSize {
+		if err := l.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[headerSize:], data)
+	_, err := l.active.Write(record)
+	if err == nil {
+		err = l.active.Sync()
+	}
+	if err != nil {
+		// Drop what was written of the record, so the next one is appended at the end of the log.
+		_ = l.active.Truncate(l.end - l.activeBase)
+		return 0, err
+	}
+
+	offset := l.end
+	l.end += int64(len(record))
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return offset, nil
+}
+
+// roll starts a new segment at the end of the log.
+func (l *Log) roll() error {
+	f, err := os.OpenFile(l.segmentPath(l.end), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	l.active = f
+	l.activeBase = l.end
+	l.segments = append(l.segments, l.end)
+	return nil
+}
+
+// Dir returns the directory of the log.
+func (l *Log) Dir() string {
+	return l.dir
+}
+
+// End returns the offset the next record will be appended at.
+func (l *Log) End() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.end
+}
+
+// Drained returns whether every cursor has reached the end of the log.
+func (l *Log) Drained() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, offset := range l.cursors {
+		if offset < l.end {
+			return false
+		}
+	}
+	return true
+}
+
+// Appended returns a channel that is closed when the next record is appended.
+func (l *Log) Appended() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.appended
+}
+
+// Read returns the record at the given offset and the offset of the record after it. It returns
+// io.EOF if there is no record at the offset yet.
+func (l *Log) Read(offset int64) ([]byte, int64, error) {
+	l.mu.Lock()
+	if offset >= l.end {
+		l.mu.Unlock()
+		return nil, offset, io.EOF
+	}
+	i := sort.Search(len(l.segments), func(i int) bool { return l.segments[i] > offset }) - 1
+	if i < 0 {
+		l.mu.Unlock()
+		return nil, offset, fmt.Errorf("%w: offset %d was deleted", ErrUnreadable, offset)
+	}
+	base := l.segments[i]
+	l.mu.Unlock()
+
+	f, err := os.Open(l.segmentPath(base))
+	if os.IsNotExist(err) {
+		return nil, offset, fmt.Errorf("%w: %v", ErrUnreadable, err)
+	}
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, offset, err
+	}
+	data, n, err := readRecord(f, offset-base, info.Size())
+	if err == ErrCorrupt || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, offset, fmt.Errorf("%w: failed to read offset %d: %v", ErrUnreadable, offset, err)
+	}
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to read offset %d: %w", offset, err)
+	}
+	return data, offset + n, nil
+}
+
+// Skip returns the offset reading can continue at when the record at the given offset is
+// unreadable. The records after an unreadable one can't be found, so it is the start of the next
+// segment, or the end of the log. For a deleted offset, it is the start of the first segment.
+func (l *Log) Skip(offset int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := sort.Search(len(l.segments), func(i int) bool { return l.segments[i] > offset })
+	if i < len(l.segments) {
+		return l.segments[i]
+	}
+	return l.end
+}
+
+// Cursor returns the committed cursor with the given name, and whether there is one.
+func (l *Log) Cursor(name string) (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	offset, ok := l.cursors[name]
+	return offset, ok
+}
+
+// Commit records that the cursor with the given name has moved to the given offset. The cursor is
+// saved with the next flush; the error returned is the one of the last flush, if it failed.
+func (l *Log) Commit(name string, offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cursors[name] = offset
+	l.dirty = true
+	err := l.flushErr
+	l.flushErr = nil
+	return err
+}
+
+// DeleteCursor drops the cursor with the given name.
+func (l *Log) DeleteCursor(name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.cursors[name]; !ok {
+		return nil
+	}
+	delete(l.cursors, name)
+	l.dirty = true
+	return l.flush()
+}
+
+// saveCursors atomically replaces the cursors on disk.
+func (l *Log) saveCursors() error {
+	data, err := json.Marshal(l.cursors)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(l.dir, cursorsFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(l.dir, cursorsFile))
+}
+
+// deleteSegments deletes the segments before the lowest cursor, never the active one.
+func (l *Log) deleteSegments() error {
+	lowest := l.end
+	for _, offset := range l.cursors {
+		if offset < lowest {
+			lowest = offset
+		}
+	}
+	for len(l.segments) > 1 && l.segments[1] <= lowest {
+		if err := os.Remove(l.segmentPath(l.segments[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+// Close saves the committed cursors and closes the log. Its files are kept.
+func (l *Log) Close() error {
+	close(l.stop)
+	<-l.stopped
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	flushErr := l.flush()
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
@@ -0,0 +1,347 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// noFlush is a flush interval long enough for cursors to only be saved by the tests.
+const noFlush = time.Hour
+
+func openLog(t *testing.T, dir string, segmentSize int64, flushInterval time.Duration) *Log {
+	t.Helper()
+	l, err := Open(dir, segmentSize, flushInterval)
+	if err != nil {
+		t.Fatal("Open() =", err)
+	}
+	return l
+}
+
+func appendRecords(t *testing.T, l *Log, records ...string) []int64 {
+	t.Helper()
+	offsets := make([]int64, 0, len(records))
+	for _, record := range records {
+		offset, err := l.Append([]byte(record))
+		if err != nil {
+			t.Fatal("Append() =", err)
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+// readAll reads the records from the given offset to the end of the log.
+func readAll(t *testing.T, l *Log, offset int64) []string {
+	t.Helper()
+	var records []string
+	for {
+		data, next, err := l.Read(offset)
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("Read(%d) = %v", offset, err)
+		}
+		records = append(records, string(data))
+		offset = next
+	}
+}
+
+func assertRecords(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Records = %q, want %q", got, want)
+	}
+}
+
+func TestAppendRead(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, DefaultSegmentSize, noFlush)
+
+	appended := l.Appended()
+	offsets := appendRecords(t, l, "a", "bb", "ccc")
+	select {
+	case <-appended:
+	default:
+		t.Error("Appended() channel not closed after Append")
+	}
+	if offsets[0] != 0 || offsets[1] != headerSize+1 || offsets[2] != 2*headerSize+3 {
+		t.Errorf("Offsets = %v", offsets)
+	}
+	if got, want := l.End(), int64(3*headerSize+6); got != want {
+		t.Errorf("End() = %d, want %d", got, want)
+	}
+	assertRecords(t, readAll(t, l, 0), "a", "bb", "ccc")
+	assertRecords(t, readAll(t, l, offsets[1]), "bb", "ccc")
+	if _, next, err := l.Read(l.End()); err != io.EOF || next != l.End() {
+		t.Errorf("Read(End()) = %d, %v, want %d, EOF", next, err, l.End())
+	}
+
+	// Appended records are synced, so they are read back without closing the log first.
+	reopened := openLog(t, dir, DefaultSegmentSize, noFlush)
+	assertRecords(t, readAll(t, reopened, 0), "a", "bb", "ccc")
+	if err := reopened.Close(); err != nil {
+		t.Error("Close() =", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Error("Close() =", err)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	tests := map[string]func(t *testing.T, path string){
+		"torn record": func(t *testing.T, path string) {
+			// The header of a record was written, but not all of its data.
+			appendToFile(t, path, []byte{0, 0, 0, 100, 1, 2, 3, 4, 'x'})
+		},
+		"torn header": func(t *testing.T, path string) {
+			appendToFile(t, path, []byte{0, 0})
+		},
+		"corrupt record": func(t *testing.T, path string) {
+			// The last byte of the last record is flipped, so it doesn't match its checksum.
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal("ReadFile() =", err)
+			}
+			data[len(data)-1] ^= 0xff
+			if err := ioutil.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal("WriteFile() =", err)
+			}
+		},
+	}
+	for name, damage := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openLog(t, dir, DefaultSegmentSize, noFlush)
+			offsets := appendRecords(t, l, "first", "second")
+			end := l.End()
+			if err := l.Close(); err != nil {
+				t.Fatal("Close() =", err)
+			}
+
+			damage(t, l.segmentPath(0))
+			want := []string{"first", "second"}
+			if name == "corrupt record" {
+				// The corrupt record is the last one, so it is truncated like a torn one.
+				want, end = want[:1], offsets[1]
+			}
+
+			l = openLog(t, dir, DefaultSegmentSize, noFlush)
+			defer l.Close()
+			if got := l.End(); got != end {
+				t.Errorf("End() = %d after recovery, want %d", got, end)
+			}
+			assertRecords(t, readAll(t, l, 0), want...)
+
+			// New records are appended right after the recovered ones.
+			appendRecords(t, l, "third")
+			assertRecords(t, readAll(t, l, 0), append(want, "third")...)
+		})
+	}
+}
+
+func appendToFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal("OpenFile() =", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal("Write() =", err)
+	}
+}
+
+func TestReadCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	// Every record gets a segment of its own.
+	l := openLog(t, dir, 1, noFlush)
+	defer l.Close()
+	offsets := appendRecords(t, l, "first", "second", "third")
+
+	// Corrupt the record of a segment that is not recovered, as it is not the active one.
+	path := l.segmentPath(offsets[1])
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("ReadFile() =", err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal("WriteFile() =", err)
+	}
+
+	_, _, err = l.Read(offsets[1])
+	if !errors.Is(err, ErrUnreadable) {
+		t.Fatalf("Read() = %v, want %v", err, ErrUnreadable)
+	}
+	if got := l.Skip(offsets[1]); got != offsets[2] {
+		t.Errorf("Skip() = %d, want the next segment at %d", got, offsets[2])
+	}
+	if got := l.Skip(offsets[2]); got != l.End() {
+		t.Errorf("Skip() = %d in the active segment, want the end of the log at %d", got, l.End())
+	}
+	assertRecords(t, readAll(t, l, l.Skip(offsets[1])), "third")
+}
+
+func TestCursorFlush(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, DefaultSegmentSize, noFlush)
+	offsets := appendRecords(t, l, "a", "b")
+
+	if err := l.Commit("sub", offsets[1]); err != nil {
+		t.Fatal("Commit() =", err)
+	}
+	if got, ok := l.Cursor("sub"); !ok || got != offsets[1] {
+		t.Errorf("Cursor() = %d, %t, want %d, true", got, ok, offsets[1])
+	}
+	// Commit doesn't save the cursor itself, as if the dispatcher crashed before the next flush.
+	if _, err := os.Stat(filepath.Join(dir, cursorsFile)); !os.IsNotExist(err) {
+		t.Errorf("Stat(%s) = %v, want it not to exist before a flush", cursorsFile, err)
+	}
+
+	// Close saves the committed cursors.
+	if err := l.Close(); err != nil {
+		t.Fatal("Close() =", err)
+	}
+	l = openLog(t, dir, DefaultSegmentSize, 10*time.Millisecond)
+	defer l.Close()
+	if got, ok := l.Cursor("sub"); !ok || got != offsets[1] {
+		t.Errorf("Cursor() = %d, %t after reopening, want %d, true", got, ok, offsets[1])
+	}
+
+	// The cursors are saved periodically.
+	if err := l.Commit("sub", l.End()); err != nil {
+		t.Fatal("Commit() =", err)
+	}
+	want := fmt.Sprintf(`{"sub":%d}`, l.End())
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, cursorsFile))
+		if string(data) == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Saved cursors = %s, want %s", data, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Deleting a cursor saves the cursors right away.
+	if err := l.DeleteCursor("sub"); err != nil {
+		t.Fatal("DeleteCursor() =", err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, cursorsFile)); string(data) != "{}" {
+		t.Errorf("Saved cursors = %s after DeleteCursor, want {}", data)
+	}
+}
+
+func TestSegmentDeletion(t *testing.T) {
+	dir := t.TempDir()
+	// Every record gets a segment of its own.
+	l := openLog(t, dir, 1, noFlush)
+	defer l.Close()
+	offsets := appendRecords(t, l, "a", "b", "c", "d")
+
+	segmentExists := func(base int64) bool {
+		_, err := os.Stat(l.segmentPath(base))
+		return err == nil
+	}
+	flush := func() {
+		t.Helper()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if err := l.flush(); err != nil {
+			t.Fatal("flush() =", err)
+		}
+	}
+
+	// Segments are only deleted below the lowest cursor.
+	if err := l.Commit("fast", offsets[3]); err != nil {
+		t.Fatal("Commit() =", err)
+	}
+	if err := l.Commit("slow", offsets[1]); err != nil {
+		t.Fatal("Commit() =", err)
+	}
+	flush()
+	for i, want := range []bool{false, true, true, true} {
+		if got := segmentExists(offsets[i]); got != want {
+			t.Errorf("Segment %d exists = %t, want %t", offsets[i], got, want)
+		}
+	}
+
+	if err := l.Commit("slow", l.End()); err != nil {
+		t.Fatal("Commit() =", err)
+	}
+	flush()
+	// The active segment is kept, even when every cursor moved past it.
+	for i, want := range []bool{false, false, false, true} {
+		if got := segmentExists(offsets[i]); got != want {
+			t.Errorf("Segment %d exists = %t, want %t", offsets[i], got, want)
+		}
+	}
+
+	// Deleted records are unreadable, reading continues at the first segment kept.
+	if _, _, err := l.Read(offsets[0]); !errors.Is(err, ErrUnreadable) {
+		t.Errorf("Read() = %v for a deleted record, want %v", err, ErrUnreadable)
+	}
+	if got := l.Skip(offsets[0]); got != offsets[3] {
+		t.Errorf("Skip() = %d for a deleted record, want %d", got, offsets[3])
+	}
+}
+
+func TestDrained(t *testing.T) {
+	l := openLog(t, t.TempDir(), DefaultSegmentSize, noFlush)
+	defer l.Close()
+
+	if !l.Drained() {
+		t.Error("Drained() = false for an empty log")
+	}
+	offsets := appendRecords(t, l, "a", "b")
+	// Records nobody reads don't keep the log from being drained.
+	if !l.Drained() {
+		t.Error("Drained() = false without cursors")
+	}
+
+	for _, cursor := range []string{"sub-1", "sub-2"} {
+		if err := l.Commit(cursor, offsets[1]); err != nil {
+			t.Fatal("Commit() =", err)
+		}
+	}
+	if l.Drained() {
+		t.Error("Drained() = true with cursors before the end of the log")
+	}
+	if err := l.Commit("sub-1", l.End()); err != nil {
+		t.Fatal("Commit() =", err)
+	}
+	if l.Drained() {
+		t.Error("Drained() = true with a cursor before the end of the log")
+	}
+	if err := l.Commit("sub-2", l.End()); err != nil {
+		t.Fatal("Commit() =", err)
+	}
+	if !l.Drained() {
+		t.Error("Drained() = false with every cursor at the end of the log")
+	}
+}
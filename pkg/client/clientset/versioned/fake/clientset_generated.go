@@ -22,6 +22,8 @@ import (
 	clientset "github.com/aliok/websocket-channel/pkg/client/clientset/versioned"
 	channelsv1alpha1 "github.com/aliok/websocket-channel/pkg/client/clientset/versioned/typed/channels/v1alpha1"
 	fakechannelsv1alpha1 "github.com/aliok/websocket-channel/pkg/client/clientset/versioned/typed/channels/v1alpha1/fake"
+	channelsv1beta1 "github.com/aliok/websocket-channel/pkg/client/clientset/versioned/typed/channels/v1beta1"
+	fakechannelsv1beta1 "github.com/aliok/websocket-channel/pkg/client/clientset/versioned/typed/channels/v1beta1/fake"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/discovery"
@@ -80,3 +82,8 @@ var _ clientset.Interface = &Clientset{}
 func (c *Clientset) ChannelsV1alpha1() channelsv1alpha1.ChannelsV1alpha1Interface {
 	return &fakechannelsv1alpha1.FakeChannelsV1alpha1{Fake: &c.Fake}
 }
+
+// ChannelsV1beta1 retrieves the ChannelsV1beta1Client
+func (c *Clientset) ChannelsV1beta1() channelsv1beta1.ChannelsV1beta1Interface {
+	return &fakechannelsv1beta1.FakeChannelsV1beta1{Fake: &c.Fake}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"context"
+	time "time"
+
+	channelsv1beta1 "github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	versioned "github.com/aliok/websocket-channel/pkg/client/clientset/versioned"
+	internalinterfaces "github.com/aliok/websocket-channel/pkg/client/informers/externalversions/internalinterfaces"
+	v1beta1 "github.com/aliok/websocket-channel/pkg/client/listers/channels/v1beta1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// WebSocketChannelInformer provides access to a shared informer and lister for
+// WebSocketChannels.
+type WebSocketChannelInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1beta1.WebSocketChannelLister
+}
+
+type webSocketChannelInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewWebSocketChannelInformer constructs a new informer for WebSocketChannel type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewWebSocketChannelInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredWebSocketChannelInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredWebSocketChannelInformer constructs a new informer for WebSocketChannel type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredWebSocketChannelInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.ChannelsV1beta1().WebSocketChannels(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.ChannelsV1beta1().WebSocketChannels(namespace).Watch(context.TODO(), options)
+			},
+		},
+		&channelsv1beta1.WebSocketChannel{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *webSocketChannelInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredWebSocketChannelInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *webSocketChannelInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&channelsv1beta1.WebSocketChannel{}, f.defaultInformer)
+}
+
+func (f *webSocketChannelInformer) Lister() v1beta1.WebSocketChannelLister {
+	return v1beta1.NewWebSocketChannelLister(f.Informer().GetIndexer())
+}
@@ -26,7 +26,7 @@ import (
 
 	versionedscheme "github.com/aliok/websocket-channel/pkg/client/clientset/versioned/scheme"
 	client "github.com/aliok/websocket-channel/pkg/client/injection/client"
-	websocketchannel "github.com/aliok/websocket-channel/pkg/client/injection/informers/channels/v1alpha1/websocketchannel"
+	websocketchannel "github.com/aliok/websocket-channel/pkg/client/injection/informers/channels/v1beta1/websocketchannel"
 	zap "go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	labels "k8s.io/apimachinery/pkg/labels"
@@ -24,9 +24,9 @@ import (
 	fmt "fmt"
 	reflect "reflect"
 
-	v1alpha1 "github.com/aliok/websocket-channel/pkg/apis/channels/v1alpha1"
+	v1beta1 "github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
 	versioned "github.com/aliok/websocket-channel/pkg/client/clientset/versioned"
-	channelsv1alpha1 "github.com/aliok/websocket-channel/pkg/client/listers/channels/v1alpha1"
+	channelsv1beta1 "github.com/aliok/websocket-channel/pkg/client/listers/channels/v1beta1"
 	zap "go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
 	equality "k8s.io/apimachinery/pkg/api/equality"
@@ -43,50 +43,50 @@ import (
 )
 
 // Interface defines the strongly typed interfaces to be implemented by a
-// controller reconciling v1alpha1.WebSocketChannel.
+// controller reconciling v1beta1.WebSocketChannel.
 type Interface interface {
-	// ReconcileKind implements custom logic to reconcile v1alpha1.WebSocketChannel. Any changes
+	// ReconcileKind implements custom logic to reconcile v1beta1.WebSocketChannel. Any changes
 	// to the objects .Status or .Finalizers will be propagated to the stored
 	// object. It is recommended that implementors do not call any update calls
 	// for the Kind inside of ReconcileKind, it is the responsibility of the calling
 	// controller to propagate those properties. The resource passed to ReconcileKind
 	// will always have an empty deletion timestamp.
-	ReconcileKind(ctx context.Context, o *v1alpha1.WebSocketChannel) reconciler.Event
+	ReconcileKind(ctx context.Context, o *v1beta1.WebSocketChannel) reconciler.Event
 }
 
 // Finalizer defines the strongly typed interfaces to be implemented by a
-// controller finalizing v1alpha1.WebSocketChannel.
+// controller finalizing v1beta1.WebSocketChannel.
 type Finalizer interface {
-	// FinalizeKind implements custom logic to finalize v1alpha1.WebSocketChannel. Any changes
+	// FinalizeKind implements custom logic to finalize v1beta1.WebSocketChannel. Any changes
 	// to the objects .Status or .Finalizers will be ignored. Returning a nil or
 	// Normal type reconciler.Event will allow the finalizer to be deleted on
 	// the resource. The resource passed to FinalizeKind will always have a set
 	// deletion timestamp.
-	FinalizeKind(ctx context.Context, o *v1alpha1.WebSocketChannel) reconciler.Event
+	FinalizeKind(ctx context.Context, o *v1beta1.WebSocketChannel) reconciler.Event
 }
 
 // ReadOnlyInterface defines the strongly typed interfaces to be implemented by a
-// controller reconciling v1alpha1.WebSocketChannel if they want to process resources for which
+// controller reconciling v1beta1.WebSocketChannel if they want to process resources for which
 // they are not the leader.
 type ReadOnlyInterface interface {
-	// ObserveKind implements logic to observe v1alpha1.WebSocketChannel.
+	// ObserveKind implements logic to observe v1beta1.WebSocketChannel.
 	// This method should not write to the API.
-	ObserveKind(ctx context.Context, o *v1alpha1.WebSocketChannel) reconciler.Event
+	ObserveKind(ctx context.Context, o *v1beta1.WebSocketChannel) reconciler.Event
 }
 
 // ReadOnlyFinalizer defines the strongly typed interfaces to be implemented by a
-// controller finalizing v1alpha1.WebSocketChannel if they want to process tombstoned resources
+// controller finalizing v1beta1.WebSocketChannel if they want to process tombstoned resources
 // even when they are not the leader.  Due to the nature of how finalizers are handled
 // there are no guarantees that this will be called.
 type ReadOnlyFinalizer interface {
-	// ObserveFinalizeKind implements custom logic to observe the final state of v1alpha1.WebSocketChannel.
+	// ObserveFinalizeKind implements custom logic to observe the final state of v1beta1.WebSocketChannel.
 	// This method should not write to the API.
-	ObserveFinalizeKind(ctx context.Context, o *v1alpha1.WebSocketChannel) reconciler.Event
+	ObserveFinalizeKind(ctx context.Context, o *v1beta1.WebSocketChannel) reconciler.Event
 }
 
-type doReconcile func(ctx context.Context, o *v1alpha1.WebSocketChannel) reconciler.Event
+type doReconcile func(ctx context.Context, o *v1beta1.WebSocketChannel) reconciler.Event
 
-// reconcilerImpl implements controller.Reconciler for v1alpha1.WebSocketChannel resources.
+// reconcilerImpl implements controller.Reconciler for v1beta1.WebSocketChannel resources.
 type reconcilerImpl struct {
 	// LeaderAwareFuncs is inlined to help us implement reconciler.LeaderAware
 	reconciler.LeaderAwareFuncs
@@ -95,7 +95,7 @@ type reconcilerImpl struct {
 	Client versioned.Interface
 
 	// Listers index properties about resources
-	Lister channelsv1alpha1.WebSocketChannelLister
+	Lister channelsv1beta1.WebSocketChannelLister
 
 	// Recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
@@ -122,7 +122,7 @@ var _ controller.Reconciler = (*reconcilerImpl)(nil)
 // Check that our generated Reconciler is always LeaderAware.
 var _ reconciler.LeaderAware = (*reconcilerImpl)(nil)
 
-func NewReconciler(ctx context.Context, logger *zap.SugaredLogger, client versioned.Interface, lister channelsv1alpha1.WebSocketChannelLister, recorder record.EventRecorder, r Interface, options ...controller.Options) controller.Reconciler {
+func NewReconciler(ctx context.Context, logger *zap.SugaredLogger, client versioned.Interface, lister channelsv1beta1.WebSocketChannelLister, recorder record.EventRecorder, r Interface, options ...controller.Options) controller.Reconciler {
 	// Check the options function input. It should be 0 or 1.
 	if len(options) > 1 {
 		logger.Fatal("Up to one options struct is supported, found: ", len(options))
@@ -307,13 +307,13 @@ func (r *reconcilerImpl) Reconcile(ctx context.Context, key string) error {
 	return nil
 }
 
-func (r *reconcilerImpl) updateStatus(ctx context.Context, existing *v1alpha1.WebSocketChannel, desired *v1alpha1.WebSocketChannel) error {
+func (r *reconcilerImpl) updateStatus(ctx context.Context, existing *v1beta1.WebSocketChannel, desired *v1beta1.WebSocketChannel) error {
 	existing = existing.DeepCopy()
 	return reconciler.RetryUpdateConflicts(func(attempts int) (err error) {
 		// The first iteration tries to use the injectionInformer's state, subsequent attempts fetch the latest state via API.
 		if attempts > 0 {
 
-			getter := r.Client.ChannelsV1alpha1().WebSocketChannels(desired.Namespace)
+			getter := r.Client.ChannelsV1beta1().WebSocketChannels(desired.Namespace)
 
 			existing, err = getter.Get(ctx, desired.Name, metav1.GetOptions{})
 			if err != nil {
@@ -332,7 +332,7 @@ func (r *reconcilerImpl) updateStatus(ctx context.Context, existing *v1alpha1.We
 
 		existing.Status = desired.Status
 
-		updater := r.Client.ChannelsV1alpha1().WebSocketChannels(existing.Namespace)
+		updater := r.Client.ChannelsV1beta1().WebSocketChannels(existing.Namespace)
 
 		_, err = updater.UpdateStatus(ctx, existing, metav1.UpdateOptions{})
 		return err
@@ -342,7 +342,7 @@ func (r *reconcilerImpl) updateStatus(ctx context.Context, existing *v1alpha1.We
 // updateFinalizersFiltered will update the Finalizers of the resource.
 // TODO: this method could be generic and sync all finalizers. For now it only
 // updates defaultFinalizerName or its override.
-func (r *reconcilerImpl) updateFinalizersFiltered(ctx context.Context, resource *v1alpha1.WebSocketChannel) (*v1alpha1.WebSocketChannel, error) {
+func (r *reconcilerImpl) updateFinalizersFiltered(ctx context.Context, resource *v1beta1.WebSocketChannel) (*v1beta1.WebSocketChannel, error) {
 
 	getter := r.Lister.WebSocketChannels(resource.Namespace)
 
@@ -389,7 +389,7 @@ func (r *reconcilerImpl) updateFinalizersFiltered(ctx context.Context, resource
 		return resource, err
 	}
 
-	patcher := r.Client.ChannelsV1alpha1().WebSocketChannels(resource.Namespace)
+	patcher := r.Client.ChannelsV1beta1().WebSocketChannels(resource.Namespace)
 
 	resourceName := resource.Name
 	updated, err := patcher.Patch(ctx, resourceName, types.MergePatchType, patch, metav1.PatchOptions{})
@@ -403,7 +403,7 @@ func (r *reconcilerImpl) updateFinalizersFiltered(ctx context.Context, resource
 	return updated, err
 }
 
-func (r *reconcilerImpl) setFinalizerIfFinalizer(ctx context.Context, resource *v1alpha1.WebSocketChannel) (*v1alpha1.WebSocketChannel, error) {
+func (r *reconcilerImpl) setFinalizerIfFinalizer(ctx context.Context, resource *v1beta1.WebSocketChannel) (*v1beta1.WebSocketChannel, error) {
 	if _, ok := r.reconciler.(Finalizer); !ok {
 		return resource, nil
 	}
@@ -421,7 +421,7 @@ func (r *reconcilerImpl) setFinalizerIfFinalizer(ctx context.Context, resource *
 	return r.updateFinalizersFiltered(ctx, resource)
 }
 
-func (r *reconcilerImpl) clearFinalizer(ctx context.Context, resource *v1alpha1.WebSocketChannel, reconcileEvent reconciler.Event) (*v1alpha1.WebSocketChannel, error) {
+func (r *reconcilerImpl) clearFinalizer(ctx context.Context, resource *v1beta1.WebSocketChannel, reconcileEvent reconciler.Event) (*v1beta1.WebSocketChannel, error) {
 	if _, ok := r.reconciler.(Finalizer); !ok {
 		return resource, nil
 	}
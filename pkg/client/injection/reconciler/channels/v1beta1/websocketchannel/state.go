@@ -21,7 +21,7 @@ package websocketchannel
 import (
 	fmt "fmt"
 
-	v1alpha1 "github.com/aliok/websocket-channel/pkg/apis/channels/v1alpha1"
+	v1beta1 "github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
 	types "k8s.io/apimachinery/pkg/types"
 	cache "k8s.io/client-go/tools/cache"
 	reconciler "knative.dev/pkg/reconciler"
@@ -90,7 +90,7 @@ func (s *state) isNotLeaderNorObserver() bool {
 	return false
 }
 
-func (s *state) reconcileMethodFor(o *v1alpha1.WebSocketChannel) (string, doReconcile) {
+func (s *state) reconcileMethodFor(o *v1beta1.WebSocketChannel) (string, doReconcile) {
 	if o.GetDeletionTimestamp().IsZero() {
 		if s.isLeader {
 			return reconciler.DoReconcileKind, s.reconciler.ReconcileKind
@@ -19,29 +19,39 @@ package controller
 import (
 	"context"
 
-	"github.com/aliok/websocket-channel/pkg/client/injection/informers/channels/v1alpha1/websocketchannel"
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	"github.com/aliok/websocket-channel/pkg/apis/config"
+	"github.com/aliok/websocket-channel/pkg/channel/status"
+	"github.com/aliok/websocket-channel/pkg/client/injection/informers/channels/v1beta1/websocketchannel"
 
 	"github.com/kelseyhightower/envconfig"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/cache"
 	kubeclient "knative.dev/pkg/client/injection/kube/client"
 
 	"knative.dev/pkg/client/injection/kube/informers/apps/v1/deployment"
+	"knative.dev/pkg/client/injection/kube/informers/apps/v1/statefulset"
 	"knative.dev/pkg/client/injection/kube/informers/core/v1/endpoints"
 	"knative.dev/pkg/client/injection/kube/informers/core/v1/service"
 	"knative.dev/pkg/client/injection/kube/informers/core/v1/serviceaccount"
 	"knative.dev/pkg/client/injection/kube/informers/rbac/v1/rolebinding"
 	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection/clients/dynamicclient"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/resolver"
 	"knative.dev/pkg/system"
 
-	websocketchannelreconciler "github.com/aliok/websocket-channel/pkg/client/injection/reconciler/channels/v1alpha1/websocketchannel"
+	websocketchannelreconciler "github.com/aliok/websocket-channel/pkg/client/injection/reconciler/channels/v1beta1/websocketchannel"
 )
 
 const dispatcherName = "websocket-ch-dispatcher"
 
 type envConfig struct {
 	Image string `envconfig:"DISPATCHER_IMAGE" required:"true"`
+
+	// ChannelServiceType is the type of the Services created for channels, either ExternalName or ClusterIP.
+	ChannelServiceType string `envconfig:"CHANNEL_SERVICE_TYPE" default:"ExternalName"`
 }
 
 // NewController initializes the controller and is called by the generated code.
@@ -53,6 +63,7 @@ func NewController(
 	logger := logging.FromContext(ctx)
 	websocketchannelInformer := websocketchannel.Get(ctx)
 	deploymentInformer := deployment.Get(ctx)
+	statefulSetInformer := statefulset.Get(ctx)
 	serviceInformer := service.Get(ctx)
 	endpointsInformer := endpoints.Get(ctx)
 	serviceAccountInformer := serviceaccount.Get(ctx)
@@ -60,14 +71,17 @@ func NewController(
 
 	r := &Reconciler{
 		kubeClientSet:            kubeclient.Get(ctx),
+		dynamicClientSet:         dynamicclient.Get(ctx),
 		systemNamespace:          system.Namespace(),
 		websocketchannelLister:   websocketchannelInformer.Lister(),
 		websocketchannelInformer: websocketchannelInformer.Informer(),
 		deploymentLister:         deploymentInformer.Lister(),
+		statefulSetLister:        statefulSetInformer.Lister(),
 		serviceLister:            serviceInformer.Lister(),
 		endpointsLister:          endpointsInformer.Lister(),
 		serviceAccountLister:     serviceAccountInformer.Lister(),
 		roleBindingLister:        roleBindingInformer.Lister(),
+		prober:                   status.NewProber(),
 	}
 
 	env := &envConfig{}
@@ -81,7 +95,24 @@ func NewController(
 
 	r.dispatcherImage = env.Image
 
-	impl := websocketchannelreconciler.NewImpl(ctx, r)
+	switch corev1.ServiceType(env.ChannelServiceType) {
+	case corev1.ServiceTypeExternalName, corev1.ServiceTypeClusterIP:
+		r.channelServiceType = corev1.ServiceType(env.ChannelServiceType)
+	default:
+		logger.Panicf("unsupported CHANNEL_SERVICE_TYPE %q, expected %q or %q", env.ChannelServiceType, corev1.ServiceTypeExternalName, corev1.ServiceTypeClusterIP)
+	}
+
+	impl := websocketchannelreconciler.NewImpl(ctx, r, func(impl *controller.Impl) controller.Options {
+		// Reconcile all channels again when the cluster-wide configuration changes.
+		configStore := config.NewStore(logger.Named("config-store"), func(name string, value interface{}) {
+			impl.GlobalResync(websocketchannelInformer.Informer())
+		})
+		configStore.WatchConfigs(cmw)
+		return controller.Options{ConfigStore: configStore}
+	})
+
+	r.uriResolver = resolver.NewURIResolver(ctx, impl.EnqueueKey)
+	r.enqueueAfter = impl.EnqueueAfter
 
 	logger.Info("Setting up event handlers")
 	websocketchannelInformer.Informer().AddEventHandler(controller.HandleAll(impl.Enqueue))
@@ -101,6 +132,10 @@ func NewController(
 		FilterFunc: controller.FilterWithName(dispatcherName),
 		Handler:    controller.HandleAll(grCh),
 	})
+	statefulSetInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: controller.FilterWithName(dispatcherName),
+		Handler:    controller.HandleAll(grCh),
+	})
 	serviceInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
 		FilterFunc: controller.FilterWithName(dispatcherName),
 		Handler:    controller.HandleAll(grCh),
@@ -109,6 +144,11 @@ func NewController(
 		FilterFunc: controller.FilterWithName(dispatcherName),
 		Handler:    controller.HandleAll(grCh),
 	})
+	// Watch the endpoints mirrored for ClusterIP channel services.
+	endpointsInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: controller.FilterControllerGK(v1beta1.Kind("WebSocketChannel")),
+		Handler:    controller.HandleAll(impl.EnqueueControllerOf),
+	})
 	serviceAccountInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
 		FilterFunc: controller.FilterWithName(dispatcherName),
 		Handler:    controller.HandleAll(grCh),
@@ -1,9 +1,15 @@
 package controller
 
 import (
-	"github.com/aliok/websocket-channel/pkg/apis/channels/v1alpha1"
+	"fmt"
+	"time"
+
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"knative.dev/pkg/kmeta"
 	"knative.dev/pkg/network"
 )
@@ -13,8 +19,19 @@ const (
 	PortNumber         = 80
 	MessagingRoleLabel = "messaging.knative.dev/role"
 	MessagingRole      = "websocket-channel"
+
+	// DispatcherPortName is the name of the port of the dispatcher Service events are sent to.
+	DispatcherPortName = "http-dispatcher"
+
+	// exposureTimeout is the read and write timeout of Ingresses and HTTPRoutes exposing channels.
+	// It matches the timeouts of the dispatcher, so long lived connections aren't cut by the proxy.
+	exposureTimeout = 15 * time.Minute
 )
 
+// httpRouteGVR is the Gateway API HTTPRoute resource. There is no typed client for it, so HTTPRoutes
+// are managed as unstructured objects.
+var httpRouteGVR = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+
 // ServiceOption can be used to optionally modify the K8s service in CreateK8sService
 type K8sServiceOption func(*corev1.Service) error
 
@@ -34,10 +51,61 @@ func externalService(namespace, service string) K8sServiceOption {
 	}
 }
 
+// clusterIPService is a functional option for CreateK8sService to create a K8s service of type ClusterIP
+// without a selector. Its endpoints are mirrored from the dispatcher service, see newK8sEndpoints.
+func clusterIPService() K8sServiceOption {
+	return func(svc *corev1.Service) error {
+		svc.Spec.Type = corev1.ServiceTypeClusterIP
+		return nil
+	}
+}
+
+// newK8sEndpoints creates the Endpoints of the ClusterIP Service of a Channel resource, mirroring the
+// addresses of the dispatcher Endpoints. Only the DispatcherPortName port is mirrored, renamed after
+// the port of the channel Service, so other ports of the dispatcher are not exposed.
+func newK8sEndpoints(wsc *v1beta1.WebSocketChannel, dispatcher *corev1.Endpoints) *corev1.Endpoints {
+	subsets := make([]corev1.EndpointSubset, 0, len(dispatcher.Subsets))
+	for _, subset := range dispatcher.Subsets {
+		subset := subset.DeepCopy()
+		for _, port := range subset.Ports {
+			if port.Name != DispatcherPortName {
+				continue
+			}
+			subsets = append(subsets, corev1.EndpointSubset{
+				Addresses:         subset.Addresses,
+				NotReadyAddresses: subset.NotReadyAddresses,
+				Ports: []corev1.EndpointPort{{
+					Name:     PortName,
+					Port:     port.Port,
+					Protocol: port.Protocol,
+				}},
+			})
+		}
+	}
+
+	return &corev1.Endpoints{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Endpoints",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      createChannelServiceName(wsc.ObjectMeta.Name),
+			Namespace: wsc.Namespace,
+			Labels: map[string]string{
+				MessagingRoleLabel: MessagingRole,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*kmeta.NewControllerRef(wsc),
+			},
+		},
+		Subsets: subsets,
+	}
+}
+
 // newK8sService creates a new Service for a Channel resource. It also sets the appropriate
 // OwnerReferences on the resource so handleObject can discover the Channel resource that 'owns' it.
 // As well as being garbage collected when the Channel is deleted.
-func newK8sService(wsc *v1alpha1.WebSocketChannel, opts ...K8sServiceOption) (*corev1.Service, error) {
+func newK8sService(wsc *v1beta1.WebSocketChannel, opts ...K8sServiceOption) (*corev1.Service, error) {
 	// Add annotations
 	svc := &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
@@ -71,3 +139,113 @@ func newK8sService(wsc *v1alpha1.WebSocketChannel, opts ...K8sServiceOption) (*c
 	}
 	return svc, nil
 }
+
+// newIngress creates the Ingress exposing a Channel resource outside the cluster. It routes the external
+// host to the channel Service. The dispatcher handles the external host as an alias of the channel.
+func newIngress(wsc *v1beta1.WebSocketChannel) *networkingv1.Ingress {
+	exposure := wsc.Spec.Exposure
+	pathType := networkingv1.PathTypePrefix
+	timeout := fmt.Sprint(int(exposureTimeout.Seconds()))
+
+	ingress := &networkingv1.Ingress{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "Ingress",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      createChannelServiceName(wsc.ObjectMeta.Name),
+			Namespace: wsc.Namespace,
+			Labels: map[string]string{
+				MessagingRoleLabel: MessagingRole,
+			},
+			Annotations: map[string]string{
+				"nginx.ingress.kubernetes.io/proxy-read-timeout": timeout,
+				"nginx.ingress.kubernetes.io/proxy-send-timeout": timeout,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*kmeta.NewControllerRef(wsc),
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: exposure.Ingress.ClassName,
+			Rules: []networkingv1.IngressRule{{
+				Host: exposure.Host,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{{
+							Path:     "/",
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: createChannelServiceName(wsc.ObjectMeta.Name),
+									Port: networkingv1.ServiceBackendPort{Name: PortName},
+								},
+							},
+						}},
+					},
+				},
+			}},
+		},
+	}
+	if exposure.Ingress.TLSSecretName != "" {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{{
+			Hosts:      []string{exposure.Host},
+			SecretName: exposure.Ingress.TLSSecretName,
+		}}
+	}
+	return ingress
+}
+
+// newHTTPRoute creates the Gateway API HTTPRoute exposing a Channel resource outside the cluster. It routes
+// the external host to the channel Service. The dispatcher handles the external host as an alias of the channel.
+func newHTTPRoute(wsc *v1beta1.WebSocketChannel) *unstructured.Unstructured {
+	exposure := wsc.Spec.Exposure
+	gatewayNamespace := exposure.HTTPRoute.GatewayNamespace
+	if gatewayNamespace == "" {
+		gatewayNamespace = wsc.Namespace
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{
+				map[string]interface{}{
+					"name":      exposure.HTTPRoute.GatewayName,
+					"namespace": gatewayNamespace,
+				},
+			},
+			"hostnames": []interface{}{exposure.Host},
+			"rules": []interface{}{
+				map[string]interface{}{
+					"matches": []interface{}{
+						map[string]interface{}{
+							"path": map[string]interface{}{
+								"type":  "PathPrefix",
+								"value": "/",
+							},
+						},
+					},
+					"backendRefs": []interface{}{
+						map[string]interface{}{
+							"name": createChannelServiceName(wsc.ObjectMeta.Name),
+							"port": int64(PortNumber),
+						},
+					},
+					"timeouts": map[string]interface{}{
+						"request": exposureTimeout.String(),
+					},
+				},
+			},
+		},
+	}}
+	route.SetAPIVersion(httpRouteGVR.GroupVersion().String())
+	route.SetKind("HTTPRoute")
+	route.SetName(createChannelServiceName(wsc.ObjectMeta.Name))
+	route.SetNamespace(wsc.Namespace)
+	route.SetLabels(map[string]string{
+		MessagingRoleLabel: MessagingRole,
+	})
+	route.SetOwnerReferences([]metav1.OwnerReference{
+		*kmeta.NewControllerRef(wsc),
+	})
+	return route
+}
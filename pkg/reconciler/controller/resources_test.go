@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewK8sEndpoints(t *testing.T) {
+	wsc := &v1beta1.WebSocketChannel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "channel"}}
+	ready := []corev1.EndpointAddress{{IP: "10.0.0.1"}}
+	notReady := []corev1.EndpointAddress{{IP: "10.0.0.2"}}
+
+	tests := map[string]struct {
+		subsets []corev1.EndpointSubset
+		want    []corev1.EndpointSubset
+	}{
+		"no endpoints": {
+			want: []corev1.EndpointSubset{},
+		},
+		"dispatcher port": {
+			subsets: []corev1.EndpointSubset{{
+				Addresses:         ready,
+				NotReadyAddresses: notReady,
+				Ports:             []corev1.EndpointPort{{Name: DispatcherPortName, Port: 8080, Protocol: corev1.ProtocolTCP}},
+			}},
+			want: []corev1.EndpointSubset{{
+				Addresses:         ready,
+				NotReadyAddresses: notReady,
+				Ports:             []corev1.EndpointPort{{Name: PortName, Port: 8080, Protocol: corev1.ProtocolTCP}},
+			}},
+		},
+		"multiple ports": {
+			subsets: []corev1.EndpointSubset{{
+				Addresses: ready,
+				Ports: []corev1.EndpointPort{
+					{Name: "http-metrics", Port: 9090, Protocol: corev1.ProtocolTCP},
+					{Name: DispatcherPortName, Port: 8080, Protocol: corev1.ProtocolTCP},
+					{Name: "http-admin", Port: 8081, Protocol: corev1.ProtocolTCP},
+				},
+			}},
+			want: []corev1.EndpointSubset{{
+				Addresses: ready,
+				Ports:     []corev1.EndpointPort{{Name: PortName, Port: 8080, Protocol: corev1.ProtocolTCP}},
+			}},
+		},
+		"subset without the dispatcher port": {
+			subsets: []corev1.EndpointSubset{{
+				Addresses: ready,
+				Ports:     []corev1.EndpointPort{{Name: "http-metrics", Port: 9090, Protocol: corev1.ProtocolTCP}},
+			}, {
+				Addresses: notReady,
+				Ports:     []corev1.EndpointPort{{Name: DispatcherPortName, Port: 8080, Protocol: corev1.ProtocolTCP}},
+			}},
+			want: []corev1.EndpointSubset{{
+				Addresses: notReady,
+				Ports:     []corev1.EndpointPort{{Name: PortName, Port: 8080, Protocol: corev1.ProtocolTCP}},
+			}},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := newK8sEndpoints(wsc, &corev1.Endpoints{Subsets: test.subsets})
+			if got.Name != createChannelServiceName(wsc.Name) || got.Namespace != wsc.Namespace {
+				t.Errorf("Endpoints = %s/%s, want the channel Service", got.Namespace, got.Name)
+			}
+			if diff := cmp.Diff(test.want, got.Subsets); diff != "" {
+				t.Error("Subsets (-want, +got) =", diff)
+			}
+		})
+	}
+}
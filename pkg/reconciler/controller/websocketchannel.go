@@ -4,19 +4,26 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
-	"github.com/aliok/websocket-channel/pkg/apis/channels/v1alpha1"
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	"github.com/aliok/websocket-channel/pkg/apis/config"
+	"github.com/aliok/websocket-channel/pkg/channel/status"
 	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/network"
+	"knative.dev/pkg/resolver"
 
 	appsv1listers "k8s.io/client-go/listers/apps/v1"
 	corev1listers "k8s.io/client-go/listers/core/v1"
@@ -24,44 +31,51 @@ import (
 
 	pkgreconciler "knative.dev/pkg/reconciler"
 
-	websocketchannelreconciler "github.com/aliok/websocket-channel/pkg/client/injection/reconciler/channels/v1alpha1/websocketchannel"
-	listers "github.com/aliok/websocket-channel/pkg/client/listers/channels/v1alpha1"
+	websocketchannelreconciler "github.com/aliok/websocket-channel/pkg/client/injection/reconciler/channels/v1beta1/websocketchannel"
+	listers "github.com/aliok/websocket-channel/pkg/client/listers/channels/v1beta1"
 )
 
+// probeRetryDelay is the time after which a channel not loaded by every dispatcher replica is probed again.
+const probeRetryDelay = time.Second
+
 type Reconciler struct {
-	kubeClientSet kubernetes.Interface
+	kubeClientSet    kubernetes.Interface
+	dynamicClientSet dynamic.Interface
 
 	systemNamespace          string
 	dispatcherImage          string
+	channelServiceType       corev1.ServiceType
 	websocketchannelLister   listers.WebSocketChannelLister
 	websocketchannelInformer cache.SharedIndexInformer
 	deploymentLister         appsv1listers.DeploymentLister
+	statefulSetLister        appsv1listers.StatefulSetLister
 	serviceLister            corev1listers.ServiceLister
 	endpointsLister          corev1listers.EndpointsLister
 	serviceAccountLister     corev1listers.ServiceAccountLister
 	roleBindingLister        rbacv1listers.RoleBindingLister
+	uriResolver              *resolver.URIResolver
+	prober                   *status.Prober
+	enqueueAfter             func(interface{}, time.Duration)
 }
 
 // Check that our Reconciler implements Interface
 var _ websocketchannelreconciler.Interface = (*Reconciler)(nil)
 
-func (r *Reconciler) ReconcileKind(ctx context.Context, wsc *v1alpha1.WebSocketChannel) pkgreconciler.Event {
+func (r *Reconciler) ReconcileKind(ctx context.Context, wsc *v1beta1.WebSocketChannel) pkgreconciler.Event {
 
 	// Make sure the dispatcher deployment exists and propagate the status to the Channel
 	// For namespace-scope dispatcher, make sure configuration files exist and RBAC is properly configured.
-	d, err := r.reconcileDispatcher(ctx, r.systemNamespace, wsc)
-	if err != nil {
+	if err := r.reconcileDispatcher(ctx, r.systemNamespace, wsc); err != nil {
 		logging.FromContext(ctx).Errorw("Failed to reconcile WebSocketChannel dispatcher", zap.Error(err))
 		return err
 	}
-	wsc.Status.PropagateDispatcherStatus(&d.Status)
 
 
 
 	// Make sure the dispatcher service exists and propagate the status to the Channel in case it does not exist.
 	// We don't do anything with the service because it's status contains nothing useful, so just do
 	// an existence check. Then below we check the endpoints targeting it.
-	_, err = r.reconcileDispatcherService(ctx, r.systemNamespace, wsc)
+	_, err := r.reconcileDispatcherService(ctx, r.systemNamespace, wsc)
 	if err != nil {
 		logging.FromContext(ctx).Errorw("Failed to reconcile WebSocketChannel dispatcher service", zap.Error(err))
 		return err
@@ -94,17 +108,50 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, wsc *v1alpha1.WebSocketC
 
 
 	// Reconcile the k8s service representing the actual Channel. It points to the Dispatcher service via
-	// ExternalName
+	// ExternalName, or via endpoints mirrored from the Dispatcher service.
 	svc, err := r.reconcileChannelService(ctx, r.systemNamespace, wsc)
 	if err != nil {
 		logging.FromContext(ctx).Errorw("Failed to reconcile channel service", zap.Error(err))
 		return err
 	}
+	if err := r.reconcileChannelEndpoints(ctx, wsc, e); err != nil {
+		logging.FromContext(ctx).Errorw("Failed to reconcile channel endpoints", zap.Error(err))
+		return err
+	}
 	wsc.Status.MarkChannelServiceTrue()
 	wsc.Status.SetAddress(apis.HTTP(network.GetServiceHostname(svc.Name, svc.Namespace)))
 
 
 
+	// Expose the channel outside the cluster, if requested.
+	if err := r.reconcileExposure(ctx, wsc); err != nil {
+		logging.FromContext(ctx).Errorw("Failed to reconcile channel exposure", zap.Error(err))
+		return err
+	}
+
+
+
+	// Resolve the dead letter sink of the channel, if any, so the dispatcher can route events
+	// that exhausted their retries there.
+	if err := r.reconcileDeadLetterSink(ctx, wsc); err != nil {
+		logging.FromContext(ctx).Errorw("Failed to resolve dead letter sink", zap.Error(err))
+		return err
+	}
+
+
+
+	// Make sure every dispatcher replica has loaded the channel before marking it ready. Otherwise
+	// publishers racing a freshly created channel get errors from the dispatcher.
+	if err := r.prober.Probe(ctx, e, wsc.Status.Address.URL.Host, wsc.Generation); err != nil {
+		logging.FromContext(ctx).Infow("Channel is not loaded by every dispatcher replica yet", zap.Error(err))
+		wsc.Status.MarkDispatcherLoadedUnknown("DispatcherNotLoaded", fmt.Sprint("Channel is not loaded by every dispatcher replica: ", err))
+		r.enqueueAfter(wsc, probeRetryDelay)
+		return nil
+	}
+	wsc.Status.MarkDispatcherLoadedTrue()
+
+
+
 	// Ok, so now the Dispatcher Deployment & Service have been created, we're golden since the
 	// dispatcher watches the Channel and where it needs to dispatch events to.
 	logging.FromContext(ctx).Debugw("Reconciled WebSocketChannel", zap.Any("WebSocketChannel", wsc))
@@ -112,21 +159,38 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, wsc *v1alpha1.WebSocketC
 
 }
 
-func (r *Reconciler) reconcileDispatcher(ctx context.Context, dispatcherNamespace string, wsc *v1alpha1.WebSocketChannel) (*appsv1.Deployment, error) {
+// reconcileDispatcher propagates the status of the dispatcher to the channel. The dispatcher is
+// either a Deployment, or a StatefulSet with a persistent volume for the write-ahead logs of
+// durable channels, see config/durable. Durable channels need the latter.
+func (r *Reconciler) reconcileDispatcher(ctx context.Context, dispatcherNamespace string, wsc *v1beta1.WebSocketChannel) error {
 	d, err := r.deploymentLister.Deployments(dispatcherNamespace).Get(dispatcherName)
-	if err != nil {
-		if apierrs.IsNotFound(err) {
-			wsc.Status.MarkDispatcherFailed("DispatcherDeploymentDoesNotExist", "Dispatcher Deployment does not exist")
-		} else {
-			logging.FromContext(ctx).Error("Unable to get the dispatcher Deployment", zap.Error(err))
-			wsc.Status.MarkDispatcherFailed("DispatcherDeploymentGetFailed", "Failed to get dispatcher Deployment")
+	if err == nil {
+		if wsc.Spec.Durable {
+			wsc.Status.MarkDispatcherFailed("DispatcherNotDurable", "Durable channels need the dispatcher StatefulSet with a persistent volume, found a Deployment")
+			return nil
+		}
+		wsc.Status.PropagateDispatcherStatus(&d.Status)
+		return nil
+	}
+	if apierrs.IsNotFound(err) {
+		var ss *appsv1.StatefulSet
+		ss, err = r.statefulSetLister.StatefulSets(dispatcherNamespace).Get(dispatcherName)
+		if err == nil {
+			wsc.Status.PropagateDispatcherStatefulSetStatus(ss)
+			return nil
 		}
-		return nil, newDeploymentWarn(err)
 	}
-	return d, nil
+
+	if apierrs.IsNotFound(err) {
+		wsc.Status.MarkDispatcherFailed("DispatcherDeploymentDoesNotExist", "Dispatcher Deployment does not exist")
+	} else {
+		logging.FromContext(ctx).Error("Unable to get the dispatcher Deployment", zap.Error(err))
+		wsc.Status.MarkDispatcherFailed("DispatcherDeploymentGetFailed", "Failed to get dispatcher Deployment")
+	}
+	return newDeploymentWarn(err)
 }
 
-func (r *Reconciler) reconcileDispatcherService(ctx context.Context, dispatcherNamespace string, wsc *v1alpha1.WebSocketChannel) (*corev1.Service, error) {
+func (r *Reconciler) reconcileDispatcherService(ctx context.Context, dispatcherNamespace string, wsc *v1beta1.WebSocketChannel) (*corev1.Service, error) {
 	svc, err := r.serviceLister.Services(dispatcherNamespace).Get(dispatcherName)
 	if err != nil {
 		if apierrs.IsNotFound(err) {
@@ -140,12 +204,16 @@ func (r *Reconciler) reconcileDispatcherService(ctx context.Context, dispatcherN
 	return svc, nil
 }
 
-func (r *Reconciler) reconcileChannelService(ctx context.Context, dispatcherNamespace string, wsc *v1alpha1.WebSocketChannel) (*corev1.Service, error) {
+func (r *Reconciler) reconcileChannelService(ctx context.Context, dispatcherNamespace string, wsc *v1beta1.WebSocketChannel) (*corev1.Service, error) {
 	// Get the  Service and propagate the status to the Channel in case it does not exist.
 	// We don't do anything with the service because it's status contains nothing useful, so just do
 	// an existence check. Then below we check the endpoints targeting it.
 	// We may change this name later, so we have to ensure we use proper addressable when resolving these.
-	expected, err := newK8sService(wsc, externalService(dispatcherNamespace, dispatcherName))
+	serviceOption := externalService(dispatcherNamespace, dispatcherName)
+	if r.channelServiceType == corev1.ServiceTypeClusterIP {
+		serviceOption = clusterIPService()
+	}
+	expected, err := newK8sService(wsc, serviceOption)
 	if err != nil {
 		logging.FromContext(ctx).Error("failed to create the channel service object", zap.Error(err))
 		wsc.Status.MarkChannelServiceFailed("ChannelServiceFailed", fmt.Sprint("Channel Service failed: ", err))
@@ -168,7 +236,9 @@ func (r *Reconciler) reconcileChannelService(ctx context.Context, dispatcherName
 		logging.FromContext(ctx).Error("Unable to get the channel service", zap.Error(err))
 		wsc.Status.MarkChannelServiceUnknown("ChannelServiceGetFailed", fmt.Sprint("Unable to get the channel service: ", err))
 		return nil, err
-	} else if !equality.Semantic.DeepEqual(svc.Spec, expected.Spec) {
+	} else if !equality.Semantic.DeepDerivative(expected.Spec, svc.Spec) {
+		// Fields defaulted by the API server, such as the cluster IP, are ignored by the comparison above.
+		// They are dropped by the update as well, which is what switching between service types requires.
 		svc = svc.DeepCopy()
 		svc.Spec = expected.Spec
 
@@ -189,6 +259,257 @@ func (r *Reconciler) reconcileChannelService(ctx context.Context, dispatcherName
 	return svc, nil
 }
 
+// reconcileChannelEndpoints mirrors the dispatcher endpoints into the endpoints of a ClusterIP channel
+// service. Mirrored endpoints left over from a ClusterIP channel service are deleted otherwise.
+func (r *Reconciler) reconcileChannelEndpoints(ctx context.Context, wsc *v1beta1.WebSocketChannel, dispatcherEndpoints *corev1.Endpoints) error {
+	channelSvcName := createChannelServiceName(wsc.Name)
+
+	e, err := r.endpointsLister.Endpoints(wsc.Namespace).Get(channelSvcName)
+	if err != nil && !apierrs.IsNotFound(err) {
+		logging.FromContext(ctx).Error("Unable to get the channel endpoints", zap.Error(err))
+		wsc.Status.MarkChannelServiceUnknown("ChannelEndpointsGetFailed", fmt.Sprint("Unable to get the channel endpoints: ", err))
+		return err
+	}
+	exists := err == nil
+
+	if r.channelServiceType != corev1.ServiceTypeClusterIP {
+		if exists && metav1.IsControlledBy(e, wsc) {
+			err := r.kubeClientSet.CoreV1().Endpoints(wsc.Namespace).Delete(ctx, channelSvcName, metav1.DeleteOptions{})
+			if err != nil && !apierrs.IsNotFound(err) {
+				logging.FromContext(ctx).Error("failed to delete the channel endpoints", zap.Error(err))
+				wsc.Status.MarkChannelServiceFailed("ChannelEndpointsFailed", fmt.Sprint("Channel Endpoints failed: ", err))
+				return err
+			}
+		}
+		return nil
+	}
+
+	expected := newK8sEndpoints(wsc, dispatcherEndpoints)
+	if !exists {
+		_, err = r.kubeClientSet.CoreV1().Endpoints(wsc.Namespace).Create(ctx, expected, metav1.CreateOptions{})
+		if err != nil {
+			logging.FromContext(ctx).Error("failed to create the channel endpoints", zap.Error(err))
+			wsc.Status.MarkChannelServiceFailed("ChannelEndpointsFailed", fmt.Sprint("Channel Endpoints failed: ", err))
+			return err
+		}
+		return nil
+	}
+
+	// Check to make sure that our channel owns these endpoints and if not, complain.
+	if !metav1.IsControlledBy(e, wsc) {
+		err := fmt.Errorf("websocketchannel: %s/%s does not own Endpoints: %q", wsc.Namespace, wsc.Name, e.Name)
+		wsc.Status.MarkChannelServiceFailed("ChannelEndpointsFailed", fmt.Sprint("Channel Endpoints failed: ", err))
+		return err
+	}
+
+	if !equality.Semantic.DeepEqual(e.Subsets, expected.Subsets) {
+		e = e.DeepCopy()
+		e.Subsets = expected.Subsets
+		_, err = r.kubeClientSet.CoreV1().Endpoints(wsc.Namespace).Update(ctx, e, metav1.UpdateOptions{})
+		if err != nil {
+			logging.FromContext(ctx).Error("failed to update the channel endpoints", zap.Error(err))
+			wsc.Status.MarkChannelServiceFailed("ChannelEndpointsFailed", fmt.Sprint("Channel Endpoints failed: ", err))
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileExposure reconciles the Ingress or HTTPRoute exposing the channel outside the cluster, and
+// deletes the one not in use anymore.
+func (r *Reconciler) reconcileExposure(ctx context.Context, wsc *v1beta1.WebSocketChannel) error {
+	exposure := wsc.Spec.Exposure
+
+	var err error
+	if exposure == nil || exposure.Ingress == nil {
+		err = r.deleteIngress(ctx, wsc)
+	} else {
+		err = r.reconcileIngress(ctx, wsc)
+	}
+	if err != nil {
+		wsc.Status.MarkExposureFailed("IngressFailed", fmt.Sprint("Ingress failed: ", err))
+		return err
+	}
+
+	if exposure == nil || exposure.HTTPRoute == nil {
+		err = r.deleteHTTPRoute(ctx, wsc)
+	} else {
+		err = r.reconcileHTTPRoute(ctx, wsc)
+	}
+	if err != nil {
+		wsc.Status.MarkExposureFailed("HTTPRouteFailed", fmt.Sprint("HTTPRoute failed: ", err))
+		return err
+	}
+
+	if exposure == nil {
+		wsc.Status.MarkExposureNotConfigured()
+		return nil
+	}
+
+	// The dispatcher only serves HTTP, the external URL is http or https.
+	scheme := "http"
+	if (exposure.Ingress != nil && exposure.Ingress.TLSSecretName != "") || (exposure.HTTPRoute != nil && exposure.HTTPRoute.TLS) {
+		scheme = "https"
+	}
+	wsc.Status.MarkExposed(&apis.URL{Scheme: scheme, Host: exposure.Host})
+	return nil
+}
+
+func (r *Reconciler) reconcileIngress(ctx context.Context, wsc *v1beta1.WebSocketChannel) error {
+	expected := newIngress(wsc)
+
+	ingress, err := r.kubeClientSet.NetworkingV1().Ingresses(wsc.Namespace).Get(ctx, expected.Name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		_, err = r.kubeClientSet.NetworkingV1().Ingresses(wsc.Namespace).Create(ctx, expected, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(ingress, wsc) {
+		return fmt.Errorf("websocketchannel: %s/%s does not own Ingress: %q", wsc.Namespace, wsc.Name, ingress.Name)
+	}
+	if equality.Semantic.DeepDerivative(expected.Spec, ingress.Spec) &&
+		equality.Semantic.DeepDerivative(expected.Annotations, ingress.Annotations) {
+		return nil
+	}
+
+	ingress = ingress.DeepCopy()
+	ingress.Spec = expected.Spec
+	if ingress.Annotations == nil {
+		ingress.Annotations = make(map[string]string, len(expected.Annotations))
+	}
+	for k, v := range expected.Annotations {
+		ingress.Annotations[k] = v
+	}
+	_, err = r.kubeClientSet.NetworkingV1().Ingresses(wsc.Namespace).Update(ctx, ingress, metav1.UpdateOptions{})
+	return err
+}
+
+func (r *Reconciler) deleteIngress(ctx context.Context, wsc *v1beta1.WebSocketChannel) error {
+	// Nothing can be left over from a channel that was never exposed.
+	if wsc.Status.ExternalURL == nil {
+		return nil
+	}
+
+	name := createChannelServiceName(wsc.Name)
+	ingress, err := r.kubeClientSet.NetworkingV1().Ingresses(wsc.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(ingress, wsc) {
+		return nil
+	}
+	err = r.kubeClientSet.NetworkingV1().Ingresses(wsc.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *Reconciler) reconcileHTTPRoute(ctx context.Context, wsc *v1beta1.WebSocketChannel) error {
+	expected := newHTTPRoute(wsc)
+	client := r.dynamicClientSet.Resource(httpRouteGVR).Namespace(wsc.Namespace)
+
+	route, err := client.Get(ctx, expected.GetName(), metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		_, err = client.Create(ctx, expected, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(route, wsc) {
+		return fmt.Errorf("websocketchannel: %s/%s does not own HTTPRoute: %q", wsc.Namespace, wsc.Name, route.GetName())
+	}
+	if equality.Semantic.DeepDerivative(expected.Object["spec"], route.Object["spec"]) {
+		return nil
+	}
+
+	route = route.DeepCopy()
+	route.Object["spec"] = expected.Object["spec"]
+	_, err = client.Update(ctx, route, metav1.UpdateOptions{})
+	return err
+}
+
+func (r *Reconciler) deleteHTTPRoute(ctx context.Context, wsc *v1beta1.WebSocketChannel) error {
+	// Nothing can be left over from a channel that was never exposed.
+	if wsc.Status.ExternalURL == nil {
+		return nil
+	}
+
+	name := createChannelServiceName(wsc.Name)
+	client := r.dynamicClientSet.Resource(httpRouteGVR).Namespace(wsc.Namespace)
+	route, err := client.Get(ctx, name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		// Either there is no such HTTPRoute, or the Gateway API is not installed.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(route, wsc) {
+		return nil
+	}
+	err = client.Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *Reconciler) reconcileDeadLetterSink(ctx context.Context, wsc *v1beta1.WebSocketChannel) error {
+	// Channels without a delivery spec use the cluster-wide one.
+	delivery := wsc.Spec.Delivery
+	if delivery == nil {
+		delivery = config.FromContextOrDefaults(ctx).Channel.Delivery
+	}
+	if delivery == nil || delivery.DeadLetterSink == nil {
+		wsc.Status.MarkDeadLetterSinkNotConfigured()
+		return nil
+	}
+
+	dls := delivery.DeadLetterSink.DeepCopy()
+	if dls.Ref != nil && dls.Ref.Namespace == "" {
+		dls.Ref.Namespace = wsc.Namespace
+	}
+
+	if isSelfReference(wsc, dls.Ref) {
+		err := fmt.Errorf("websocketchannel: %s/%s cannot be its own dead letter sink", wsc.Namespace, wsc.Name)
+		wsc.Status.MarkDeadLetterSinkResolvedFailed("DeadLetterSinkIsSelf", fmt.Sprint("Failed to resolve dead letter sink: ", err))
+		return newDeadLetterSinkWarn(err)
+	}
+
+	uri, err := r.uriResolver.URIFromDestinationV1(ctx, *dls, wsc)
+	if err != nil {
+		wsc.Status.MarkDeadLetterSinkResolvedFailed("DeadLetterSinkResolveFailed", fmt.Sprint("Failed to resolve dead letter sink: ", err))
+		return newDeadLetterSinkWarn(err)
+	}
+
+	var dlc *duckv1.KReference
+	if isWebSocketChannel(dls.Ref) {
+		dlc = dls.Ref
+	}
+	wsc.Status.MarkDeadLetterSinkResolvedSucceeded(uri, dlc)
+	return nil
+}
+
+func isWebSocketChannel(ref *duckv1.KReference) bool {
+	if ref == nil {
+		return false
+	}
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return false
+	}
+	return gv.WithKind(ref.Kind).GroupKind() == v1beta1.Kind("WebSocketChannel")
+}
+
+func isSelfReference(wsc *v1beta1.WebSocketChannel, ref *duckv1.KReference) bool {
+	return isWebSocketChannel(ref) && ref.Namespace == wsc.Namespace && ref.Name == wsc.Name
+}
+
 func newDeploymentWarn(err error) pkgreconciler.Event {
 	return pkgreconciler.NewEvent(corev1.EventTypeWarning, "DispatcherDeploymentFailed", "Reconciling dispatcher Deployment failed with: %s", err)
 }
@@ -196,3 +517,7 @@ func newDeploymentWarn(err error) pkgreconciler.Event {
 func newServiceWarn(err error) pkgreconciler.Event {
 	return pkgreconciler.NewEvent(corev1.EventTypeWarning, "DispatcherServiceFailed", "Reconciling dispatcher Service failed: %s", err)
 }
+
+func newDeadLetterSinkWarn(err error) pkgreconciler.Event {
+	return pkgreconciler.NewEvent(corev1.EventTypeWarning, "DeadLetterSinkResolveFailed", "Resolving dead letter sink failed: %s", err)
+}
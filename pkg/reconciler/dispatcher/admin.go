@@ -0,0 +1,152 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	nethttp "net/http"
+	"sort"
+)
+
+const (
+	// adminPort is the port the admin API is served on, apart from the ports reachable by
+	// publishers and the other replicas.
+	adminPort = 8082
+
+	adminChannelsPath = "/channels"
+	adminPausePath    = "/subscribers/pause"
+	adminResumePath   = "/subscribers/resume"
+)
+
+// adminChannel is a channel loaded by this dispatcher replica, as listed by the admin API.
+type adminChannel struct {
+	Host         string            `json:"host"`
+	Generation   int64             `json:"generation"`
+	ExternalHost string            `json:"externalHost,omitempty"`
+	InFlight     int64             `json:"inFlight"`
+	Subscribers  []adminSubscriber `json:"subscribers"`
+}
+
+// adminSubscriber is a fanout.Subscription of a channel, as listed by the admin API.
+type adminSubscriber struct {
+	// Key identifies the subscriber in the pause and resume actions.
+	Key        string            `json:"key"`
+	Subscriber string            `json:"subscriber,omitempty"`
+	Reply      string            `json:"reply,omitempty"`
+	DeadLetter string            `json:"deadLetter,omitempty"`
+	Paused     bool              `json:"paused"`
+	Health     *subscriberHealth `json:"health,omitempty"`
+}
+
+// adminHandler serves the admin API of this dispatcher replica. Every request has to carry the
+// given token as a bearer token.
+//
+//	GET  /channels                                     lists the loaded channels and their subscribers
+//	POST /subscribers/pause?channel=<host>&key=<key>   holds the deliveries to a subscriber
+//	POST /subscribers/resume?channel=<host>&key=<key>  delivers the held events to a paused subscriber
+func (r *Reconciler) adminHandler(token string) nethttp.Handler {
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc(adminChannelsPath, func(response nethttp.ResponseWriter, request *nethttp.Request) {
+		if request.Method != nethttp.MethodGet {
+			response.WriteHeader(nethttp.StatusMethodNotAllowed)
+			return
+		}
+		body, err := json.Marshal(r.adminChannels(request.Context()))
+		if err != nil {
+			response.WriteHeader(nethttp.StatusInternalServerError)
+			return
+		}
+		response.Header().Set("Content-Type", "application/json")
+		_, _ = response.Write(body)
+	})
+	mux.HandleFunc(adminPausePath, r.setPausedHandler(true))
+	mux.HandleFunc(adminResumePath, r.setPausedHandler(false))
+
+	return nethttp.HandlerFunc(func(response nethttp.ResponseWriter, request *nethttp.Request) {
+		if !authorized(request, token) {
+			response.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(response, request)
+	})
+}
+
+func (r *Reconciler) adminChannels(ctx context.Context) []adminChannel {
+	r.externalHostsLock.Lock()
+	externalHosts := make(map[string]string, len(r.externalHosts))
+	for host, externalHost := range r.externalHosts {
+		externalHosts[host] = externalHost
+	}
+	r.externalHostsLock.Unlock()
+
+	channels := make([]adminChannel, 0)
+	for host, generation := range r.statusHandler.Loaded() {
+		handler, ok := r.multiChannelMessageHandler.GetChannelHandler(host).(*channelHandler)
+		if !ok {
+			continue
+		}
+		var health map[string]subscriberHealth
+		if tracker := r.getHealthTracker(host); tracker != nil {
+			health = tracker.snapshot()
+		}
+
+		channel := adminChannel{
+			Host:         host,
+			Generation:   generation,
+			ExternalHost: externalHosts[host],
+			InFlight:     handler.inFlight(),
+			Subscribers:  make([]adminSubscriber, 0),
+		}
+		for _, sub := range handler.GetSubscriptions(ctx) {
+			key := subscriberKey(sub.Subscriber, sub.Reply)
+			subscriber := adminSubscriber{
+				Key:    key,
+				Paused: handler.dispatcher.isPaused(key),
+			}
+			if sub.Subscriber != nil {
+				subscriber.Subscriber = sub.Subscriber.String()
+			}
+			if sub.Reply != nil {
+				subscriber.Reply = sub.Reply.String()
+			}
+			if sub.DeadLetter != nil {
+				subscriber.DeadLetter = sub.DeadLetter.String()
+			}
+			if h, ok := health[key]; ok {
+				subscriber.Health = &h
+			}
+			channel.Subscribers = append(channel.Subscribers, subscriber)
+		}
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Host < channels[j].Host })
+	return channels
+}
+
+// setPausedHandler pauses or resumes the subscriber selected by the channel and key query parameters.
+// Pausing only applies to this dispatcher replica. Up to maxHeldDeliveries deliveries are held in
+// memory until the subscriber is resumed, and lost when the replica restarts or when the fanout
+// gives up on them. The ones beyond are sent to the dead letter sink of the subscriber, or dropped
+// if it has none.
+func (r *Reconciler) setPausedHandler(paused bool) nethttp.HandlerFunc {
+	return func(response nethttp.ResponseWriter, request *nethttp.Request) {
+		if request.Method != nethttp.MethodPost {
+			response.WriteHeader(nethttp.StatusMethodNotAllowed)
+			return
+		}
+		query := request.URL.Query()
+		handler, ok := r.multiChannelMessageHandler.GetChannelHandler(query.Get("channel")).(*channelHandler)
+		if !ok {
+			nethttp.Error(response, "channel not found", nethttp.StatusNotFound)
+			return
+		}
+		key := query.Get("key")
+		for _, sub := range handler.GetSubscriptions(request.Context()) {
+			if subscriberKey(sub.Subscriber, sub.Reply) == key {
+				handler.dispatcher.setPaused(key, paused)
+				response.WriteHeader(nethttp.StatusNoContent)
+				return
+			}
+		}
+		nethttp.Error(response, "subscriber not found", nethttp.StatusNotFound)
+	}
+}
@@ -0,0 +1,170 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aliok/websocket-channel/pkg/channel/status"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/eventing/pkg/channel/fanout"
+	"knative.dev/eventing/pkg/channel/multichannelfanout"
+)
+
+const (
+	testAdminToken  = "secret"
+	testChannelHost = "channel-kn-channel.ns.svc.cluster.local"
+	testSubscriber  = "http://subscriber.ns.svc.cluster.local/"
+)
+
+// newAdminTestReconciler returns a Reconciler with a single loaded channel, which has a single subscriber.
+func newAdminTestReconciler(t *testing.T) (*Reconciler, *channelHandler) {
+	t.Helper()
+	dispatcher := newTestDispatcher(&fakeDispatcher{}, newHealthTracker(nil))
+	fanoutHandler, err := fanout.NewFanoutMessageHandler(zap.NewNop(), dispatcher, fanout.Config{
+		Subscriptions: []fanout.Subscription{{Subscriber: mustParseURL(t, testSubscriber)}},
+	}, nil)
+	if err != nil {
+		t.Fatal("NewFanoutMessageHandler() =", err)
+	}
+	handler := newChannelHandler(zap.NewNop(), fanoutHandler, dispatcher, types.NamespacedName{Namespace: "ns", Name: "channel"})
+
+	r := &Reconciler{
+		multiChannelMessageHandler: multichannelfanout.NewMessageHandler(context.Background(), zap.NewNop(), dispatcher, nil),
+		statusHandler:              status.NewHandler(),
+		healthTrackers:             make(map[string]*healthTracker),
+		externalHosts:              make(map[string]string),
+	}
+	r.multiChannelMessageHandler.SetChannelHandler(testChannelHost, handler)
+	r.statusHandler.SetLoaded(testChannelHost, 1)
+	return r, handler
+}
+
+func serveAdmin(handler nethttp.Handler, method, path, authorization string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, nil)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+func TestAdminHandlerAuthorization(t *testing.T) {
+	r, _ := newAdminTestReconciler(t)
+
+	tests := map[string]struct {
+		token         string
+		authorization string
+		want          int
+	}{
+		"no token configured": {
+			authorization: "Bearer ",
+			want:          nethttp.StatusUnauthorized,
+		},
+		"missing authorization": {
+			token: testAdminToken,
+			want:  nethttp.StatusUnauthorized,
+		},
+		"wrong token": {
+			token:         testAdminToken,
+			authorization: "Bearer other",
+			want:          nethttp.StatusUnauthorized,
+		},
+		"not a bearer token": {
+			token:         testAdminToken,
+			authorization: "Basic " + testAdminToken,
+			want:          nethttp.StatusUnauthorized,
+		},
+		"authorized": {
+			token:         testAdminToken,
+			authorization: "Bearer " + testAdminToken,
+			want:          nethttp.StatusOK,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			response := serveAdmin(r.adminHandler(test.token), nethttp.MethodGet, adminChannelsPath, test.authorization)
+			if response.Code != test.want {
+				t.Errorf("Status = %d, want %d", response.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestAdminHandlerPauseResume(t *testing.T) {
+	r, handler := newAdminTestReconciler(t)
+	admin := r.adminHandler(testAdminToken)
+	authorization := "Bearer " + testAdminToken
+	query := "?" + url.Values{"channel": {testChannelHost}, "key": {testSubscriber}}.Encode()
+
+	tests := map[string]struct {
+		method string
+		path   string
+		want   int
+	}{
+		"pause with GET": {
+			method: nethttp.MethodGet,
+			path:   adminPausePath + query,
+			want:   nethttp.StatusMethodNotAllowed,
+		},
+		"unknown channel": {
+			method: nethttp.MethodPost,
+			path:   adminPausePath + "?" + url.Values{"channel": {"other"}, "key": {testSubscriber}}.Encode(),
+			want:   nethttp.StatusNotFound,
+		},
+		"unknown subscriber": {
+			method: nethttp.MethodPost,
+			path:   adminPausePath + "?" + url.Values{"channel": {testChannelHost}, "key": {"http://other/"}}.Encode(),
+			want:   nethttp.StatusNotFound,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if response := serveAdmin(admin, test.method, test.path, authorization); response.Code != test.want {
+				t.Errorf("Status = %d, want %d", response.Code, test.want)
+			}
+			if handler.dispatcher.isPaused(testSubscriber) {
+				t.Error("isPaused() = true")
+			}
+		})
+	}
+
+	if response := serveAdmin(admin, nethttp.MethodPost, adminPausePath+query, authorization); response.Code != nethttp.StatusNoContent {
+		t.Fatalf("Pause status = %d, want %d", response.Code, nethttp.StatusNoContent)
+	}
+	if !handler.dispatcher.isPaused(testSubscriber) {
+		t.Error("isPaused() = false after pausing")
+	}
+	if paused := listedPaused(t, admin, authorization); !paused {
+		t.Error("Listed subscriber is not paused after pausing")
+	}
+
+	if response := serveAdmin(admin, nethttp.MethodPost, adminResumePath+query, authorization); response.Code != nethttp.StatusNoContent {
+		t.Fatalf("Resume status = %d, want %d", response.Code, nethttp.StatusNoContent)
+	}
+	if handler.dispatcher.isPaused(testSubscriber) {
+		t.Error("isPaused() = true after resuming")
+	}
+	if paused := listedPaused(t, admin, authorization); paused {
+		t.Error("Listed subscriber is paused after resuming")
+	}
+}
+
+// listedPaused returns whether the admin API lists the test subscriber as paused.
+func listedPaused(t *testing.T, admin nethttp.Handler, authorization string) bool {
+	t.Helper()
+	response := serveAdmin(admin, nethttp.MethodGet, adminChannelsPath, authorization)
+	var channels []adminChannel
+	if err := json.Unmarshal(response.Body.Bytes(), &channels); err != nil {
+		t.Fatal("Unmarshal() =", err)
+	}
+	if len(channels) != 1 || channels[0].Host != testChannelHost || len(channels[0].Subscribers) != 1 {
+		t.Fatalf("Listed channels = %+v, want %s with one subscriber", channels, testChannelHost)
+	}
+	return channels[0].Subscribers[0].Paused
+}
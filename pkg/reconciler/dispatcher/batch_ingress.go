@@ -0,0 +1,139 @@
+package dispatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	nethttp "net/http"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"go.uber.org/zap"
+)
+
+// structuredContentType is the media type of the structured mode of the CloudEvents HTTP binding.
+const structuredContentType = "application/cloudevents+json"
+
+// batchPublishResponse is the body of the response to a batch of events, with the outcome of every
+// event in the order of the batch.
+type batchPublishResponse struct {
+	Events []batchPublishResult `json:"events"`
+}
+
+// batchPublishResult is the outcome of a single event of a batch: the status code it would have
+// been answered with if it had been published on its own.
+type batchPublishResult struct {
+	ID     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// isBatch returns whether the request publishes a batch of events.
+func isBatch(request *nethttp.Request) bool {
+	return request.Method == nethttp.MethodPost && strings.HasPrefix(request.Header.Get("Content-Type"), batchContentType)
+}
+
+// serveBatch publishes every event of a batch on its own, in the order of the batch, as if it had
+// been sent in structured mode. The response is 202 if every event was accepted, or 207 Multi-Status
+// otherwise, with the outcome of every event in a batchPublishResponse.
+func (h *channelHandler) serveBatch(response nethttp.ResponseWriter, request *nethttp.Request) {
+	// The body is bounded by the maximum message size of the dispatcher. Other read errors are
+	// the publisher failing to send the whole batch.
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		h.logger.Info("Failed to read a batch", zap.Error(err))
+		var tooLarge *bodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			response.WriteHeader(nethttp.StatusRequestEntityTooLarge)
+		} else {
+			response.WriteHeader(nethttp.StatusBadRequest)
+		}
+		return
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		h.logger.Info("Rejecting an invalid batch", zap.Error(err))
+		response.WriteHeader(nethttp.StatusBadRequest)
+		return
+	}
+
+	results := make([]batchPublishResult, 0, len(batch))
+	status := nethttp.StatusAccepted
+	for _, data := range batch {
+		result := h.publishBatched(request, data)
+		if result.Status < 200 || result.Status >= 300 {
+			status = nethttp.StatusMultiStatus
+		}
+		results = append(results, result)
+	}
+
+	data, err := json.Marshal(batchPublishResponse{Events: results})
+	if err != nil {
+		h.logger.Error("Failed to encode the outcome of a batch", zap.Error(err))
+		response.WriteHeader(nethttp.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	_, _ = response.Write(data)
+}
+
+// publishBatched validates a single event of a batch and passes it on to the handler of the channel.
+func (h *channelHandler) publishBatched(request *nethttp.Request, data json.RawMessage) batchPublishResult {
+	event := cloudevents.NewEvent()
+	err := json.Unmarshal(data, &event)
+	if err == nil {
+		err = event.Validate()
+	}
+	if err != nil {
+		return batchPublishResult{ID: event.ID(), Status: nethttp.StatusBadRequest, Error: strings.TrimSpace(err.Error())}
+	}
+
+	// The event is published with the headers of the batch, so it is routed and traced like it.
+	eventRequest := request.Clone(request.Context())
+	eventRequest.Header.Set("Content-Type", structuredContentType)
+	eventRequest.Header.Del("Content-Length")
+	eventRequest.Body = ioutil.NopCloser(bytes.NewReader(data))
+	eventRequest.ContentLength = int64(len(data))
+
+	recorder := &statusRecorder{header: make(nethttp.Header)}
+	h.MessageHandler.ServeHTTP(recorder, eventRequest)
+	result := batchPublishResult{ID: event.ID(), Status: recorder.statusCode()}
+	if result.Status < 200 || result.Status >= 300 {
+		result.Error = fmt.Sprintf("the event was not accepted: %s", nethttp.StatusText(result.Status))
+	}
+	return result
+}
+
+// statusRecorder is the nethttp.ResponseWriter a single event of a batch is published with. It
+// only keeps the status code.
+type statusRecorder struct {
+	header nethttp.Header
+	status int
+}
+
+func (r *statusRecorder) Header() nethttp.Header {
+	return r.header
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = nethttp.StatusOK
+	}
+	return len(b), nil
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+}
+
+func (r *statusRecorder) statusCode() int {
+	if r.status == 0 {
+		return nethttp.StatusOK
+	}
+	return r.status
+}
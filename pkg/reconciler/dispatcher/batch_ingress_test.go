@@ -0,0 +1,203 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/channel/fanout"
+)
+
+// publishRecorder is the handler of a channel recording the IDs of the events published to it. It
+// rejects the events with the ID "rejected".
+type publishRecorder struct {
+	mu        sync.Mutex
+	published []string
+	headers   []string
+}
+
+var _ fanout.MessageHandler = (*publishRecorder)(nil)
+
+func (r *publishRecorder) ServeHTTP(response nethttp.ResponseWriter, request *nethttp.Request) {
+	var event struct {
+		ID string `json:"id"`
+	}
+	body, _ := ioutil.ReadAll(request.Body)
+	if request.Header.Get("Content-Type") != structuredContentType || json.Unmarshal(body, &event) != nil {
+		response.WriteHeader(nethttp.StatusBadRequest)
+		return
+	}
+	if event.ID == "rejected" {
+		response.WriteHeader(nethttp.StatusServiceUnavailable)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.published = append(r.published, event.ID)
+	r.headers = append(r.headers, request.Header.Get("X-Request-Id"))
+	response.WriteHeader(nethttp.StatusAccepted)
+}
+
+func (r *publishRecorder) SetSubscriptions(context.Context, []fanout.Subscription) {}
+
+func (r *publishRecorder) GetSubscriptions(context.Context) []fanout.Subscription {
+	return nil
+}
+
+func newTestBatchChannelHandler(recorder *publishRecorder) *channelHandler {
+	dispatcher := newTestDispatcher(channel.NewMessageDispatcher(zap.NewNop()), newHealthTracker(nil))
+	return newChannelHandler(zap.NewNop(), recorder, dispatcher, types.NamespacedName{Namespace: "ns", Name: "channel"})
+}
+
+func testBatchEvent(id string) string {
+	return `{"specversion": "1.0", "id": "` + id + `", "type": "test.type", "source": "test-source", "data": {"hello": "world"}}`
+}
+
+func TestServeBatch(t *testing.T) {
+	invalid := `{"specversion": "1.0", "id": "invalid", "source": "test-source"}`
+
+	tests := map[string]struct {
+		body          string
+		wantStatus    int
+		wantResults   []batchPublishResult
+		wantPublished []string
+	}{
+		"accepted": {
+			body:       "[" + testBatchEvent("1") + "," + testBatchEvent("2") + "]",
+			wantStatus: nethttp.StatusAccepted,
+			wantResults: []batchPublishResult{
+				{ID: "1", Status: nethttp.StatusAccepted},
+				{ID: "2", Status: nethttp.StatusAccepted},
+			},
+			wantPublished: []string{"1", "2"},
+		},
+		"empty": {
+			body:        "[]",
+			wantStatus:  nethttp.StatusAccepted,
+			wantResults: []batchPublishResult{},
+		},
+		"rejected by the channel": {
+			body:       "[" + testBatchEvent("1") + "," + testBatchEvent("rejected") + "," + testBatchEvent("3") + "]",
+			wantStatus: nethttp.StatusMultiStatus,
+			wantResults: []batchPublishResult{
+				{ID: "1", Status: nethttp.StatusAccepted},
+				{ID: "rejected", Status: nethttp.StatusServiceUnavailable, Error: "the event was not accepted: Service Unavailable"},
+				{ID: "3", Status: nethttp.StatusAccepted},
+			},
+			wantPublished: []string{"1", "3"},
+		},
+		"invalid events": {
+			body:       "[" + testBatchEvent("1") + "," + invalid + `, "not an event"]`,
+			wantStatus: nethttp.StatusMultiStatus,
+			wantResults: []batchPublishResult{
+				{ID: "1", Status: nethttp.StatusAccepted},
+				{ID: "invalid", Status: nethttp.StatusBadRequest},
+				{Status: nethttp.StatusBadRequest},
+			},
+			wantPublished: []string{"1"},
+		},
+		"not a batch": {
+			body:       testBatchEvent("1"),
+			wantStatus: nethttp.StatusBadRequest,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := &publishRecorder{}
+			request := httptest.NewRequest(nethttp.MethodPost, "/", strings.NewReader(test.body))
+			request.Header.Set("Content-Type", batchContentType)
+			request.Header.Set("X-Request-Id", "request")
+			response := httptest.NewRecorder()
+
+			newTestBatchChannelHandler(recorder).ServeHTTP(response, request)
+
+			if response.Code != test.wantStatus {
+				t.Fatalf("Status = %d, want %d", response.Code, test.wantStatus)
+			}
+			if test.wantResults != nil {
+				var got batchPublishResponse
+				if err := json.Unmarshal(response.Body.Bytes(), &got); err != nil {
+					t.Fatal("Unmarshal() =", err)
+				}
+				// The errors of invalid events come from the CloudEvents SDK, only their presence is checked.
+				for i, result := range got.Events {
+					if result.Status == nethttp.StatusBadRequest {
+						if result.Error == "" {
+							t.Errorf("Result %d has no error", i)
+						}
+						got.Events[i].Error = ""
+					}
+				}
+				if diff := cmp.Diff(test.wantResults, got.Events); diff != "" {
+					t.Error("Results (-want, +got) =", diff)
+				}
+			}
+			if diff := cmp.Diff(test.wantPublished, recorder.published); diff != "" {
+				t.Error("Published (-want, +got) =", diff)
+			}
+			// Every event is published with the headers of the batch.
+			for _, header := range recorder.headers {
+				if header != "request" {
+					t.Errorf("X-Request-Id = %q, want the header of the batch", header)
+				}
+			}
+		})
+	}
+}
+
+func TestServeBatchReadErrors(t *testing.T) {
+	batch := "[" + testBatchEvent("1") + "," + testBatchEvent("2") + "]"
+
+	tests := map[string]struct {
+		body       func(response nethttp.ResponseWriter) io.ReadCloser
+		wantStatus int
+	}{
+		"over the maximum message size": {
+			body: func(response nethttp.ResponseWriter) io.ReadCloser {
+				return &limitedBody{ReadCloser: nethttp.MaxBytesReader(response, ioutil.NopCloser(strings.NewReader(batch)), 10), limit: 10}
+			},
+			wantStatus: nethttp.StatusRequestEntityTooLarge,
+		},
+		"within the maximum message size": {
+			body: func(response nethttp.ResponseWriter) io.ReadCloser {
+				limit := int64(len(batch))
+				return &limitedBody{ReadCloser: nethttp.MaxBytesReader(response, ioutil.NopCloser(strings.NewReader(batch)), limit), limit: limit}
+			},
+			wantStatus: nethttp.StatusAccepted,
+		},
+		"truncated": {
+			body: func(nethttp.ResponseWriter) io.ReadCloser {
+				return ioutil.NopCloser(iotest.TimeoutReader(strings.NewReader(batch)))
+			},
+			wantStatus: nethttp.StatusBadRequest,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest(nethttp.MethodPost, "/", nil)
+			request.Header.Set("Content-Type", batchContentType)
+			// The body is chunked, so it is only found to be too large reading it.
+			request.ContentLength = -1
+			response := httptest.NewRecorder()
+			request.Body = test.body(response)
+
+			newTestBatchChannelHandler(&publishRecorder{}).ServeHTTP(response, request)
+
+			if response.Code != test.wantStatus {
+				t.Errorf("Status = %d, want %d", response.Code, test.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliok/websocket-channel/pkg/apis"
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	listers "github.com/aliok/websocket-channel/pkg/client/listers/channels/v1beta1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/channel/attributes"
+	"knative.dev/eventing/pkg/kncloudevents"
+	"knative.dev/pkg/logging"
+)
+
+// batchContentType is the media type of the batched mode of the CloudEvents HTTP binding.
+const batchContentType = "application/cloudevents-batch+json"
+
+const (
+	// defaultBatchMaxEvents is the number of events after which a batch is sent, unless the
+	// Subscription sets apis.BatchMaxEventsAnnotation.
+	defaultBatchMaxEvents = 100
+
+	// defaultBatchMaxDelay is the time after which a batch is sent, unless the Subscription sets
+	// apis.BatchMaxDelayAnnotation.
+	defaultBatchMaxDelay = 100 * time.Millisecond
+)
+
+// batchConfig is the batching of the deliveries to a subscriber, see apis.BatchMaxEventsAnnotation.
+type batchConfig struct {
+	maxEvents int
+	maxDelay  time.Duration
+}
+
+// newBatchConfigs returns the batching of the subscribers of a channel, keyed by subscriber key.
+// Subscribers opt in with the batching annotations of their Subscription. Durable and ordered
+// channels deliver one event at a time, so they are never batched.
+func newBatchConfigs(ctx context.Context, wsc *v1beta1.WebSocketChannel, subscriptions cache.GenericLister) map[string]batchConfig {
+	if subscriptions == nil || wsc.Spec.Durable || wsc.Spec.Ordering == v1beta1.OrderingPartitionKey {
+		return nil
+	}
+	keys := make(map[types.UID]string, len(wsc.Spec.Subscribers))
+	for _, sub := range wsc.Spec.Subscribers {
+		keys[sub.UID] = specSubscriberKey(sub)
+	}
+	objs, err := subscriptions.ByNamespace(wsc.Namespace).List(labels.Everything())
+	if err != nil {
+		logging.FromContext(ctx).Errorw("Failed to list the subscriptions, delivering without batching", zap.Error(err))
+		return nil
+	}
+
+	configs := make(map[string]batchConfig)
+	for _, obj := range objs {
+		subscription, ok := obj.(metav1.Object)
+		if !ok {
+			continue
+		}
+		key, ok := keys[subscription.GetUID()]
+		if !ok {
+			continue
+		}
+		config, err := batchConfigFromAnnotations(subscription.GetAnnotations())
+		if err != nil {
+			logging.FromContext(ctx).Warnw("Ignoring the batching of a subscription", zap.String("subscription", subscription.GetName()), zap.Error(err))
+			continue
+		}
+		if config != nil {
+			configs[key] = *config
+		}
+	}
+	return configs
+}
+
+// enqueueSubscribedChannels returns an event handler enqueueing the channels a Subscription
+// subscribes to, found by its UID in their spec.subscribers.
+func enqueueSubscribedChannels(lister listers.WebSocketChannelLister, enqueue func(types.NamespacedName)) func(interface{}) {
+	return func(obj interface{}) {
+		subscription, ok := obj.(metav1.Object)
+		if !ok {
+			return
+		}
+		channels, err := lister.WebSocketChannels(subscription.GetNamespace()).List(labels.Everything())
+		if err != nil {
+			return
+		}
+		for _, wsc := range channels {
+			for _, sub := range wsc.Spec.Subscribers {
+				if sub.UID == subscription.GetUID() {
+					enqueue(types.NamespacedName{Namespace: wsc.Namespace, Name: wsc.Name})
+					break
+				}
+			}
+		}
+	}
+}
+
+// batchConfigFromAnnotations returns the batching set by the annotations of a Subscription, nil if
+// it doesn't opt in to batching.
+func batchConfigFromAnnotations(annotations map[string]string) (*batchConfig, error) {
+	maxEvents, hasMaxEvents := annotations[apis.BatchMaxEventsAnnotation]
+	maxDelay, hasMaxDelay := annotations[apis.BatchMaxDelayAnnotation]
+	if !hasMaxEvents && !hasMaxDelay {
+		return nil, nil
+	}
+
+	config := &batchConfig{maxEvents: defaultBatchMaxEvents, maxDelay: defaultBatchMaxDelay}
+	if hasMaxEvents {
+		n, err := strconv.Atoi(maxEvents)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s %q, must be a positive integer", apis.BatchMaxEventsAnnotation, maxEvents)
+		}
+		config.maxEvents = n
+	}
+	if hasMaxDelay {
+		d, err := time.ParseDuration(maxDelay)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid %s %q, must be a non-negative duration", apis.BatchMaxDelayAnnotation, maxDelay)
+		}
+		config.maxDelay = d
+	}
+	return config, nil
+}
+
+// batchTarget is the subscription the events of a batch are delivered for, and the headers passed
+// through from the request of an event.
+type batchTarget struct {
+	destination       *url.URL
+	reply             *url.URL
+	deadLetter        *url.URL
+	config            *kncloudevents.RetryConfig
+	additionalHeaders nethttp.Header
+}
+
+// batchedEvent is an event waiting to be delivered in a batch. The outcome of its delivery is sent
+// on result.
+type batchedEvent struct {
+	ctx    context.Context
+	event  *cloudevents.Event
+	target batchTarget
+	result chan batchResult
+
+	// deadline is the time the event expires at, if it expires.
+	deadline          time.Time
+	expires           bool
+	deadLetterExpired bool
+}
+
+type batchResult struct {
+	info *channel.DispatchExecutionInfo
+	err  error
+}
+
+// batchFailures is the body of the 207 Multi-Status response of a subscriber that failed some of
+// the events of a batch, with their indexes in the batch.
+type batchFailures struct {
+	Failed []int `json:"failed"`
+}
+
+// batchAttempt is the outcome of sending a batch once.
+type batchAttempt struct {
+	info   *channel.DispatchExecutionInfo
+	err    error
+	failed []*batchedEvent
+	retry  bool
+
+	// header and body of a successful response, the reply of the subscriber.
+	header nethttp.Header
+	body   []byte
+}
+
+// batcher accumulates the events for a single subscriber, and delivers them in batches.
+type batcher struct {
+	dispatcher *healthTrackingDispatcher
+	key        string
+	sender     *kncloudevents.HTTPMessageSender
+
+	mu      sync.Mutex
+	config  batchConfig
+	pending []*batchedEvent
+	// batch counts the batches started, so a timer never sends a later batch than its own.
+	batch int
+}
+
+func newBatcher(dispatcher *healthTrackingDispatcher, key string, config batchConfig) *batcher {
+	// The sender shares the HTTP client of the message dispatcher, it never fails to be created.
+	sender, _ := kncloudevents.NewHTTPMessageSenderWithTarget("")
+	return &batcher{
+		dispatcher: dispatcher,
+		key:        key,
+		sender:     sender,
+		config:     config,
+	}
+}
+
+func (b *batcher) setConfig(config batchConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.config = config
+}
+
+// add adds the event to the pending batch, and waits for the batch to be delivered. A batch is sent
+// by the call adding its last event, or once maxDelay passed since its first one.
+func (b *batcher) add(e *batchedEvent) batchResult {
+	b.mu.Lock()
+	b.pending = append(b.pending, e)
+	switch {
+	case len(b.pending) >= b.config.maxEvents:
+		events := b.take()
+		b.mu.Unlock()
+		b.deliver(events)
+	case len(b.pending) == 1:
+		batch := b.batch
+		time.AfterFunc(b.config.maxDelay, func() {
+			b.flush(batch)
+		})
+		b.mu.Unlock()
+	default:
+		b.mu.Unlock()
+	}
+	return <-e.result
+}
+
+// take takes the pending events, starting a new batch. It is called with mu held.
+func (b *batcher) take() []*batchedEvent {
+	events := b.pending
+	b.pending = nil
+	b.batch++
+	return events
+}
+
+// flush delivers the pending events if they are still the given batch.
+func (b *batcher) flush(batch int) {
+	b.mu.Lock()
+	if b.batch != batch || len(b.pending) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	events := b.take()
+	b.mu.Unlock()
+	b.deliver(events)
+}
+
+// flushPending delivers the pending events right away.
+func (b *batcher) flushPending() {
+	b.mu.Lock()
+	batch := b.batch
+	b.mu.Unlock()
+	b.flush(batch)
+}
+
+// deliver sends the events as a batch, retrying the failed ones as a smaller batch according to
+// the retry config of the subscription. The events still failing are sent to the dead letter sink
+// one by one. Every event gets its outcome.
+func (b *batcher) deliver(events []*batchedEvent) {
+	ctx, span, target := newBatchContext(events)
+	defer span.End()
+
+	remaining := events
+	var attempt batchAttempt
+	for n := 0; ; n++ {
+		remaining = b.expire(remaining)
+		if len(remaining) == 0 {
+			return
+		}
+
+		attempt = b.send(ctx, target, remaining)
+		failed := make(map[*batchedEvent]bool, len(attempt.failed))
+		for _, e := range attempt.failed {
+			failed[e] = true
+		}
+		var replyErr error
+		if len(attempt.failed) == 0 {
+			replyErr = b.forwardReplies(ctx, target, attempt)
+		}
+		for _, e := range remaining {
+			if !failed[e] {
+				e.result <- batchResult{info: attempt.info, err: replyErr}
+			}
+		}
+		if len(attempt.failed) == 0 {
+			b.dispatcher.tracker.record(b.key, nil)
+			return
+		}
+
+		remaining = attempt.failed
+		if !attempt.retry || target.config == nil || n >= target.config.RetryMax {
+			break
+		}
+		if target.config.Backoff != nil {
+			time.Sleep(target.config.Backoff(n, nil))
+		}
+	}
+
+	b.dispatcher.tracker.record(b.key, attempt.err)
+	for _, e := range remaining {
+		e.result <- b.deadLetter(e, attempt)
+	}
+}
+
+// newBatchContext returns the context and the target a batch of events is delivered with. The
+// batch is traced in a span of its own linked to the spans of its events, so cancelling the
+// delivery of one event doesn't fail the others. It is delivered for the subscription of its last
+// event, the most recent one, with the headers of its request.
+func newBatchContext(events []*batchedEvent) (context.Context, *trace.Span, batchTarget) {
+	ctx, span := trace.StartSpan(context.Background(), "websocketchannel.batch", trace.WithSpanKind(trace.SpanKindClient))
+	if span.IsRecordingEvents() {
+		span.AddAttributes(trace.Int64Attribute("websocketchannel.batch.size", int64(len(events))))
+		for _, e := range events {
+			if parent := trace.FromContext(e.ctx); parent != nil {
+				sc := parent.SpanContext()
+				span.AddLink(trace.Link{TraceID: sc.TraceID, SpanID: sc.SpanID, Type: trace.LinkTypeParent})
+			}
+		}
+	}
+	return ctx, span, events[len(events)-1].target
+}
+
+// expire takes the expired events out of the batch, and returns the others.
+func (b *batcher) expire(events []*batchedEvent) []*batchedEvent {
+	now := time.Now()
+	var remaining []*batchedEvent
+	for _, e := range events {
+		if !e.expires || now.Before(e.deadline) {
+			remaining = append(remaining, e)
+			continue
+		}
+		info, err := b.dispatcher.expire(e.ctx, binding.ToMessage(e.event), e.target.additionalHeaders, b.key, e.event.Type(), e.target.deadLetter, e.target.config, e.deadLetterExpired)
+		e.result <- batchResult{info: info, err: err}
+	}
+	return remaining
+}
+
+// send sends the events to the subscriber as a single batch.
+func (b *batcher) send(ctx context.Context, target batchTarget, events []*batchedEvent) batchAttempt {
+	attempt := batchAttempt{
+		info:   &channel.DispatchExecutionInfo{Time: channel.NoDuration, ResponseCode: channel.NoResponse},
+		failed: events,
+	}
+
+	batch := make([]*cloudevents.Event, 0, len(events))
+	for _, e := range events {
+		batch = append(batch, e.event)
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		attempt.err = fmt.Errorf("failed to encode the batch: %w", err)
+		return attempt
+	}
+	req, err := b.sender.NewCloudEventRequestWithTarget(ctx, target.destination.String())
+	if err != nil {
+		attempt.err = err
+		return attempt
+	}
+	for name, values := range target.additionalHeaders {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", batchContentType)
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	start := time.Now()
+	resp, err := b.sender.Send(req)
+	attempt.info.Time = time.Since(start)
+	if err != nil {
+		attempt.err = err
+		attempt.retry = checkRetry(ctx, target.config, nil, err)
+		attempt.info.ResponseBody = []byte(fmt.Sprintf("dispatch error: %s", err.Error()))
+		return attempt
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		attempt.err = fmt.Errorf("failed to read the response: %w", err)
+		attempt.retry = checkRetry(ctx, target.config, nil, err)
+		return attempt
+	}
+	attempt.info.ResponseCode = resp.StatusCode
+	attempt.info.ResponseBody = respBody
+
+	switch {
+	case resp.StatusCode == nethttp.StatusMultiStatus:
+		var failures batchFailures
+		if err := json.Unmarshal(respBody, &failures); err != nil {
+			// Without knowing which events failed, all of them did.
+			attempt.err = fmt.Errorf("failed to decode the 207 response to the batch: %w", err)
+			attempt.retry = checkRetry(ctx, target.config, nil, attempt.err)
+			return attempt
+		}
+		attempt.failed = nil
+		seen := make(map[int]bool, len(failures.Failed))
+		for _, i := range failures.Failed {
+			if i >= 0 && i < len(events) && !seen[i] {
+				seen[i] = true
+				attempt.failed = append(attempt.failed, events[i])
+			}
+		}
+		if len(attempt.failed) > 0 {
+			// The failed events are retried as if their delivery had failed without a response.
+			attempt.err = fmt.Errorf("%d of the %d events of the batch failed", len(attempt.failed), len(events))
+			attempt.retry = checkRetry(ctx, target.config, nil, attempt.err)
+		}
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		attempt.failed = nil
+		attempt.header = resp.Header
+		attempt.body = respBody
+	default:
+		attempt.err = fmt.Errorf("unexpected HTTP response, expected 2xx, got %d", resp.StatusCode)
+		attempt.retry = checkRetry(ctx, target.config, resp, nil)
+	}
+	return attempt
+}
+
+// checkRetry returns whether a failed batch is retried according to the retry config.
+func checkRetry(ctx context.Context, config *kncloudevents.RetryConfig, resp *nethttp.Response, err error) bool {
+	if config == nil {
+		return false
+	}
+	check := config.CheckRetry
+	if check == nil {
+		check = kncloudevents.RetryIfGreaterThan300
+	}
+	retry, _ := check(ctx, resp, err)
+	return retry
+}
+
+// forwardReplies forwards the events the subscriber replied to a batch with to the reply of the
+// subscription. The reply is either a single event or a batch.
+func (b *batcher) forwardReplies(ctx context.Context, target batchTarget, attempt batchAttempt) error {
+	if target.reply == nil || len(attempt.body) == 0 {
+		return nil
+	}
+
+	var replies []cloudevents.Event
+	if strings.HasPrefix(attempt.header.Get("Content-Type"), batchContentType) {
+		if err := json.Unmarshal(attempt.body, &replies); err != nil {
+			return fmt.Errorf("failed to decode the reply batch: %w", err)
+		}
+	} else {
+		message := cehttp.NewMessage(attempt.header, ioutil.NopCloser(bytes.NewReader(attempt.body)))
+		if message.ReadEncoding() == binding.EncodingUnknown {
+			return nil
+		}
+		event, err := binding.ToEvent(ctx, message)
+		if err != nil {
+			return fmt.Errorf("failed to decode the reply: %w", err)
+		}
+		replies = append(replies, *event)
+	}
+
+	for i := range replies {
+		if _, err := b.dispatcher.MessageDispatcher.DispatchMessageWithRetries(ctx, binding.ToMessage(&replies[i]), target.additionalHeaders, target.reply, nil, nil, target.config); err != nil {
+			return fmt.Errorf("failed to forward the reply to %s: %w", target.reply, err)
+		}
+	}
+	return nil
+}
+
+// deadLetter sends an event that could not be delivered in a batch to the dead letter sink, with
+// the knative error extensions of the last attempt.
+func (b *batcher) deadLetter(e *batchedEvent, attempt batchAttempt) batchResult {
+	if e.target.deadLetter == nil {
+		return batchResult{info: attempt.info, err: attempt.err}
+	}
+	transformers := attributes.KnativeErrorTransformers(attempt.info.ResponseCode, string(attempt.info.ResponseBody))
+	info, err := b.dispatcher.sendToDeadLetter(e.ctx, binding.ToMessage(e.event), e.target.additionalHeaders, b.key, e.target.deadLetter, e.target.config, transformers...)
+	if err != nil {
+		return batchResult{info: info, err: fmt.Errorf("unable to complete request to either %s (%v) or %s (%v)", e.target.destination, attempt.err, e.target.deadLetter, err)}
+	}
+	return batchResult{info: info}
+}
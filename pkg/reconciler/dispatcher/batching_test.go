@@ -0,0 +1,389 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliok/websocket-channel/pkg/apis"
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	listers "github.com/aliok/websocket-channel/pkg/client/listers/channels/v1beta1"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/kncloudevents"
+	knapis "knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+)
+
+func TestBatchConfigFromAnnotations(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		want        *batchConfig
+		wantErr     bool
+	}{
+		"not batched": {
+			annotations: map[string]string{"other": "annotation"},
+		},
+		"max events": {
+			annotations: map[string]string{apis.BatchMaxEventsAnnotation: "10"},
+			want:        &batchConfig{maxEvents: 10, maxDelay: defaultBatchMaxDelay},
+		},
+		"max delay": {
+			annotations: map[string]string{apis.BatchMaxDelayAnnotation: "1s"},
+			want:        &batchConfig{maxEvents: defaultBatchMaxEvents, maxDelay: time.Second},
+		},
+		"both": {
+			annotations: map[string]string{apis.BatchMaxEventsAnnotation: "5", apis.BatchMaxDelayAnnotation: "0s"},
+			want:        &batchConfig{maxEvents: 5},
+		},
+		"zero max events": {
+			annotations: map[string]string{apis.BatchMaxEventsAnnotation: "0"},
+			wantErr:     true,
+		},
+		"invalid max events": {
+			annotations: map[string]string{apis.BatchMaxEventsAnnotation: "many"},
+			wantErr:     true,
+		},
+		"negative max delay": {
+			annotations: map[string]string{apis.BatchMaxDelayAnnotation: "-1s"},
+			wantErr:     true,
+		},
+		"invalid max delay": {
+			annotations: map[string]string{apis.BatchMaxDelayAnnotation: "100"},
+			wantErr:     true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := batchConfigFromAnnotations(test.annotations)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("batchConfigFromAnnotations() = %v, wantErr %t", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(batchConfig{})); diff != "" {
+				t.Error("batchConfigFromAnnotations (-want, +got) =", diff)
+			}
+		})
+	}
+}
+
+// newTestSubscriptionLister returns a lister of the metadata of the given Subscriptions.
+func newTestSubscriptionLister(t *testing.T, subscriptions ...*duckv1.KResource) cache.GenericLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, subscription := range subscriptions {
+		if err := indexer.Add(subscription); err != nil {
+			t.Fatal("Add() =", err)
+		}
+	}
+	return cache.NewGenericLister(indexer, subscriptionsResource.GroupResource())
+}
+
+func testSubscription(namespace, name string, uid types.UID, annotations map[string]string) *duckv1.KResource {
+	return &duckv1.KResource{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   namespace,
+		Name:        name,
+		UID:         uid,
+		Annotations: annotations,
+	}}
+}
+
+func TestNewBatchConfigs(t *testing.T) {
+	batched := eventingduckv1.SubscriberSpec{UID: "batched", SubscriberURI: knapis.HTTP("batched.ns.svc.cluster.local")}
+	unbatched := eventingduckv1.SubscriberSpec{UID: "unbatched", SubscriberURI: knapis.HTTP("unbatched.ns.svc.cluster.local")}
+	invalid := eventingduckv1.SubscriberSpec{UID: "invalid", SubscriberURI: knapis.HTTP("invalid.ns.svc.cluster.local")}
+	lister := newTestSubscriptionLister(t,
+		testSubscription("ns", "batched", "batched", map[string]string{apis.BatchMaxEventsAnnotation: "10"}),
+		testSubscription("ns", "unbatched", "unbatched", nil),
+		testSubscription("ns", "invalid", "invalid", map[string]string{apis.BatchMaxEventsAnnotation: "-1"}),
+		// Subscriptions of other channels are ignored, even when in the same namespace.
+		testSubscription("ns", "other", "other", map[string]string{apis.BatchMaxEventsAnnotation: "10"}),
+		testSubscription("other-ns", "batched", "batched-elsewhere", map[string]string{apis.BatchMaxEventsAnnotation: "10"}),
+	)
+
+	tests := map[string]struct {
+		spec     v1beta1.WebSocketChannelSpec
+		noLister bool
+		want     map[string]batchConfig
+	}{
+		"batched subscriber": {
+			want: map[string]batchConfig{
+				specSubscriberKey(batched): {maxEvents: 10, maxDelay: defaultBatchMaxDelay},
+			},
+		},
+		"durable": {
+			spec: v1beta1.WebSocketChannelSpec{Durable: true},
+		},
+		"ordered": {
+			spec: v1beta1.WebSocketChannelSpec{Ordering: v1beta1.OrderingPartitionKey},
+		},
+		"no lister": {
+			noLister: true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			wsc := &v1beta1.WebSocketChannel{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "channel"},
+				Spec:       test.spec,
+			}
+			wsc.Spec.Subscribers = []eventingduckv1.SubscriberSpec{batched, unbatched, invalid}
+			subscriptions := lister
+			if test.noLister {
+				subscriptions = nil
+			}
+
+			got := newBatchConfigs(context.Background(), wsc, subscriptions)
+			if len(got) == 0 && len(test.want) == 0 {
+				return
+			}
+			if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(batchConfig{})); diff != "" {
+				t.Error("newBatchConfigs (-want, +got) =", diff)
+			}
+		})
+	}
+}
+
+func TestEnqueueSubscribedChannels(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, wsc := range []*v1beta1.WebSocketChannel{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "subscribed"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "other"}},
+	} {
+		if wsc.Name == "subscribed" {
+			wsc.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{UID: "uid"}}
+		}
+		if err := indexer.Add(wsc); err != nil {
+			t.Fatal("Add() =", err)
+		}
+	}
+
+	var enqueued []types.NamespacedName
+	enqueue := enqueueSubscribedChannels(listers.NewWebSocketChannelLister(indexer), func(key types.NamespacedName) {
+		enqueued = append(enqueued, key)
+	})
+	enqueue(testSubscription("ns", "subscription", "uid", nil))
+	enqueue(testSubscription("ns", "unknown", "unknown", nil))
+
+	if diff := cmp.Diff([]types.NamespacedName{{Namespace: "ns", Name: "subscribed"}}, enqueued); diff != "" {
+		t.Error("Enqueued (-want, +got) =", diff)
+	}
+}
+
+// batchSubscriber is a subscriber receiving events in batches at /, and one by one at /dls as the
+// dead letter sink. Its responses to batches are taken from responses in turn, and it accepts the
+// batches once they run out.
+type batchSubscriber struct {
+	mu          sync.Mutex
+	responses   []batchResponse
+	batches     [][]string
+	headers     []nethttp.Header
+	deadLetters []string
+}
+
+type batchResponse struct {
+	code int
+	body string
+}
+
+func (s *batchSubscriber) ServeHTTP(response nethttp.ResponseWriter, request *nethttp.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if request.URL.Path == "/dls" {
+		s.deadLetters = append(s.deadLetters, request.Header.Get("Ce-Id"))
+		response.WriteHeader(nethttp.StatusAccepted)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var events []struct {
+		ID string `json:"id"`
+	}
+	if request.Header.Get("Content-Type") != batchContentType || json.Unmarshal(body, &events) != nil {
+		response.WriteHeader(nethttp.StatusBadRequest)
+		return
+	}
+	ids := make([]string, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	s.batches = append(s.batches, ids)
+	s.headers = append(s.headers, request.Header)
+
+	if len(s.responses) == 0 {
+		response.WriteHeader(nethttp.StatusAccepted)
+		return
+	}
+	next := s.responses[0]
+	s.responses = s.responses[1:]
+	response.WriteHeader(next.code)
+	_, _ = response.Write([]byte(next.body))
+}
+
+func (s *batchSubscriber) received() ([][]string, []nethttp.Header, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.batches...), append([]nethttp.Header(nil), s.headers...), append([]string(nil), s.deadLetters...)
+}
+
+// dispatchBatched dispatches the events with the given IDs at once, and returns the error of every
+// delivery.
+func dispatchBatched(t *testing.T, d *healthTrackingDispatcher, server *httptest.Server, deadLetter bool, config *kncloudevents.RetryConfig, ids ...string) []error {
+	t.Helper()
+	destination := mustParseURL(t, server.URL+"/")
+	dls := mustParseURL(t, server.URL+"/dls")
+	if !deadLetter {
+		dls = nil
+	}
+	headers := nethttp.Header{"X-Request-Id": []string{"request"}}
+
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			_, errs[i] = d.DispatchMessageWithRetries(context.Background(), testMessage(id), headers, destination, nil, dls, config)
+		}(i, id)
+	}
+	wg.Wait()
+	return errs
+}
+
+func newBatchingTestDispatcher(t *testing.T, server *httptest.Server, config batchConfig) *healthTrackingDispatcher {
+	t.Helper()
+	d := newTestDispatcher(channel.NewMessageDispatcher(zap.NewNop()), newHealthTracker(nil))
+	d.setBatching(map[string]batchConfig{subscriberKey(mustParseURL(t, server.URL+"/"), nil): config})
+	return d
+}
+
+func TestBatcherSendsFullBatches(t *testing.T) {
+	subscriber := &batchSubscriber{}
+	server := httptest.NewServer(subscriber)
+	defer server.Close()
+	// The delay is long enough for the batch to be sent because it is full.
+	d := newBatchingTestDispatcher(t, server, batchConfig{maxEvents: 3, maxDelay: time.Hour})
+
+	for _, err := range dispatchBatched(t, d, server, false, nil, "1", "2", "3") {
+		if err != nil {
+			t.Error("DispatchMessageWithRetries() =", err)
+		}
+	}
+	batches, headers, _ := subscriber.received()
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("Batches = %v, want a single batch of 3 events", batches)
+	}
+	if got := headers[0].Get("X-Request-Id"); got != "request" {
+		t.Errorf("X-Request-Id = %q, want the additional header of the events", got)
+	}
+}
+
+func TestBatcherSendsBatchesAfterMaxDelay(t *testing.T) {
+	subscriber := &batchSubscriber{}
+	server := httptest.NewServer(subscriber)
+	defer server.Close()
+	d := newBatchingTestDispatcher(t, server, batchConfig{maxEvents: 100, maxDelay: 10 * time.Millisecond})
+
+	for _, err := range dispatchBatched(t, d, server, false, nil, "1", "2") {
+		if err != nil {
+			t.Error("DispatchMessageWithRetries() =", err)
+		}
+	}
+	batches, _, _ := subscriber.received()
+	var events int
+	for _, batch := range batches {
+		events += len(batch)
+	}
+	if events != 2 {
+		t.Errorf("Batches = %v, want the 2 events", batches)
+	}
+}
+
+func TestBatcherRetriesFailedEvents(t *testing.T) {
+	retryConfig := &kncloudevents.RetryConfig{
+		RetryMax:   2,
+		CheckRetry: kncloudevents.RetryIfGreaterThan300,
+		Backoff: func(int, *nethttp.Response) time.Duration {
+			return time.Millisecond
+		},
+	}
+
+	tests := map[string]struct {
+		responses       []batchResponse
+		config          *kncloudevents.RetryConfig
+		deadLetter      bool
+		wantBatches     int
+		wantDeadLetters int
+		wantErrs        int
+	}{
+		"partial failure is retried": {
+			responses:   []batchResponse{{code: nethttp.StatusMultiStatus, body: `{"failed": [1]}`}},
+			config:      retryConfig,
+			wantBatches: 2,
+		},
+		"failed events are dead lettered": {
+			responses: []batchResponse{
+				{code: nethttp.StatusMultiStatus, body: `{"failed": [0, 2]}`},
+				{code: nethttp.StatusInternalServerError},
+				{code: nethttp.StatusInternalServerError},
+			},
+			config:          retryConfig,
+			deadLetter:      true,
+			wantBatches:     3,
+			wantDeadLetters: 2,
+		},
+		"unparseable 207 fails the whole batch": {
+			responses:       []batchResponse{{code: nethttp.StatusMultiStatus, body: "not json"}},
+			deadLetter:      true,
+			wantBatches:     1,
+			wantDeadLetters: 3,
+		},
+		"failed events without a dead letter sink": {
+			responses:   []batchResponse{{code: nethttp.StatusMultiStatus, body: `{"failed": [2]}`}},
+			wantBatches: 1,
+			wantErrs:    1,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			subscriber := &batchSubscriber{responses: test.responses}
+			server := httptest.NewServer(subscriber)
+			defer server.Close()
+			d := newBatchingTestDispatcher(t, server, batchConfig{maxEvents: 3, maxDelay: time.Hour})
+
+			var failed int
+			for _, err := range dispatchBatched(t, d, server, test.deadLetter, test.config, "1", "2", "3") {
+				if err != nil {
+					failed++
+				}
+			}
+			if failed != test.wantErrs {
+				t.Errorf("%d deliveries failed, want %d", failed, test.wantErrs)
+			}
+
+			batches, _, deadLetters := subscriber.received()
+			if len(batches) != test.wantBatches {
+				t.Errorf("Batches = %v, want %d of them", batches, test.wantBatches)
+			}
+			// Only the failed events are retried.
+			for i := 1; i < len(batches); i++ {
+				if len(batches[i]) >= len(batches[0]) {
+					t.Errorf("Batch %d = %v, want only the failed events of %v", i, batches[i], batches[0])
+				}
+			}
+			if len(deadLetters) != test.wantDeadLetters {
+				t.Errorf("Dead lettered %v, want %d events", deadLetters, test.wantDeadLetters)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package dispatcher
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/eventing/pkg/channel/fanout"
+	"knative.dev/eventing/pkg/tracing"
+)
+
+const (
+	// drainTimeout bounds the time a deleted channel waits for the deliveries in flight, including their retries.
+	drainTimeout = 30 * time.Second
+
+	// drainRequeueInterval is how often a deleted channel checks the deliveries in flight again.
+	drainRequeueInterval = time.Second
+)
+
+// channelHandler is the fanout.MessageHandler of a single channel. It keeps track of the events
+// in flight, so the channel can be drained before it is deleted.
+type channelHandler struct {
+	fanout.MessageHandler
+	logger     *zap.Logger
+	dispatcher *healthTrackingDispatcher
+	channel    types.NamespacedName
+
+	drainOnce    sync.Once
+	drainStarted time.Time
+	draining     int32
+	receiving    int64
+}
+
+var _ fanout.MessageHandler = (*channelHandler)(nil)
+
+func newChannelHandler(logger *zap.Logger, handler fanout.MessageHandler, dispatcher *healthTrackingDispatcher, channel types.NamespacedName) *channelHandler {
+	return &channelHandler{
+		MessageHandler: handler,
+		logger:         logger,
+		dispatcher:     dispatcher,
+		channel:        channel,
+	}
+}
+
+func (h *channelHandler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	atomic.AddInt64(&h.receiving, 1)
+	defer atomic.AddInt64(&h.receiving, -1)
+
+	if atomic.LoadInt32(&h.draining) == 1 {
+		// The channel is being deleted.
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The span of the channel is a child of the one started from the trace context of the
+	// request, the spans of the deliveries to the subscribers are its children.
+	ctx, span := trace.StartSpan(request.Context(), channelMessagingDestination(h.channel), trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	if span.IsRecordingEvents() {
+		span.AddAttributes(
+			tracing.MessagingSystemAttribute,
+			tracing.MessagingProtocolHTTP,
+			trace.StringAttribute(tracing.MessagingDestinationAttributeName, channelMessagingDestination(h.channel)),
+		)
+	}
+	if isBatch(request) {
+		h.serveBatch(response, request.WithContext(ctx))
+		return
+	}
+	h.MessageHandler.ServeHTTP(response, request.WithContext(ctx))
+}
+
+// channelMessagingDestination names the channel in spans, like eventing does for brokers and triggers.
+func channelMessagingDestination(channel types.NamespacedName) string {
+	return fmt.Sprintf("websocketchannel:%s.%s", channel.Name, channel.Namespace)
+}
+
+// inFlight returns the number of events being received, queued or delivered.
+func (h *channelHandler) inFlight() int64 {
+	inFlight := atomic.LoadInt64(&h.receiving) + h.dispatcher.inFlight()
+	if fc, ok := h.MessageHandler.(*flowControlMessageHandler); ok {
+		inFlight += fc.queued()
+	}
+	return inFlight
+}
+
+// flowControlled returns whether the events are queued for every subscriber, see flowControlMessageHandler.
+func (h *channelHandler) flowControlled() bool {
+	_, ok := h.MessageHandler.(*flowControlMessageHandler)
+	return ok
+}
+
+// startDraining stops accepting new events. It returns when the channel started draining.
+func (h *channelHandler) startDraining() time.Time {
+	h.drainOnce.Do(func() {
+		h.drainStarted = time.Now()
+		atomic.StoreInt32(&h.draining, 1)
+	})
+	return h.drainStarted
+}
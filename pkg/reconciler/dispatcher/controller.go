@@ -2,84 +2,140 @@ package dispatcher
 
 import (
 	"context"
-	"time"
+	"net/http"
 
+	"github.com/aliok/websocket-channel/pkg/apis/config"
+	"github.com/aliok/websocket-channel/pkg/channel/status"
 	"github.com/aliok/websocket-channel/pkg/client/injection/client"
-	websocketchannelinformer "github.com/aliok/websocket-channel/pkg/client/injection/informers/channels/v1alpha1/websocketchannel"
-	websocketchannelreconciler "github.com/aliok/websocket-channel/pkg/client/injection/reconciler/channels/v1alpha1/websocketchannel"
+	websocketchannelinformer "github.com/aliok/websocket-channel/pkg/client/injection/informers/channels/v1beta1/websocketchannel"
+	websocketchannelreconciler "github.com/aliok/websocket-channel/pkg/client/injection/reconciler/channels/v1beta1/websocketchannel"
+	"github.com/google/uuid"
 	"github.com/kelseyhightower/envconfig"
 	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/tools/cache"
 	"knative.dev/eventing/pkg/channel"
 	"knative.dev/eventing/pkg/channel/multichannelfanout"
+	"knative.dev/eventing/pkg/kncloudevents"
+	"knative.dev/pkg/apis/duck"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
 	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection/clients/dynamicclient"
+	"knative.dev/pkg/kmeta"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+	"knative.dev/pkg/tracing"
+	tracingconfig "knative.dev/pkg/tracing/config"
 )
 
 const (
-	readTimeout   = 15 * time.Minute
-	writeTimeout  = 15 * time.Minute
-	port          = 8080
 	finalizerName = "websocket-ch-dispatcher"
 )
 
+var subscriptionsResource = schema.GroupVersionResource{Group: "messaging.knative.dev", Version: "v1", Resource: "subscriptions"}
+
 type envConfig struct {
 	// TODO: change this environment variable to something like "PodGroupName".
 	PodName       string `envconfig:"POD_NAME" required:"true"`
 	ContainerName string `envconfig:"CONTAINER_NAME" required:"true"`
-}
-
-type NoopStatsReporter struct {
-}
 
-func (r *NoopStatsReporter) ReportEventCount(_ *channel.ReportArgs, _ int) error {
-	return nil
-}
+	// WALDir is the directory on a persistent volume the write-ahead logs of durable channels are
+	// kept in. Durable channels are not loaded without it.
+	WALDir string `envconfig:"WAL_DIR"`
 
-func (r *NoopStatsReporter) ReportEventDispatchTime(_ *channel.ReportArgs, _ int, _ time.Duration) error {
-	return nil
+	// AdminToken is the bearer token of the admin API and of the subscriber statistics the
+	// replicas collect from each other. Both are disabled without one.
+	AdminToken string `envconfig:"ADMIN_TOKEN"`
 }
 
 // NewController initializes the controller and is called by the generated code.
 // Registers event handlers to enqueue events.
 func NewController(
 	ctx context.Context,
-	_ configmap.Watcher,
+	cmw configmap.Watcher,
 ) *controller.Impl {
 	logger := logging.FromContext(ctx)
 
+	// Export the spans of the dispatcher as configured in config-tracing.
+	if err := tracing.SetupDynamicPublishing(logger, cmw, dispatcherName, tracingconfig.ConfigName); err != nil {
+		logger.Panicw("Error setting up trace publishing", zap.Error(err))
+	}
+
 	var env envConfig
 	if err := envconfig.Process("", &env); err != nil {
 		logger.Panicw("Failed to process env var", zap.Error(err))
 	}
 
-	reporter := &NoopStatsReporter{}
+	reporter := newStatsReporter(env.ContainerName, kmeta.ChildName(env.PodName, uuid.New().String()))
 
 	sh := multichannelfanout.NewMessageHandler(ctx, logger.Desugar(), channel.NewMessageDispatcher(logger.Desugar()), reporter)
 
+	// Start with the defaults until config-websocket-channel is loaded.
+	defaultConfig, _ := config.NewChannelConfigFromMap(map[string]string{})
 	args := &webSocketMessageDispatcherArgs{
-		Port:         port,
-		ReadTimeout:  readTimeout,
-		WriteTimeout: writeTimeout,
-		Handler:      sh,
-		Logger:       logger.Desugar(),
+		Config:  defaultConfig,
+		Handler: sh,
+		Logger:  logger.Desugar(),
 	}
 	webSocketDispatcher := newMessageDispatcher(args)
 
+	statusHandler := status.NewHandler()
+
+	// Watch the endpoints of the dispatcher Service only, to find the other replicas.
+	endpointsInformerFactory := informers.NewSharedInformerFactoryWithOptions(kubeclient.Get(ctx), controller.GetResyncPeriod(ctx),
+		informers.WithNamespace(system.Namespace()),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", dispatcherName).String()
+		}))
+	endpointsInformer := endpointsInformerFactory.Core().V1().Endpoints()
+	endpointsInformer.Informer()
+	endpointsInformerFactory.Start(ctx.Done())
+
+	// Watch the metadata of the Subscriptions, whose annotations opt their subscriber in to batching.
+	subscriptionInformer, subscriptionLister, err := (&duck.TypedInformerFactory{
+		Client:       dynamicclient.Get(ctx),
+		Type:         &duckv1.KResource{},
+		ResyncPeriod: controller.GetResyncPeriod(ctx),
+		StopChannel:  ctx.Done(),
+	}).Get(ctx, subscriptionsResource)
+	if err != nil {
+		logger.Panicw("Failed to watch the subscriptions", zap.Error(err))
+	}
+
 	r := &Reconciler{
 		multiChannelMessageHandler: sh,
-		clientSet:                  client.Get(ctx).ChannelsV1alpha1(),
+		statusHandler:              statusHandler,
+		clientSet:                  client.Get(ctx).ChannelsV1beta1(),
 		reporter:                   reporter,
+		healthTrackers:             make(map[string]*healthTracker),
+		externalHosts:              make(map[string]string),
+		walDir:                     env.WALDir,
+		statsCollector:             newStatsCollector(endpointsInformer.Lister().Endpoints(system.Namespace()), env.PodName, env.AdminToken),
+		subscriptionLister:         subscriptionLister,
 	}
+	webSocketChannelInformer := websocketchannelinformer.Get(ctx)
+
 	impl := websocketchannelreconciler.NewImpl(ctx, r, func(impl *controller.Impl) controller.Options {
-		return controller.Options{SkipStatusUpdates: true, FinalizerName: finalizerName}
+		// Apply changes of the cluster-wide configuration to the server and to all channels.
+		configStore := config.NewStore(logger.Named("config-store"), func(name string, value interface{}) {
+			if c, ok := value.(*config.Channel); ok {
+				webSocketDispatcher.UpdateConfig(c)
+			}
+			impl.GlobalResync(webSocketChannelInformer.Informer())
+		})
+		configStore.WatchConfigs(cmw)
+		return controller.Options{SkipStatusUpdates: true, FinalizerName: finalizerName, ConfigStore: configStore}
 	})
+	r.enqueueKey = impl.EnqueueKey
+	r.enqueueAfter = impl.EnqueueAfter
 
 	logging.FromContext(ctx).Info("Setting up event handlers")
 
-	webSocketChannelInformer := websocketchannelinformer.Get(ctx)
-
 	// Watch for channels.
 	webSocketChannelInformer.Informer().AddEventHandler(
 		cache.FilteringResourceEventHandler{
@@ -90,6 +146,13 @@ func NewController(
 				DeleteFunc: r.deleteFunc,
 			}})
 
+	// Watch for subscriptions, their batching annotations may have changed.
+	enqueueSubscribed := enqueueSubscribedChannels(webSocketChannelInformer.Lister(), impl.EnqueueKey)
+	subscriptionInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueSubscribed,
+		UpdateFunc: controller.PassNew(enqueueSubscribed),
+	})
+
 	// Start the dispatcher.
 	go func() {
 		err := webSocketDispatcher.Start(ctx)
@@ -98,5 +161,29 @@ func NewController(
 		}
 	}()
 
+	// Start serving the loaded channels to the status prober of the controller, and the subscriber
+	// statistics to the replica that is the leader. The statistics are authenticated with the admin token.
+	statusMux := http.NewServeMux()
+	statusMux.Handle(status.Path, statusHandler)
+	if env.AdminToken != "" {
+		statusMux.Handle(statsPath, r.statsHandler(env.PodName, env.AdminToken))
+	}
+	go func() {
+		err := kncloudevents.NewHTTPMessageReceiver(status.Port).StartListen(ctx, statusMux)
+		if err != nil {
+			logging.FromContext(ctx).Errorw("Failed stopping channel status server.", zap.Error(err))
+		}
+	}()
+
+	// Start serving the admin API, if it is enabled.
+	if env.AdminToken != "" {
+		go func() {
+			err := kncloudevents.NewHTTPMessageReceiver(adminPort).StartListen(ctx, r.adminHandler(env.AdminToken))
+			if err != nil {
+				logging.FromContext(ctx).Errorw("Failed stopping admin server.", zap.Error(err))
+			}
+		}()
+	}
+
 	return impl
 }
@@ -2,42 +2,170 @@ package dispatcher
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/aliok/websocket-channel/pkg/apis/config"
 	"go.uber.org/zap"
 	"knative.dev/eventing/pkg/channel/multichannelfanout"
 	"knative.dev/eventing/pkg/kncloudevents"
+	"knative.dev/pkg/network/handlers"
 )
 
+// listenTimeout bounds how long a restarted HTTP server waits for the port to be released
+// by the previous one.
+const listenTimeout = 5 * time.Second
+
 type webSocketMessageDispatcher struct {
-	handler              multichannelfanout.MultiChannelMessageHandler
-	httpBindingsReceiver *kncloudevents.HTTPMessageReceiver
-	writeTimeout         time.Duration
-	logger               *zap.Logger
+	handler multichannelfanout.MultiChannelMessageHandler
+	logger  *zap.Logger
+
+	// config holds the current *config.Channel.
+	config atomic.Value
+	// restart is signaled when the settings of the HTTP server change.
+	restart chan struct{}
 }
 
 type webSocketMessageDispatcherArgs struct {
-	Port         int
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
-	Handler      multichannelfanout.MultiChannelMessageHandler
-	Logger       *zap.Logger
+	Config  *config.Channel
+	Handler multichannelfanout.MultiChannelMessageHandler
+	Logger  *zap.Logger
 }
 
 func newMessageDispatcher(args *webSocketMessageDispatcherArgs) *webSocketMessageDispatcher {
-	// TODO set read timeouts?
-	bindingsReceiver := kncloudevents.NewHTTPMessageReceiver(args.Port)
-
 	dispatcher := &webSocketMessageDispatcher{
-		handler:              args.Handler,
-		httpBindingsReceiver: bindingsReceiver,
-		logger:               args.Logger,
-		writeTimeout:         args.WriteTimeout,
+		handler: args.Handler,
+		logger:  args.Logger,
+		restart: make(chan struct{}, 1),
 	}
+	dispatcher.config.Store(args.Config)
 
 	return dispatcher
 }
 
+func (d *webSocketMessageDispatcher) currentConfig() *config.Channel {
+	return d.config.Load().(*config.Channel)
+}
+
+// UpdateConfig applies a new configuration. The maximum message size is applied to the next
+// request, the HTTP server is restarted when its port or timeouts change.
+func (d *webSocketMessageDispatcher) UpdateConfig(c *config.Channel) {
+	old := d.currentConfig()
+	d.config.Store(c)
+	if old.Port != c.Port || old.ReadTimeout != c.ReadTimeout || old.WriteTimeout != c.WriteTimeout {
+		select {
+		case d.restart <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (d *webSocketMessageDispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if maxSize := d.currentConfig().MaxMessageSize; maxSize > 0 {
+		if r.ContentLength > maxSize {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, maxSize), limit: maxSize}
+	}
+	d.handler.ServeHTTP(w, r)
+}
+
+// bodyTooLargeError is returned reading a request body past the maximum message size.
+type bodyTooLargeError struct {
+	limit int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return fmt.Sprintf("the request body is larger than the maximum message size of %d bytes", e.limit)
+}
+
+// limitedBody is a request body bounded by http.MaxBytesReader, returning a *bodyTooLargeError once
+// its limit is exceeded. http.MaxBytesReader fails reading past its limit, after the whole limit
+// was read.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		return n, &bodyTooLargeError{limit: b.limit}
+	}
+	return n, err
+}
+
+// Start serves events until ctx is done. Requests in flight are given the write timeout to
+// complete when the HTTP server is restarted or stopped.
 func (d *webSocketMessageDispatcher) Start(ctx context.Context) error {
-	return d.httpBindingsReceiver.StartListen(kncloudevents.WithShutdownTimeout(ctx, d.writeTimeout), d.handler)
+	drainer := &handlers.Drainer{
+		Inner: kncloudevents.CreateHandler(d),
+	}
+
+	for {
+		cfg := d.currentConfig()
+		listener, err := listen(ctx, cfg.Port)
+		if err != nil {
+			return err
+		}
+		server := &http.Server{
+			Addr:         listener.Addr().String(),
+			Handler:      drainer,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		}
+
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- server.Serve(listener)
+		}()
+
+		select {
+		case <-ctx.Done():
+			// As we start to shutdown, disable keep-alives to avoid clients hanging onto connections.
+			server.SetKeepAlivesEnabled(false)
+			drainer.Drain()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.WriteTimeout)
+			defer cancel()
+			err := server.Shutdown(shutdownCtx)
+			<-errChan // Wait for server goroutine to exit
+			return err
+		case <-d.restart:
+			d.logger.Info("Restarting the HTTP server to apply the new configuration")
+			server.SetKeepAlivesEnabled(false)
+			go func() {
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.WriteTimeout)
+				defer cancel()
+				if err := server.Shutdown(shutdownCtx); err != nil {
+					d.logger.Warn("Failed to shut down the previous HTTP server", zap.Error(err))
+				}
+			}()
+		case err := <-errChan:
+			return err
+		}
+	}
+}
+
+// listen listens on the given port, retrying while it is still held by a previous server.
+func listen(ctx context.Context, port int) (net.Listener, error) {
+	ctx, cancel := context.WithTimeout(ctx, listenTimeout)
+	defer cancel()
+	for {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err == nil {
+			return listener, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
 }
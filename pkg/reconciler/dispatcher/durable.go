@@ -0,0 +1,280 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	nethttp "net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aliok/websocket-channel/pkg/channel/wal"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/channel/fanout"
+)
+
+// durableRetryInterval is the time a durable subscription waits before trying again to read an
+// event that could not be read from the log.
+const durableRetryInterval = 5 * time.Second
+
+// durableMessageHandler is the fanout.MessageHandler of a durable channel. Accepted events are
+// appended to a write-ahead log before they are acknowledged. Every subscriber reads the events
+// from the log in order and commits its cursor once an event is delivered, so deliveries resume
+// from the committed cursors when the dispatcher restarts.
+type durableMessageHandler struct {
+	logger     *zap.Logger
+	log        *wal.Log
+	dispatcher channel.MessageDispatcher
+	reporter   channel.StatsReporter
+	namespace  string
+
+	mu            sync.Mutex
+	subscriptions []fanout.Subscription
+	deliveries    map[string]*durableDelivery
+	wg            sync.WaitGroup
+}
+
+var _ fanout.MessageHandler = (*durableMessageHandler)(nil)
+
+// durableDelivery delivers the events of the log to a single subscriber.
+type durableDelivery struct {
+	mu           sync.Mutex
+	subscription fanout.Subscription
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+func (d *durableDelivery) getSubscription() fanout.Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.subscription
+}
+
+func (d *durableDelivery) setSubscription(sub fanout.Subscription) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscription = sub
+}
+
+func newDurableMessageHandler(logger *zap.Logger, log *wal.Log, dispatcher channel.MessageDispatcher, reporter channel.StatsReporter, namespace string) *durableMessageHandler {
+	return &durableMessageHandler{
+		logger:     logger,
+		log:        log,
+		dispatcher: dispatcher,
+		reporter:   reporter,
+		namespace:  namespace,
+		deliveries: make(map[string]*durableDelivery),
+	}
+}
+
+// ServeHTTP accepts an event once it is synced to the log.
+func (h *durableMessageHandler) ServeHTTP(response nethttp.ResponseWriter, request *nethttp.Request) {
+	if request.Method != nethttp.MethodPost {
+		response.WriteHeader(nethttp.StatusMethodNotAllowed)
+		return
+	}
+	if request.URL.Path != "/" {
+		response.WriteHeader(nethttp.StatusNotFound)
+		return
+	}
+
+	args := &channel.ReportArgs{Ns: h.namespace}
+	message := cehttp.NewMessageFromHttpRequest(request)
+	if message.ReadEncoding() == binding.EncodingUnknown {
+		response.WriteHeader(nethttp.StatusBadRequest)
+		_ = h.reporter.ReportEventCount(args, nethttp.StatusBadRequest)
+		return
+	}
+	event, err := binding.ToEvent(request.Context(), message)
+	if err == nil {
+		err = event.Validate()
+	}
+	if err != nil {
+		h.logger.Info("Rejecting invalid event", zap.Error(err))
+		response.WriteHeader(nethttp.StatusBadRequest)
+		_ = h.reporter.ReportEventCount(args, nethttp.StatusBadRequest)
+		return
+	}
+	args.EventType = event.Type()
+
+	data, err := json.Marshal(event)
+	if err == nil {
+		_, err = h.log.Append(data)
+	}
+	if err != nil {
+		h.logger.Error("Failed to append the event to the log", zap.Error(err))
+		response.WriteHeader(nethttp.StatusServiceUnavailable)
+		_ = h.reporter.ReportEventCount(args, nethttp.StatusServiceUnavailable)
+		return
+	}
+	response.WriteHeader(nethttp.StatusAccepted)
+}
+
+func (h *durableMessageHandler) GetSubscriptions(_ context.Context) []fanout.Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs := make([]fanout.Subscription, len(h.subscriptions))
+	copy(subs, h.subscriptions)
+	return subs
+}
+
+// SetSubscriptions starts delivering to new subscribers, from the end of the log or from their
+// committed cursor, and stops delivering to removed ones, dropping their cursor.
+func (h *durableMessageHandler) SetSubscriptions(_ context.Context, subs []fanout.Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := sets.NewString()
+	for _, sub := range subs {
+		key := subscriberKey(sub.Subscriber, sub.Reply)
+		keys.Insert(key)
+		if d, ok := h.deliveries[key]; ok {
+			d.setSubscription(sub)
+			continue
+		}
+		if _, ok := h.log.Cursor(key); !ok {
+			if err := h.log.Commit(key, h.log.End()); err != nil {
+				h.logger.Error("Failed to commit the cursor of a new subscriber", zap.String("subscriber", key), zap.Error(err))
+			}
+		}
+		deliveryCtx, cancel := context.WithCancel(context.Background())
+		d := &durableDelivery{subscription: sub, cancel: cancel, done: make(chan struct{})}
+		h.deliveries[key] = d
+		h.wg.Add(1)
+		go func(key string) {
+			defer h.wg.Done()
+			defer close(d.done)
+			h.deliver(deliveryCtx, key, d)
+		}(key)
+	}
+
+	for key, d := range h.deliveries {
+		if keys.Has(key) {
+			continue
+		}
+		d.cancel()
+		delete(h.deliveries, key)
+		// Only drop the cursor once the delivery stopped committing it.
+		go func(key string, d *durableDelivery) {
+			<-d.done
+			if err := h.log.DeleteCursor(key); err != nil {
+				h.logger.Error("Failed to delete the cursor of a removed subscriber", zap.String("subscriber", key), zap.Error(err))
+			}
+		}(key, d)
+	}
+	h.subscriptions = subs
+}
+
+// deliver sends the events of the log to the subscriber, starting at its committed cursor. An
+// event is retried as the subscription asks for, and sent to its dead letter sink if it has one.
+// An event that could be delivered to neither is given up, so later events don't wait for it.
+// Events that can't be read from the log anymore are skipped, see wal.Log.Skip.
+//
+// Events without a time attribute expire relative to their first delivery attempt since the
+// dispatcher started, as the log doesn't record when they were accepted.
+func (h *durableMessageHandler) deliver(ctx context.Context, key string, d *durableDelivery) {
+	offset, _ := h.log.Cursor(key)
+	var firstAttempt time.Time
+	for {
+		appended := h.log.Appended()
+		data, next, err := h.log.Read(offset)
+		if err == io.EOF {
+			select {
+			case <-appended:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if errors.Is(err, wal.ErrUnreadable) {
+			// Waiting doesn't make the event readable, so it is given up like an undeliverable one.
+			skipped := h.log.Skip(offset)
+			h.logger.Error("Failed to read events from the log, skipping them", zap.String("subscriber", key), zap.Int64("offset", offset), zap.Int64("next", skipped), zap.Error(err))
+			if err := h.log.Commit(key, skipped); err != nil {
+				h.logger.Error("Failed to commit the cursor of a subscriber", zap.String("subscriber", key), zap.Error(err))
+			}
+			offset = skipped
+			continue
+		}
+		if err != nil {
+			h.logger.Warn("Failed to read an event from the log, retrying", zap.String("subscriber", key), zap.Int64("offset", offset), zap.Error(err))
+			select {
+			case <-time.After(durableRetryInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if firstAttempt.IsZero() {
+			firstAttempt = time.Now()
+		}
+		err = h.dispatch(contextWithFirstAttempt(ctx, firstAttempt), d.getSubscription(), data)
+		if ctx.Err() != nil {
+			return
+		}
+		// Expired events are done with, whether they were dead lettered or dropped.
+		if err != nil && !errors.Is(err, errEventExpired) {
+			h.logger.Error("Failed to deliver an event from the log, giving up", zap.String("subscriber", key), zap.Int64("offset", offset), zap.Error(err))
+		}
+		if err := h.log.Commit(key, next); err != nil {
+			h.logger.Error("Failed to commit the cursor of a subscriber", zap.String("subscriber", key), zap.Error(err))
+		}
+		offset = next
+		firstAttempt = time.Time{}
+	}
+}
+
+func (h *durableMessageHandler) dispatch(ctx context.Context, sub fanout.Subscription, data []byte) error {
+	event := cloudevents.NewEvent()
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+
+	args := &channel.ReportArgs{Ns: h.namespace, EventType: event.Type()}
+	info, err := h.dispatcher.DispatchMessageWithRetries(ctx, binding.ToMessage(&event), nil, sub.Subscriber, sub.Reply, sub.DeadLetter, sub.RetryConfig)
+	reportDispatch(h.reporter, args, info, err)
+	return err
+}
+
+// close stops all deliveries and closes the log.
+func (h *durableMessageHandler) close() error {
+	h.mu.Lock()
+	for _, d := range h.deliveries {
+		d.cancel()
+	}
+	h.deliveries = make(map[string]*durableDelivery)
+	h.mu.Unlock()
+
+	h.wg.Wait()
+	return h.log.Close()
+}
+
+// deleteWhenDrained closes the log and deletes its directory once every subscriber was sent all
+// the events of the log, checking every interval. The deliveries go on until then. The returned
+// channel is closed once the log is deleted.
+func (h *durableMessageHandler) deleteWhenDrained(interval time.Duration) <-chan struct{} {
+	deleted := make(chan struct{})
+	go func() {
+		defer close(deleted)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for !h.log.Drained() {
+			<-ticker.C
+		}
+		if err := h.close(); err != nil {
+			h.logger.Warn("Failed to close the write-ahead log", zap.Error(err))
+		}
+		if err := os.RemoveAll(h.log.Dir()); err != nil {
+			h.logger.Warn("Failed to delete the write-ahead log", zap.Error(err))
+		}
+	}()
+	return deleted
+}
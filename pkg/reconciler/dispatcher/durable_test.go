@@ -0,0 +1,210 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliok/websocket-channel/pkg/channel/wal"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"go.uber.org/zap"
+	"knative.dev/eventing/pkg/channel/fanout"
+)
+
+func newTestDurableHandler(t *testing.T, log *wal.Log, dispatcher *fakeDispatcher) *durableMessageHandler {
+	t.Helper()
+	h := newDurableMessageHandler(zap.NewNop(), log, newTestDispatcher(dispatcher, newHealthTracker(nil)), testStatsReporter(), "ns")
+	t.Cleanup(func() {
+		if err := h.close(); err != nil {
+			t.Error("close() =", err)
+		}
+	})
+	return h
+}
+
+func postEvent(h nethttp.Handler, id string) int {
+	request := httptest.NewRequest(nethttp.MethodPost, "/", strings.NewReader(`{"hello":"world"}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Ce-Specversion", "1.0")
+	request.Header.Set("Ce-Id", id)
+	request.Header.Set("Ce-Type", "test.type")
+	request.Header.Set("Ce-Source", "test-source")
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	return response.Code
+}
+
+func TestDurableMessageHandlerDelivers(t *testing.T) {
+	log, err := wal.Open(t.TempDir(), wal.DefaultSegmentSize, wal.DefaultFlushInterval)
+	if err != nil {
+		t.Fatal("Open() =", err)
+	}
+	fake := &fakeDispatcher{fail: map[string]bool{"http://failing.ns.svc.cluster.local/": true}}
+	h := newTestDurableHandler(t, log, fake)
+
+	subscriber := mustParseURL(t, "http://subscriber.ns.svc.cluster.local/")
+	failing := mustParseURL(t, "http://failing.ns.svc.cluster.local/")
+	deadLetter := mustParseURL(t, "http://dls.ns.svc.cluster.local/")
+	h.SetSubscriptions(context.Background(), []fanout.Subscription{
+		{Subscriber: subscriber},
+		{Subscriber: failing, DeadLetter: deadLetter},
+	})
+
+	for _, id := range []string{"1", "2", "3"} {
+		if code := postEvent(h, id); code != nethttp.StatusAccepted {
+			t.Fatalf("Status = %d, want %d", code, nethttp.StatusAccepted)
+		}
+	}
+	// The events of the failing subscriber go to its dead letter sink, they don't block later events.
+	waitFor(t, "the events to be delivered", func() bool { return len(fake.destinations()) == 9 })
+	counts := make(map[string]int)
+	for _, destination := range fake.destinations() {
+		counts[destination]++
+	}
+	for _, destination := range []string{subscriber.String(), failing.String(), deadLetter.String()} {
+		if counts[destination] != 3 {
+			t.Errorf("Delivered %d events to %s, want 3", counts[destination], destination)
+		}
+	}
+	for _, key := range []string{subscriberKey(subscriber, nil), subscriberKey(failing, nil)} {
+		waitFor(t, "the cursor to be committed", func() bool {
+			cursor, _ := log.Cursor(key)
+			return cursor == log.End()
+		})
+	}
+}
+
+func TestDurableMessageHandlerRejectsInvalidEvents(t *testing.T) {
+	log, err := wal.Open(t.TempDir(), wal.DefaultSegmentSize, wal.DefaultFlushInterval)
+	if err != nil {
+		t.Fatal("Open() =", err)
+	}
+	h := newTestDurableHandler(t, log, &fakeDispatcher{})
+
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest(nethttp.MethodGet, "/", nil))
+	if response.Code != nethttp.StatusMethodNotAllowed {
+		t.Errorf("Status = %d for a GET, want %d", response.Code, nethttp.StatusMethodNotAllowed)
+	}
+
+	response = httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest(nethttp.MethodPost, "/", strings.NewReader("not an event")))
+	if response.Code != nethttp.StatusBadRequest {
+		t.Errorf("Status = %d for an invalid event, want %d", response.Code, nethttp.StatusBadRequest)
+	}
+	if log.End() != 0 {
+		t.Errorf("End() = %d, invalid events were appended to the log", log.End())
+	}
+}
+
+func TestDurableMessageHandlerSkipsUnreadableEvents(t *testing.T) {
+	dir := t.TempDir()
+	// Every event gets a segment of its own.
+	log, err := wal.Open(dir, 1, wal.DefaultFlushInterval)
+	if err != nil {
+		t.Fatal("Open() =", err)
+	}
+	subscriber := mustParseURL(t, "http://subscriber.ns.svc.cluster.local/")
+	key := subscriberKey(subscriber, nil)
+	if err := log.Commit(key, 0); err != nil {
+		t.Fatal("Commit() =", err)
+	}
+
+	var offsets []int64
+	for _, id := range []string{"1", "2", "3"} {
+		event := cloudevents.NewEvent()
+		event.SetID(id)
+		event.SetType("test.type")
+		event.SetSource("test-source")
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal("Marshal() =", err)
+		}
+		offset, err := log.Append(data)
+		if err != nil {
+			t.Fatal("Append() =", err)
+		}
+		offsets = append(offsets, offset)
+	}
+
+	// Corrupt the segment of the second event.
+	path := segmentPath(dir, offsets[1])
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("ReadFile() =", err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal("WriteFile() =", err)
+	}
+
+	fake := &fakeDispatcher{}
+	h := newTestDurableHandler(t, log, fake)
+	h.SetSubscriptions(context.Background(), []fanout.Subscription{{Subscriber: subscriber}})
+
+	waitFor(t, "the cursor to reach the end of the log", func() bool {
+		cursor, _ := log.Cursor(key)
+		return cursor == log.End()
+	})
+	if got := len(fake.destinations()); got != 2 {
+		t.Errorf("Delivered %d events, want the 2 readable ones", got)
+	}
+}
+
+// segmentPath returns the path of the segment of the log in dir starting at the given offset.
+func segmentPath(dir string, base int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d.log", base))
+}
+
+func TestDurableMessageHandlerDeletesLogWhenDrained(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "channel")
+	log, err := wal.Open(dir, wal.DefaultSegmentSize, wal.DefaultFlushInterval)
+	if err != nil {
+		t.Fatal("Open() =", err)
+	}
+	fake := &fakeDispatcher{}
+	dispatcher := newTestDispatcher(fake, newHealthTracker(nil))
+	h := newDurableMessageHandler(zap.NewNop(), log, dispatcher, testStatsReporter(), "ns")
+
+	subscriber := mustParseURL(t, "http://subscriber.ns.svc.cluster.local/")
+	key := subscriberKey(subscriber, nil)
+	h.SetSubscriptions(context.Background(), []fanout.Subscription{{Subscriber: subscriber}})
+	// The deliveries to the subscriber are held until it is resumed.
+	dispatcher.setPaused(key, true)
+	for _, id := range []string{"1", "2"} {
+		if code := postEvent(h, id); code != nethttp.StatusAccepted {
+			t.Fatalf("Status = %d, want %d", code, nethttp.StatusAccepted)
+		}
+	}
+
+	deleted := h.deleteWhenDrained(time.Millisecond)
+	select {
+	case <-deleted:
+		t.Fatal("Deleted the log before the events were delivered")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatal("Stat() =", err)
+	}
+
+	dispatcher.setPaused(key, false)
+	select {
+	case <-deleted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the log to be deleted")
+	}
+	if got := len(fake.destinations()); got != 2 {
+		t.Errorf("Delivered %d events, want 2", got)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Stat() = %v, want the log directory to be deleted", err)
+	}
+}
@@ -0,0 +1,150 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"time"
+
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/binding/spec"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"github.com/rickb777/date/period"
+	"knative.dev/eventing/pkg/kncloudevents"
+)
+
+const (
+	// ttlExtension is the extension attribute an event sets its own time to live in, as an ISO 8601 duration.
+	ttlExtension = "ttl"
+
+	// expiredErrorCode is the knativeerrorcode of the expired events sent to the dead letter sink.
+	expiredErrorCode = "expired"
+)
+
+// errEventExpired is returned for events that expired before they could be delivered.
+var errEventExpired = errors.New("event expired")
+
+// expiryConfig is the expiry of the events of a channel, see v1beta1.WebSocketChannelExpiry.
+type expiryConfig struct {
+	// ttl is the time to live of the events of the channel, zero if only the events set one.
+	ttl        time.Duration
+	deadLetter bool
+}
+
+// newExpiryConfig returns the expiry of the events of a channel. Without one, only the events
+// setting a ttl extension attribute expire.
+func newExpiryConfig(expiry *v1beta1.WebSocketChannelExpiry) (*expiryConfig, error) {
+	if expiry == nil {
+		return &expiryConfig{}, nil
+	}
+	config := &expiryConfig{deadLetter: expiry.DeadLetter}
+	if expiry.TTL != nil {
+		ttl, err := period.Parse(*expiry.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Spec.Expiry.TTL: %w", err)
+		}
+		config.ttl, _ = ttl.Duration()
+	}
+	return config, nil
+}
+
+// firstAttemptKey is the context key of the time of the first delivery attempt of an event, for
+// events delivered again by a caller of the dispatcher.
+type firstAttemptKey struct{}
+
+func contextWithFirstAttempt(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, firstAttemptKey{}, t)
+}
+
+// deadline returns the time the event expires at, and whether it expires at all. The time to live
+// is counted from the time attribute of the event, or from its first delivery attempt if it has
+// none. The ttl extension of the event shortens the time to live of the channel.
+func (c *expiryConfig) deadline(ctx context.Context, message binding.Message) (time.Time, string, bool) {
+	eventType, eventTime, eventTTL := expiryAttributes(ctx, message)
+	ttl := c.ttl
+	if eventTTL != "" {
+		if p, err := period.Parse(eventTTL); err == nil {
+			if d, _ := p.Duration(); d > 0 && (ttl == 0 || d < ttl) {
+				ttl = d
+			}
+		}
+	}
+	if ttl == 0 {
+		return time.Time{}, eventType, false
+	}
+
+	if eventTime.IsZero() {
+		eventTime = time.Now()
+		if t, ok := ctx.Value(firstAttemptKey{}).(time.Time); ok {
+			eventTime = t
+		}
+	}
+	return eventTime.Add(ttl), eventType, true
+}
+
+// expiryAttributes returns the type, time and ttl extension of the event.
+func expiryAttributes(ctx context.Context, message binding.Message) (string, time.Time, string) {
+	var eventType, eventTTL string
+	var eventTime time.Time
+
+	reader := messageMetadata(ctx, message)
+	if reader == nil {
+		return "", time.Time{}, ""
+	}
+	if _, v := reader.GetAttribute(spec.Type); v != nil {
+		eventType, _ = types.ToString(v)
+	}
+	if _, v := reader.GetAttribute(spec.Time); v != nil {
+		eventTime, _ = types.ToTime(v)
+	}
+	if v := reader.GetExtension(ttlExtension); v != nil {
+		eventTTL, _ = types.ToString(v)
+	}
+	return eventType, eventTime, eventTTL
+}
+
+// messageMetadata returns a reader of the attributes and extensions of the event, nil if it can't
+// be read. Binary messages are read without parsing the event.
+func messageMetadata(ctx context.Context, message binding.Message) binding.MessageMetadataReader {
+	switch message.ReadEncoding() {
+	case binding.EncodingBinary, binding.EncodingEvent:
+		reader, _ := message.(binding.MessageMetadataReader)
+		return reader
+	}
+	event, err := binding.ToEvent(ctx, message)
+	if err != nil {
+		return nil
+	}
+	return (*binding.EventMessage)(event)
+}
+
+// retryUntil stops retrying a delivery once the event expired, and never waits for a retry past it.
+func retryUntil(config *kncloudevents.RetryConfig, deadline time.Time) *kncloudevents.RetryConfig {
+	if config == nil {
+		return nil
+	}
+	c := *config
+	checkRetry, backoff := c.CheckRetry, c.Backoff
+	c.CheckRetry = func(ctx context.Context, resp *nethttp.Response, err error) (bool, error) {
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+		if checkRetry == nil {
+			return kncloudevents.RetryIfGreaterThan300(ctx, resp, err)
+		}
+		return checkRetry(ctx, resp, err)
+	}
+	c.Backoff = func(attemptNum int, resp *nethttp.Response) time.Duration {
+		var wait time.Duration
+		if backoff != nil {
+			wait = backoff(attemptNum, resp)
+		}
+		if remaining := time.Until(deadline); wait > remaining {
+			wait = remaining
+		}
+		return wait
+	}
+	return &c
+}
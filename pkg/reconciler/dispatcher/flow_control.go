@@ -0,0 +1,501 @@
+package dispatcher
+
+import (
+	"context"
+	nethttp "net/http"
+	"sync"
+	"time"
+
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/binding/buffering"
+	"github.com/cloudevents/sdk-go/v2/binding/spec"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	cetypes "github.com/cloudevents/sdk-go/v2/types"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/channel/fanout"
+	"knative.dev/eventing/pkg/kncloudevents"
+	"knative.dev/eventing/pkg/utils"
+)
+
+const (
+	// overflowErrorCode is the knativeerrorcode of the events sent to the dead letter sink because
+	// their subscriber had no room for them.
+	overflowErrorCode = "overflow"
+
+	// partitionKeyExtension is the extension attribute of the CloudEvents partitioning extension.
+	partitionKeyExtension = "partitionkey"
+)
+
+// flowLimits bound the events held for a single subscriber, and order their deliveries.
+type flowLimits struct {
+	maxInFlight int
+	queueLength int
+	ordered     bool
+}
+
+// flowControlConfig is the flow control and the ordering of a channel, see
+// v1beta1.WebSocketChannelFlowControl and v1beta1.DeliveryOrdering.
+type flowControlConfig struct {
+	limits   flowLimits
+	overflow v1beta1.OverflowPolicy
+
+	// subscribers holds the limits overridden for single subscribers, keyed by subscriber key.
+	subscribers map[string]flowLimits
+}
+
+// newFlowControlConfig returns the flow control of a channel, nil if it has none. Ordered
+// channels get the default flow control.
+func newFlowControlConfig(wsc *v1beta1.WebSocketChannel) *flowControlConfig {
+	ordered := wsc.Spec.Ordering == v1beta1.OrderingPartitionKey
+	if wsc.Spec.FlowControl == nil && !ordered {
+		return nil
+	}
+	spec := wsc.Spec.DeepCopy()
+	if spec.FlowControl == nil {
+		spec.FlowControl = &v1beta1.WebSocketChannelFlowControl{}
+	}
+	spec.SetDefaults(context.Background())
+	fc := spec.FlowControl
+
+	config := &flowControlConfig{
+		limits: flowLimits{
+			maxInFlight: int(*fc.MaxInFlight),
+			queueLength: int(*fc.QueueLength),
+			ordered:     ordered,
+		},
+		overflow:    fc.Overflow,
+		subscribers: make(map[string]flowLimits, len(fc.Subscribers)),
+	}
+	keys := make(map[types.UID]string, len(wsc.Spec.Subscribers))
+	for _, sub := range wsc.Spec.Subscribers {
+		keys[sub.UID] = specSubscriberKey(sub)
+	}
+	for _, sub := range fc.Subscribers {
+		key, ok := keys[sub.UID]
+		if !ok {
+			continue
+		}
+		limits := config.limits
+		if sub.MaxInFlight != nil {
+			limits.maxInFlight = int(*sub.MaxInFlight)
+		}
+		if sub.QueueLength != nil {
+			limits.queueLength = int(*sub.QueueLength)
+		}
+		config.subscribers[key] = limits
+	}
+	return config
+}
+
+func (c *flowControlConfig) limitsFor(key string) flowLimits {
+	if limits, ok := c.subscribers[key]; ok {
+		return limits
+	}
+	return c.limits
+}
+
+// flowControlMessageHandler is the fanout.MessageHandler of a channel with flow control or ordered
+// delivery. Instead of starting a delivery to every subscriber for every event, it keeps a bounded
+// queue of events per subscriber, delivered by at most maxInFlight goroutines.
+type flowControlMessageHandler struct {
+	logger     *zap.Logger
+	dispatcher *healthTrackingDispatcher
+	reporter   channel.StatsReporter
+	namespace  string
+
+	// mu is held while an event is added to the queues of all subscribers. Queues only make room
+	// without it, so an event is either added to all of them or rejected.
+	mu            sync.Mutex
+	config        *flowControlConfig
+	subscriptions []fanout.Subscription
+	queues        map[string]*subscriberQueue
+}
+
+var _ fanout.MessageHandler = (*flowControlMessageHandler)(nil)
+
+func newFlowControlMessageHandler(logger *zap.Logger, dispatcher *healthTrackingDispatcher, reporter channel.StatsReporter, namespace string, config *flowControlConfig) *flowControlMessageHandler {
+	return &flowControlMessageHandler{
+		logger:     logger,
+		dispatcher: dispatcher,
+		reporter:   reporter,
+		namespace:  namespace,
+		config:     config,
+		queues:     make(map[string]*subscriberQueue),
+	}
+}
+
+// queuedEvent is an event waiting in the queue of a subscriber.
+type queuedEvent struct {
+	ctx               context.Context
+	message           binding.Message
+	additionalHeaders nethttp.Header
+	args              *channel.ReportArgs
+
+	// partitionKey orders the event with the other events of the key, if the channel is ordered.
+	partitionKey string
+}
+
+// ServeHTTP accepts an event if every subscriber has room for it. Subscribers without room reject
+// the event, or send it to their dead letter sink, depending on the overflow policy.
+func (h *flowControlMessageHandler) ServeHTTP(response nethttp.ResponseWriter, request *nethttp.Request) {
+	if request.Method != nethttp.MethodPost {
+		response.WriteHeader(nethttp.StatusMethodNotAllowed)
+		return
+	}
+	if request.URL.Path != "/" {
+		response.WriteHeader(nethttp.StatusNotFound)
+		return
+	}
+
+	args := &channel.ReportArgs{Ns: h.namespace}
+	message := cehttp.NewMessageFromHttpRequest(request)
+	if message.ReadEncoding() == binding.EncodingUnknown {
+		response.WriteHeader(nethttp.StatusBadRequest)
+		_ = h.reporter.ReportEventCount(args, nethttp.StatusBadRequest)
+		return
+	}
+	te := kncloudevents.TypeExtractorTransformer("")
+	bufferedMessage, err := buffering.CopyMessage(request.Context(), message, &te)
+	_ = message.Finish(nil)
+	if err != nil {
+		h.logger.Info("Failed to buffer the event", zap.Error(err))
+		response.WriteHeader(nethttp.StatusInternalServerError)
+		return
+	}
+	args.EventType = string(te)
+
+	// Deliveries outlive the request. Events without a time attribute expire relative to the
+	// time they are accepted, not the time they leave the queue.
+	ctx := trace.NewContext(context.Background(), trace.FromContext(request.Context()))
+	ctx = contextWithFirstAttempt(ctx, time.Now())
+	event := queuedEvent{
+		ctx:               ctx,
+		message:           bufferedMessage,
+		additionalHeaders: utils.PassThroughHeaders(request.Header),
+		args:              args,
+	}
+	if h.ordered() {
+		event.partitionKey = partitionKey(request.Context(), bufferedMessage)
+	}
+	overflowed, ok := h.enqueue(&event)
+	if !ok {
+		_ = bufferedMessage.Finish(nil)
+		response.WriteHeader(nethttp.StatusServiceUnavailable)
+		_ = h.reporter.ReportEventCount(args, nethttp.StatusServiceUnavailable)
+		return
+	}
+	// Dead letter the event for the subscribers without room before accepting it, slowing the
+	// publisher down rather than holding more events.
+	for _, sub := range overflowed {
+		h.overflow(request.Context(), event, sub)
+	}
+	response.WriteHeader(nethttp.StatusAccepted)
+}
+
+// enqueue adds the event to the queue of every subscriber. It returns the subscriptions that had no
+// room for it, or false if the event is rejected.
+func (h *flowControlMessageHandler) enqueue(event *queuedEvent) ([]fanout.Subscription, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subscriptions) == 0 {
+		_ = event.message.Finish(nil)
+		return nil, true
+	}
+	if h.config.overflow == v1beta1.OverflowReject {
+		for _, sub := range h.subscriptions {
+			if !h.queues[subscriberKey(sub.Subscriber, sub.Reply)].hasRoom(*event) {
+				return nil, false
+			}
+		}
+	}
+
+	// Every delivery, and every overflow, finishes the message once.
+	event.message = buffering.WithAcksBeforeFinish(event.message, len(h.subscriptions))
+	var overflowed []fanout.Subscription
+	for _, sub := range h.subscriptions {
+		if !h.queues[subscriberKey(sub.Subscriber, sub.Reply)].offer(*event) {
+			overflowed = append(overflowed, sub)
+		}
+	}
+	return overflowed, true
+}
+
+// overflow sends the event to the dead letter sink of a subscriber that had no room for it.
+func (h *flowControlMessageHandler) overflow(ctx context.Context, event queuedEvent, sub fanout.Subscription) {
+	defer event.message.Finish(nil)
+
+	key := subscriberKey(sub.Subscriber, sub.Reply)
+	if sub.DeadLetter == nil {
+		h.logger.Warn("Dropping an event, the subscriber has no room for it", zap.String("subscriber", key))
+		_ = h.reporter.ReportEventCount(event.args, nethttp.StatusServiceUnavailable)
+		return
+	}
+	info, err := h.dispatcher.sendToDeadLetter(ctx, event.message, event.additionalHeaders, key, sub.DeadLetter, sub.RetryConfig, errorCodeTransformers(overflowErrorCode)...)
+	if err != nil {
+		h.logger.Error("Failed to send an event the subscriber has no room for to its dead letter sink", zap.String("subscriber", key), zap.Error(err))
+	}
+	reportDispatch(h.reporter, event.args, info, err)
+}
+
+// partitionKey returns the key the event is ordered by: its partitionkey extension attribute, or
+// its subject if it has none.
+func partitionKey(ctx context.Context, message binding.Message) string {
+	reader := messageMetadata(ctx, message)
+	if reader == nil {
+		return ""
+	}
+	if v := reader.GetExtension(partitionKeyExtension); v != nil {
+		if key, err := cetypes.ToString(v); err == nil && key != "" {
+			return key
+		}
+	}
+	if _, v := reader.GetAttribute(spec.Subject); v != nil {
+		key, _ := cetypes.ToString(v)
+		return key
+	}
+	return ""
+}
+
+func (h *flowControlMessageHandler) deliver(sub fanout.Subscription, event queuedEvent) {
+	info, err := h.dispatcher.DispatchMessageWithRetries(event.ctx, event.message, event.additionalHeaders, sub.Subscriber, sub.Reply, sub.DeadLetter, sub.RetryConfig)
+	if err != nil {
+		h.logger.Error("Failed to deliver an event", zap.String("subscriber", subscriberKey(sub.Subscriber, sub.Reply)), zap.Error(err))
+	}
+	reportDispatch(h.reporter, event.args, info, err)
+}
+
+func (h *flowControlMessageHandler) GetSubscriptions(_ context.Context) []fanout.Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs := make([]fanout.Subscription, len(h.subscriptions))
+	copy(subs, h.subscriptions)
+	return subs
+}
+
+// SetSubscriptions creates a queue for every new subscriber, and drops the queues of removed
+// subscribers, with the events waiting in them.
+func (h *flowControlMessageHandler) SetSubscriptions(_ context.Context, subs []fanout.Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	queues := make(map[string]*subscriberQueue, len(subs))
+	for _, sub := range subs {
+		key := subscriberKey(sub.Subscriber, sub.Reply)
+		q, ok := h.queues[key]
+		if !ok {
+			q = newSubscriberQueue(h.deliver)
+		}
+		q.setSubscription(sub)
+		q.setLimits(h.config.limitsFor(key))
+		queues[key] = q
+	}
+	for key, q := range h.queues {
+		if _, ok := queues[key]; !ok {
+			if dropped := q.close(); dropped > 0 {
+				h.logger.Warn("Dropping the queued events of a removed subscriber", zap.String("subscriber", key), zap.Int("dropped", dropped))
+			}
+		}
+	}
+	h.queues = queues
+	h.subscriptions = make([]fanout.Subscription, len(subs))
+	copy(h.subscriptions, subs)
+}
+
+// setConfig applies changed flow control limits to the queues.
+func (h *flowControlMessageHandler) setConfig(config *flowControlConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.config = config
+	for key, q := range h.queues {
+		q.setLimits(config.limitsFor(key))
+	}
+}
+
+func (h *flowControlMessageHandler) ordered() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.config.limits.ordered
+}
+
+// queued returns the number of events waiting for a subscriber.
+func (h *flowControlMessageHandler) queued() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var queued int64
+	for _, q := range h.queues {
+		queued += int64(q.length())
+	}
+	return queued
+}
+
+// subscriberQueue holds the events waiting for a single subscriber. Up to maxInFlight goroutines
+// deliver them, and exit when no queued event can be delivered. When the queue is ordered, an
+// event waits for the earlier events with the same partition key to be delivered.
+type subscriberQueue struct {
+	deliver func(fanout.Subscription, queuedEvent)
+
+	mu           sync.Mutex
+	subscription fanout.Subscription
+	limits       flowLimits
+	inFlight     int
+	queue        []queuedEvent
+	closed       bool
+
+	// pending counts the events of every partition key in flight or queued, delivering holds the
+	// partition keys in flight.
+	pending    map[string]int
+	delivering sets.String
+}
+
+func newSubscriberQueue(deliver func(fanout.Subscription, queuedEvent)) *subscriberQueue {
+	return &subscriberQueue{
+		deliver:    deliver,
+		pending:    make(map[string]int),
+		delivering: sets.NewString(),
+	}
+}
+
+// hasRoom returns whether the event would be delivered right away or queued.
+func (q *subscriberQueue) hasRoom(event queuedEvent) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.canStart(event) || len(q.queue) < q.limits.queueLength
+}
+
+// canStart returns whether the event can be delivered right away. It is called with mu held.
+func (q *subscriberQueue) canStart(event queuedEvent) bool {
+	if q.inFlight >= q.limits.maxInFlight {
+		return false
+	}
+	return !q.limits.ordered || event.partitionKey == "" || q.pending[event.partitionKey] == 0
+}
+
+// offer delivers the event right away or queues it, and returns false if there is no room for it.
+func (q *subscriberQueue) offer(event queuedEvent) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	if q.canStart(event) {
+		q.pending[event.partitionKey]++
+		q.start(event)
+		return true
+	}
+	if len(q.queue) < q.limits.queueLength {
+		q.pending[event.partitionKey]++
+		q.queue = append(q.queue, event)
+		return true
+	}
+	return false
+}
+
+// start starts a goroutine delivering the event. It is called with mu held.
+func (q *subscriberQueue) start(event queuedEvent) {
+	q.inFlight++
+	q.delivering.Insert(event.partitionKey)
+	go q.run(event)
+}
+
+// run delivers the event, then the queued ones, until none of them can be delivered or there are
+// more goroutines than maxInFlight.
+func (q *subscriberQueue) run(event queuedEvent) {
+	for {
+		q.deliver(q.getSubscription(), event)
+
+		q.mu.Lock()
+		q.done(event)
+		// The limit may have been lowered, check it before taking an event off the queue.
+		if q.inFlight > q.limits.maxInFlight {
+			q.inFlight--
+			q.mu.Unlock()
+			return
+		}
+		next, ok := q.next()
+		if !ok {
+			q.inFlight--
+			q.mu.Unlock()
+			return
+		}
+		q.delivering.Insert(next.partitionKey)
+		event = next
+		q.mu.Unlock()
+	}
+}
+
+// done records that the event was delivered. It is called with mu held.
+func (q *subscriberQueue) done(event queuedEvent) {
+	q.delivering.Delete(event.partitionKey)
+	if q.pending[event.partitionKey]--; q.pending[event.partitionKey] <= 0 {
+		delete(q.pending, event.partitionKey)
+	}
+}
+
+// next takes the first queued event that can be delivered off the queue: the first one, unless the
+// queue is ordered and its partition key is in flight. It is called with mu held.
+func (q *subscriberQueue) next() (queuedEvent, bool) {
+	for i, event := range q.queue {
+		if q.limits.ordered && event.partitionKey != "" && q.delivering.Has(event.partitionKey) {
+			continue
+		}
+		copy(q.queue[i:], q.queue[i+1:])
+		q.queue[len(q.queue)-1] = queuedEvent{}
+		q.queue = q.queue[:len(q.queue)-1]
+		return event, true
+	}
+	return queuedEvent{}, false
+}
+
+func (q *subscriberQueue) getSubscription() fanout.Subscription {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.subscription
+}
+
+// setSubscription changes the subscription the events are delivered to, from the next delivery on.
+func (q *subscriberQueue) setSubscription(sub fanout.Subscription) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.subscription = sub
+}
+
+// setLimits changes the limits of the queue. Events already queued are kept even if the queue
+// is shorter now.
+func (q *subscriberQueue) setLimits(limits flowLimits) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limits = limits
+	for q.inFlight < q.limits.maxInFlight {
+		event, ok := q.next()
+		if !ok {
+			return
+		}
+		q.start(event)
+	}
+}
+
+func (q *subscriberQueue) length() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queue)
+}
+
+// close drops the queued events and rejects new ones. It returns the number of dropped events.
+func (q *subscriberQueue) close() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	dropped := len(q.queue)
+	for _, event := range q.queue {
+		q.done(event)
+		_ = event.message.Finish(nil)
+	}
+	q.queue = nil
+	return dropped
+}
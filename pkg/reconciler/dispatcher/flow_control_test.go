@@ -0,0 +1,511 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/channel/fanout"
+	"knative.dev/eventing/pkg/kncloudevents"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/ptr"
+)
+
+// blockingDispatcher is a channel.MessageDispatcher whose deliveries to the blocked destination
+// wait for a value on release, so the tests control how many of them are in flight. The
+// deliveries to other destinations complete right away.
+type blockingDispatcher struct {
+	blocked string
+	release chan struct{}
+
+	mu       sync.Mutex
+	inFlight int
+	// maxInFlight is the highest number of deliveries to the blocked destination in flight at once.
+	maxInFlight int
+	// delivered holds the IDs of the events delivered, by destination.
+	delivered map[string][]string
+}
+
+var _ channel.MessageDispatcher = (*blockingDispatcher)(nil)
+
+func newBlockingDispatcher(blocked string) *blockingDispatcher {
+	return &blockingDispatcher{
+		blocked:   blocked,
+		release:   make(chan struct{}),
+		delivered: make(map[string][]string),
+	}
+}
+
+func (b *blockingDispatcher) DispatchMessage(ctx context.Context, message cloudevents.Message, additionalHeaders nethttp.Header, destination *url.URL, reply *url.URL, deadLetter *url.URL) (*channel.DispatchExecutionInfo, error) {
+	return b.DispatchMessageWithRetries(ctx, message, additionalHeaders, destination, reply, deadLetter, nil)
+}
+
+func (b *blockingDispatcher) DispatchMessageWithRetries(ctx context.Context, message cloudevents.Message, _ nethttp.Header, destination *url.URL, _ *url.URL, _ *url.URL, _ *kncloudevents.RetryConfig) (*channel.DispatchExecutionInfo, error) {
+	defer message.Finish(nil)
+	event, err := binding.ToEvent(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	if destination.String() == b.blocked {
+		b.mu.Lock()
+		b.inFlight++
+		if b.inFlight > b.maxInFlight {
+			b.maxInFlight = b.inFlight
+		}
+		b.mu.Unlock()
+
+		<-b.release
+
+		b.mu.Lock()
+		b.inFlight--
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.delivered[destination.String()] = append(b.delivered[destination.String()], event.ID())
+	return &channel.DispatchExecutionInfo{ResponseCode: nethttp.StatusAccepted}, nil
+}
+
+// state returns the number of deliveries in flight to the blocked destination, and the highest
+// number seen so far.
+func (b *blockingDispatcher) state() (inFlight, maxInFlight int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inFlight, b.maxInFlight
+}
+
+// resetMaxInFlight only counts the deliveries started from now on in the highest number of
+// deliveries in flight.
+func (b *blockingDispatcher) resetMaxInFlight() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maxInFlight = 0
+}
+
+func (b *blockingDispatcher) deliveredTo(destination string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.delivered[destination]...)
+}
+
+// releaseAll lets the given number of deliveries to the blocked destination complete.
+func (b *blockingDispatcher) releaseAll(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case b.release <- struct{}{}:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out releasing delivery %d of %d", i+1, n)
+		}
+	}
+}
+
+const (
+	testBlockedSubscriber = "http://blocked.ns.svc.cluster.local/"
+	testFastSubscriber    = "http://fast.ns.svc.cluster.local/"
+	testDeadLetter        = "http://dls.ns.svc.cluster.local/"
+)
+
+func newTestFlowControlHandler(t *testing.T, dispatcher channel.MessageDispatcher, limits flowLimits, overflow v1beta1.OverflowPolicy, subs ...fanout.Subscription) *flowControlMessageHandler {
+	t.Helper()
+	h := newFlowControlMessageHandler(zap.NewNop(), newTestDispatcher(dispatcher, newHealthTracker(nil)), testStatsReporter(), "ns", &flowControlConfig{
+		limits:   limits,
+		overflow: overflow,
+	})
+	h.SetSubscriptions(context.Background(), subs)
+	return h
+}
+
+func TestNewFlowControlConfig(t *testing.T) {
+	subscriber := eventingduckv1.SubscriberSpec{UID: "uid", SubscriberURI: apis.HTTP("subscriber.ns.svc.cluster.local")}
+
+	tests := map[string]struct {
+		fc       *v1beta1.WebSocketChannelFlowControl
+		ordering v1beta1.DeliveryOrdering
+		want     *flowControlConfig
+	}{
+		"no flow control": {},
+		"ordered": {
+			ordering: v1beta1.OrderingPartitionKey,
+			want: &flowControlConfig{
+				limits:      flowLimits{maxInFlight: 100, queueLength: 1000, ordered: true},
+				overflow:    v1beta1.OverflowReject,
+				subscribers: map[string]flowLimits{},
+			},
+		},
+		"defaults": {
+			fc: &v1beta1.WebSocketChannelFlowControl{},
+			want: &flowControlConfig{
+				limits:      flowLimits{maxInFlight: 100, queueLength: 1000},
+				overflow:    v1beta1.OverflowReject,
+				subscribers: map[string]flowLimits{},
+			},
+		},
+		"subscriber overrides": {
+			fc: &v1beta1.WebSocketChannelFlowControl{
+				MaxInFlight: ptr.Int32(10),
+				QueueLength: ptr.Int32(20),
+				Overflow:    v1beta1.OverflowDeadLetter,
+				Subscribers: []v1beta1.SubscriberFlowControl{
+					{UID: "uid", MaxInFlight: ptr.Int32(1)},
+					{UID: "removed", QueueLength: ptr.Int32(1)},
+				},
+			},
+			want: &flowControlConfig{
+				limits:   flowLimits{maxInFlight: 10, queueLength: 20},
+				overflow: v1beta1.OverflowDeadLetter,
+				subscribers: map[string]flowLimits{
+					specSubscriberKey(subscriber): {maxInFlight: 1, queueLength: 20},
+				},
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			wsc := &v1beta1.WebSocketChannel{}
+			wsc.Spec.FlowControl = test.fc
+			wsc.Spec.Ordering = test.ordering
+			wsc.Spec.Subscribers = []eventingduckv1.SubscriberSpec{subscriber}
+
+			got := newFlowControlConfig(wsc)
+			if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(flowControlConfig{}, flowLimits{})); diff != "" {
+				t.Error("newFlowControlConfig (-want, +got) =", diff)
+			}
+		})
+	}
+}
+
+func TestFlowControlBoundsInFlightDeliveries(t *testing.T) {
+	blocking := newBlockingDispatcher(testBlockedSubscriber)
+	h := newTestFlowControlHandler(t, blocking, flowLimits{maxInFlight: 2, queueLength: 10}, v1beta1.OverflowReject,
+		fanout.Subscription{Subscriber: mustParseURL(t, testBlockedSubscriber)})
+
+	ids := []string{"1", "2", "3", "4", "5"}
+	for _, id := range ids {
+		if code := postEvent(h, id); code != nethttp.StatusAccepted {
+			t.Fatalf("Status = %d, want %d", code, nethttp.StatusAccepted)
+		}
+	}
+	waitFor(t, "the deliveries to start", func() bool {
+		inFlight, _ := blocking.state()
+		return inFlight == 2
+	})
+	if got := h.queued(); got != 3 {
+		t.Errorf("queued() = %d, want 3", got)
+	}
+
+	blocking.releaseAll(t, len(ids))
+	waitFor(t, "the events to be delivered", func() bool { return len(blocking.deliveredTo(testBlockedSubscriber)) == len(ids) })
+	if _, max := blocking.state(); max != 2 {
+		t.Errorf("Delivered %d events at once, want 2", max)
+	}
+	if got := h.queued(); got != 0 {
+		t.Errorf("queued() = %d after the deliveries, want 0", got)
+	}
+}
+
+func TestFlowControlOverflow(t *testing.T) {
+	tests := map[string]struct {
+		overflow       v1beta1.OverflowPolicy
+		deadLetter     string
+		wantStatus     int
+		wantBlocked    []string
+		wantFast       []string
+		wantDeadLetter []string
+	}{
+		"reject": {
+			overflow:   v1beta1.OverflowReject,
+			deadLetter: testDeadLetter,
+			wantStatus: nethttp.StatusServiceUnavailable,
+			// A rejected event is delivered to no subscriber, not even to those with room for it.
+			wantFast:    []string{"1", "2"},
+			wantBlocked: []string{"1", "2"},
+		},
+		"dead letter": {
+			overflow:       v1beta1.OverflowDeadLetter,
+			deadLetter:     testDeadLetter,
+			wantStatus:     nethttp.StatusAccepted,
+			wantFast:       []string{"1", "2", "3"},
+			wantDeadLetter: []string{"3"},
+			wantBlocked:    []string{"1", "2"},
+		},
+		"dead letter without a dead letter sink": {
+			overflow:    v1beta1.OverflowDeadLetter,
+			wantStatus:  nethttp.StatusAccepted,
+			wantFast:    []string{"1", "2", "3"},
+			wantBlocked: []string{"1", "2"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			blocked := fanout.Subscription{Subscriber: mustParseURL(t, testBlockedSubscriber)}
+			if test.deadLetter != "" {
+				blocked.DeadLetter = mustParseURL(t, test.deadLetter)
+			}
+			blocking := newBlockingDispatcher(testBlockedSubscriber)
+			h := newTestFlowControlHandler(t, blocking, flowLimits{maxInFlight: 1, queueLength: 1}, test.overflow,
+				blocked, fanout.Subscription{Subscriber: mustParseURL(t, testFastSubscriber)})
+
+			// The first event is in flight to the blocked subscriber, the second one is queued.
+			for _, id := range []string{"1", "2"} {
+				if code := postEvent(h, id); code != nethttp.StatusAccepted {
+					t.Fatalf("Status = %d, want %d", code, nethttp.StatusAccepted)
+				}
+			}
+			waitFor(t, "the delivery to start", func() bool {
+				inFlight, _ := blocking.state()
+				return inFlight == 1
+			})
+			if code := postEvent(h, "3"); code != test.wantStatus {
+				t.Errorf("Status = %d for the event beyond the limits, want %d", code, test.wantStatus)
+			}
+
+			blocking.releaseAll(t, 2)
+			waitFor(t, "the events to be delivered", func() bool {
+				return len(blocking.deliveredTo(testBlockedSubscriber)) == len(test.wantBlocked) &&
+					len(blocking.deliveredTo(testFastSubscriber)) == len(test.wantFast)
+			})
+			if diff := cmp.Diff(test.wantBlocked, blocking.deliveredTo(testBlockedSubscriber)); diff != "" {
+				t.Error("Delivered to the blocked subscriber (-want, +got) =", diff)
+			}
+			if diff := cmp.Diff(test.wantFast, blocking.deliveredTo(testFastSubscriber)); diff != "" {
+				t.Error("Delivered to the fast subscriber (-want, +got) =", diff)
+			}
+			if diff := cmp.Diff(test.wantDeadLetter, blocking.deliveredTo(testDeadLetter)); diff != "" {
+				t.Error("Delivered to the dead letter sink (-want, +got) =", diff)
+			}
+		})
+	}
+}
+
+func TestFlowControlLoweredLimits(t *testing.T) {
+	blocking := newBlockingDispatcher(testBlockedSubscriber)
+	h := newTestFlowControlHandler(t, blocking, flowLimits{maxInFlight: 3, queueLength: 10}, v1beta1.OverflowReject,
+		fanout.Subscription{Subscriber: mustParseURL(t, testBlockedSubscriber)})
+
+	ids := []string{"1", "2", "3", "4", "5", "6"}
+	for _, id := range ids {
+		if code := postEvent(h, id); code != nethttp.StatusAccepted {
+			t.Fatalf("Status = %d, want %d", code, nethttp.StatusAccepted)
+		}
+	}
+	waitFor(t, "the deliveries to start", func() bool {
+		inFlight, _ := blocking.state()
+		return inFlight == 3
+	})
+
+	// The deliveries in flight complete, but no further one starts until a single one is left.
+	h.setConfig(&flowControlConfig{limits: flowLimits{maxInFlight: 1, queueLength: 10}, overflow: v1beta1.OverflowReject})
+	blocking.resetMaxInFlight()
+	for delivered := 1; delivered <= len(ids); delivered++ {
+		blocking.releaseAll(t, 1)
+		waitFor(t, "the event to be delivered", func() bool { return len(blocking.deliveredTo(testBlockedSubscriber)) == delivered })
+	}
+	if _, max := blocking.state(); max != 1 {
+		t.Errorf("Delivered %d events at once after lowering the limit, want 1", max)
+	}
+	// No event is lost when deliveries stop because of the lowered limit.
+	if got := h.queued(); got != 0 {
+		t.Errorf("queued() = %d after the deliveries, want 0", got)
+	}
+}
+
+func postOrderedEvent(h nethttp.Handler, id, key string) int {
+	request := httptest.NewRequest(nethttp.MethodPost, "/", strings.NewReader(`{"hello":"world"}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Ce-Specversion", "1.0")
+	request.Header.Set("Ce-Id", id)
+	request.Header.Set("Ce-Type", "test.type")
+	request.Header.Set("Ce-Source", "test-source")
+	request.Header.Set("Ce-Partitionkey", key)
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	return response.Code
+}
+
+// withPrefix returns the IDs starting with prefix, the IDs of the events of a partition key in
+// these tests.
+func withPrefix(ids []string, prefix string) []string {
+	var filtered []string
+	for _, id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered
+}
+
+func TestOrderingDeliversPartitionKeysInParallel(t *testing.T) {
+	blocking := newBlockingDispatcher(testBlockedSubscriber)
+	h := newTestFlowControlHandler(t, blocking, flowLimits{maxInFlight: 10, queueLength: 10, ordered: true}, v1beta1.OverflowReject,
+		fanout.Subscription{Subscriber: mustParseURL(t, testBlockedSubscriber)})
+
+	for _, event := range []struct{ id, key string }{{"a1", "a"}, {"b1", "b"}, {"a2", "a"}, {"n1", ""}, {"n2", ""}} {
+		if code := postOrderedEvent(h, event.id, event.key); code != nethttp.StatusAccepted {
+			t.Fatalf("Status = %d, want %d", code, nethttp.StatusAccepted)
+		}
+	}
+	// a1, b1 and the events without a partition key are delivered at once, a2 waits for a1.
+	waitFor(t, "the deliveries to start", func() bool {
+		inFlight, _ := blocking.state()
+		return inFlight == 4
+	})
+	if got := h.queued(); got != 1 {
+		t.Errorf("queued() = %d, want 1", got)
+	}
+
+	blocking.releaseAll(t, 5)
+	waitFor(t, "the events to be delivered", func() bool { return len(blocking.deliveredTo(testBlockedSubscriber)) == 5 })
+	if diff := cmp.Diff([]string{"a1", "a2"}, withPrefix(blocking.deliveredTo(testBlockedSubscriber), "a")); diff != "" {
+		t.Error("Delivered the events of a partition key (-want, +got) =", diff)
+	}
+	if _, max := blocking.state(); max != 4 {
+		t.Errorf("Delivered %d events at once, want 4", max)
+	}
+}
+
+// retryingSubscriber is a subscriber failing the first attempt to deliver every event. It records
+// the events it accepted, and the highest number of deliveries of a partition key it saw at once.
+type retryingSubscriber struct {
+	mu        sync.Mutex
+	attempted map[string]bool
+	inFlight  map[string]int
+	maxPerKey int
+	maxTotal  int
+	total     int
+	accepted  []string
+}
+
+func (s *retryingSubscriber) ServeHTTP(response nethttp.ResponseWriter, request *nethttp.Request) {
+	id, key := request.Header.Get("Ce-Id"), request.Header.Get("Ce-Partitionkey")
+
+	s.mu.Lock()
+	s.inFlight[key]++
+	s.total++
+	if s.inFlight[key] > s.maxPerKey {
+		s.maxPerKey = s.inFlight[key]
+	}
+	if s.total > s.maxTotal {
+		s.maxTotal = s.total
+	}
+	retried := s.attempted[id]
+	s.attempted[id] = true
+	s.mu.Unlock()
+
+	// Gives the deliveries of other events a chance to overlap with this one.
+	time.Sleep(5 * time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight[key]--
+	s.total--
+	if !retried {
+		response.WriteHeader(nethttp.StatusInternalServerError)
+		return
+	}
+	s.accepted = append(s.accepted, id)
+	response.WriteHeader(nethttp.StatusAccepted)
+}
+
+func (s *retryingSubscriber) state() (accepted []string, maxPerKey, maxTotal int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.accepted...), s.maxPerKey, s.maxTotal
+}
+
+func TestOrderingAcrossRetries(t *testing.T) {
+	subscriber := &retryingSubscriber{attempted: make(map[string]bool), inFlight: make(map[string]int)}
+	server := httptest.NewServer(subscriber)
+	defer server.Close()
+
+	retryConfig := &kncloudevents.RetryConfig{
+		RetryMax:   3,
+		CheckRetry: kncloudevents.RetryIfGreaterThan300,
+		Backoff: func(int, *nethttp.Response) time.Duration {
+			return time.Millisecond
+		},
+	}
+	h := newTestFlowControlHandler(t, channel.NewMessageDispatcher(zap.NewNop()), flowLimits{maxInFlight: 10, queueLength: 100, ordered: true}, v1beta1.OverflowReject,
+		fanout.Subscription{Subscriber: mustParseURL(t, server.URL), RetryConfig: retryConfig})
+
+	var ids []string
+	for i := 1; i <= 5; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			id := fmt.Sprintf("%s%d", key, i)
+			ids = append(ids, id)
+			if code := postOrderedEvent(h, id, key); code != nethttp.StatusAccepted {
+				t.Fatalf("Status = %d, want %d", code, nethttp.StatusAccepted)
+			}
+		}
+	}
+	waitFor(t, "the events to be delivered", func() bool {
+		accepted, _, _ := subscriber.state()
+		return len(accepted) == len(ids)
+	})
+
+	accepted, maxPerKey, maxTotal := subscriber.state()
+	for _, key := range []string{"a", "b", "c"} {
+		if diff := cmp.Diff(withPrefix(ids, key), withPrefix(accepted, key)); diff != "" {
+			t.Errorf("Accepted the events of %s (-want, +got) = %s", key, diff)
+		}
+	}
+	if maxPerKey != 1 {
+		t.Errorf("Delivered %d events of a partition key at once, want 1", maxPerKey)
+	}
+	if maxTotal < 2 {
+		t.Errorf("Delivered %d events at once, want the partition keys in parallel", maxTotal)
+	}
+}
+
+func TestOrderingWithChangedLimits(t *testing.T) {
+	blocking := newBlockingDispatcher(testBlockedSubscriber)
+	h := newTestFlowControlHandler(t, blocking, flowLimits{maxInFlight: 1, queueLength: 10, ordered: true}, v1beta1.OverflowReject,
+		fanout.Subscription{Subscriber: mustParseURL(t, testBlockedSubscriber)})
+
+	ids := []string{"a1", "a2", "b1", "a3", "b2"}
+	for _, id := range ids {
+		if code := postOrderedEvent(h, id, id[:1]); code != nethttp.StatusAccepted {
+			t.Fatalf("Status = %d, want %d", code, nethttp.StatusAccepted)
+		}
+	}
+	waitFor(t, "the delivery to start", func() bool {
+		inFlight, _ := blocking.state()
+		return inFlight == 1
+	})
+
+	// Raising the limit starts b1 right away, but not a2 while a1 is in flight.
+	h.setConfig(&flowControlConfig{limits: flowLimits{maxInFlight: 10, queueLength: 10, ordered: true}, overflow: v1beta1.OverflowReject})
+	waitFor(t, "the deliveries to start", func() bool {
+		inFlight, _ := blocking.state()
+		return inFlight == 2
+	})
+	time.Sleep(10 * time.Millisecond)
+	if inFlight, _ := blocking.state(); inFlight != 2 {
+		t.Errorf("%d deliveries in flight, want one per partition key", inFlight)
+	}
+
+	// Lowering it again doesn't break the order either.
+	h.setConfig(&flowControlConfig{limits: flowLimits{maxInFlight: 1, queueLength: 10, ordered: true}, overflow: v1beta1.OverflowReject})
+	blocking.releaseAll(t, len(ids))
+	waitFor(t, "the events to be delivered", func() bool { return len(blocking.deliveredTo(testBlockedSubscriber)) == len(ids) })
+	for _, key := range []string{"a", "b"} {
+		if diff := cmp.Diff(withPrefix(ids, key), withPrefix(blocking.deliveredTo(testBlockedSubscriber), key)); diff != "" {
+			t.Errorf("Delivered the events of %s (-want, +got) = %s", key, diff)
+		}
+	}
+}
@@ -2,17 +2,27 @@ package dispatcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
 
-	"github.com/aliok/websocket-channel/pkg/apis/channels/v1alpha1"
-	channelsv1 "github.com/aliok/websocket-channel/pkg/client/clientset/versioned/typed/channels/v1alpha1"
-	reconcilerv1 "github.com/aliok/websocket-channel/pkg/client/injection/reconciler/channels/v1alpha1/websocketchannel"
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	"github.com/aliok/websocket-channel/pkg/apis/config"
+	"github.com/aliok/websocket-channel/pkg/channel/status"
+	"github.com/aliok/websocket-channel/pkg/channel/wal"
+	channelsv1 "github.com/aliok/websocket-channel/pkg/client/clientset/versioned/typed/channels/v1beta1"
+	reconcilerv1 "github.com/aliok/websocket-channel/pkg/client/injection/reconciler/channels/v1beta1/websocketchannel"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
 	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
 	"knative.dev/eventing/pkg/channel"
 	"knative.dev/eventing/pkg/channel/fanout"
@@ -27,17 +37,42 @@ import (
 // Reconciler reconciles WebSocket Channels.
 type Reconciler struct {
 	multiChannelMessageHandler multichannelfanout.MultiChannelMessageHandler
-	reporter                   channel.StatsReporter
-	clientSet                  channelsv1.ChannelsV1alpha1Interface
+	reporter                   *statsReporter
+	clientSet                  channelsv1.ChannelsV1beta1Interface
+	statusHandler              *status.Handler
+
+	// healthTrackers holds the subscriber health tracker of every channel, keyed by channel host name.
+	healthTrackersLock sync.RWMutex
+	healthTrackers     map[string]*healthTracker
+
+	// externalHosts holds the host name every exposed channel is reachable at from outside the
+	// cluster, keyed by channel host name.
+	externalHostsLock sync.Mutex
+	externalHosts     map[string]string
+
+	// walDir is the directory the write-ahead logs of durable channels are kept in. Durable
+	// channels can't be loaded without it.
+	walDir string
+
+	// statsCollector collects the delivery health of the subscribers from the other dispatcher replicas.
+	statsCollector *statsCollector
+
+	// subscriptionLister lists the Subscriptions, whose annotations opt their subscriber in to batching.
+	subscriptionLister cache.GenericLister
+
+	enqueueKey   func(types.NamespacedName)
+	enqueueAfter func(interface{}, time.Duration)
 }
 
 // Check the interfaces Reconciler should implement
 var (
 	_ reconcilerv1.Interface         = (*Reconciler)(nil)
 	_ reconcilerv1.ReadOnlyInterface = (*Reconciler)(nil)
+	_ reconcilerv1.Finalizer         = (*Reconciler)(nil)
+	_ reconcilerv1.ReadOnlyFinalizer = (*Reconciler)(nil)
 )
 
-func (r *Reconciler) ReconcileKind(ctx context.Context, wsc *v1alpha1.WebSocketChannel) reconciler.Event {
+func (r *Reconciler) ReconcileKind(ctx context.Context, wsc *v1beta1.WebSocketChannel) reconciler.Event {
 	if err := r.reconcile(ctx, wsc); err != nil {
 		return err
 	}
@@ -46,41 +81,105 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, wsc *v1alpha1.WebSocketC
 	return r.patchSubscriberStatus(ctx, wsc)
 }
 
-func (r *Reconciler) ObserveKind(ctx context.Context, wsc *v1alpha1.WebSocketChannel) reconciler.Event {
+func (r *Reconciler) ObserveKind(ctx context.Context, wsc *v1beta1.WebSocketChannel) reconciler.Event {
 	return r.reconcile(ctx, wsc)
 }
 
-func (r *Reconciler) reconcile(ctx context.Context, wsc *v1alpha1.WebSocketChannel) reconciler.Event {
-	logging.FromContext(ctx).Infow("Reconciling", zap.Any("WebSocketChannel", wsc))
+// FinalizeKind drains the channel before its finalizer is removed. The finalizer is kept while
+// events are in flight, and the channel is checked again.
+func (r *Reconciler) FinalizeKind(ctx context.Context, wsc *v1beta1.WebSocketChannel) reconciler.Event {
+	if inFlight := r.finalize(ctx, wsc); inFlight > 0 {
+		return reconciler.NewEvent(corev1.EventTypeWarning, "ChannelDraining", "Waiting for %d events in flight to be delivered", inFlight)
+	}
+	return nil
+}
+
+// ObserveFinalizeKind drains the channel on replicas that are not the leader. There is no
+// guarantee it is called before the leader removes the finalizer.
+func (r *Reconciler) ObserveFinalizeKind(ctx context.Context, wsc *v1beta1.WebSocketChannel) reconciler.Event {
+	r.finalize(ctx, wsc)
+	return nil
+}
 
-	if !wsc.Status.IsReady() {
-		logging.FromContext(ctx).Debug("WSC is not ready, skipping")
+// finalize stops accepting events for the channel, and removes the handler of the channel once
+// the events in flight are delivered or drainTimeout passed. Until then, it enqueues the channel
+// again and returns the number of events in flight.
+func (r *Reconciler) finalize(ctx context.Context, wsc *v1beta1.WebSocketChannel) int64 {
+	if wsc.Status.Address == nil || wsc.Status.Address.URL == nil {
+		return 0
+	}
+	hostName := wsc.Status.Address.URL.Host
+
+	if handler, ok := r.multiChannelMessageHandler.GetChannelHandler(hostName).(*channelHandler); ok {
+		started := handler.startDraining()
+		if inFlight := handler.inFlight(); inFlight > 0 {
+			if time.Since(started) < drainTimeout && r.enqueueAfter != nil {
+				logging.FromContext(ctx).Infow("Draining channel", zap.String("hostName", hostName), zap.Int64("inFlight", inFlight))
+				r.enqueueAfter(wsc, drainRequeueInterval)
+				return inFlight
+			}
+			logging.FromContext(ctx).Warnw("Events still in flight after draining channel, dropping them",
+				zap.String("hostName", hostName), zap.Int64("inFlight", inFlight))
+		}
+	}
+
+	r.deleteChannel(hostName)
+	return 0
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, wsc *v1beta1.WebSocketChannel) reconciler.Event {
+	logging.FromContext(ctx).Debugw("Reconciling", zap.Any("WebSocketChannel", wsc))
+
+	// Readiness of the channel depends on the dispatchers loading it, so only wait for its address.
+	if wsc.Status.Address == nil || wsc.Status.Address.URL == nil {
+		logging.FromContext(ctx).Debug("WSC is not addressable yet, skipping")
 		return nil
 	}
 
-	config, err := newConfigForWebSocketChannel(wsc)
+	config, err := newConfigForWebSocketChannel(ctx, wsc)
 	if err != nil {
 		logging.FromContext(ctx).Error("Error creating config for web socket channels", zap.Error(err))
 		return err
 	}
 
+	tracker := r.getOrCreateHealthTracker(wsc)
+	tracker.retain(subscriberKeys(config.FanoutConfig.Subscriptions))
+
+	expiry, err := newExpiryConfig(wsc.Spec.Expiry)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error creating the expiry config for web socket channels", zap.Error(err))
+		return err
+	}
+
+	flowControl := newFlowControlConfig(wsc)
+	batching := newBatchConfigs(ctx, wsc, r.subscriptionLister)
+
 	// First grab the MultiChannelFanoutMessage handler
-	handler := r.multiChannelMessageHandler.GetChannelHandler(config.HostName)
-	if handler == nil {
-		// No handler yet, create one.
-		fanoutHandler, err := fanout.NewFanoutMessageHandler(
-			logging.FromContext(ctx).Desugar(),
-			channel.NewMessageDispatcher(logging.FromContext(ctx).Desugar()),
-			config.FanoutConfig,
-			r.reporter,
-		)
+	handler, _ := r.multiChannelMessageHandler.GetChannelHandler(config.HostName).(*channelHandler)
+	if handler == nil || handler.flowControlled() != (flowControl != nil && !wsc.Spec.Durable) {
+		// No handler yet, or flow control was turned on or off, create one. A replaced handler
+		// delivers the events it accepted already, through the same dispatcher.
+		var dispatcher *healthTrackingDispatcher
+		if handler != nil {
+			dispatcher = handler.dispatcher
+		} else {
+			dispatcher = newHealthTrackingDispatcher(channel.NewMessageDispatcher(logging.FromContext(ctx).Desugar()), tracker, r.reporter.forChannel(wsc.Name), wsc.Namespace)
+		}
+		dispatcher.setExpiry(expiry)
+		dispatcher.setBatching(batching)
+		fanoutHandler, err := r.newFanoutHandler(ctx, wsc, config, dispatcher, flowControl)
 		if err != nil {
-			logging.FromContext(ctx).Error("Failed to create a new fanout.MessageHandler", err)
+			logging.FromContext(ctx).Error("Failed to create a new fanout.MessageHandler", zap.Error(err))
 			return err
 		}
-		r.multiChannelMessageHandler.SetChannelHandler(config.HostName, fanoutHandler)
+		r.multiChannelMessageHandler.SetChannelHandler(config.HostName, newChannelHandler(logging.FromContext(ctx).Desugar(), fanoutHandler, dispatcher, types.NamespacedName{Namespace: wsc.Namespace, Name: wsc.Name}))
 	} else {
 		// Just update the config if necessary.
+		handler.dispatcher.setExpiry(expiry)
+		handler.dispatcher.setBatching(batching)
+		if fc, ok := handler.MessageHandler.(*flowControlMessageHandler); ok {
+			fc.setConfig(flowControl)
+		}
 		haveSubs := handler.GetSubscriptions(ctx)
 
 		// Ignore the closures, we stash the values that we can tell from if the values have actually changed.
@@ -90,20 +189,116 @@ func (r *Reconciler) reconcile(ctx context.Context, wsc *v1alpha1.WebSocketChann
 		}
 	}
 
+	// Report the channel as loaded to the status prober of the controller.
+	r.statusHandler.SetLoaded(config.HostName, wsc.Generation)
+
+	// Route events sent to the external host of the channel to its handler too.
+	if err := r.reconcileExternalHost(wsc, config.HostName); err != nil {
+		logging.FromContext(ctx).Errorw("Failed to route the external host to the channel", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// newFanoutHandler creates the handler fanning the events of the channel out to its subscribers.
+// Durable channels go through a write-ahead log in the directory of the channel under walDir,
+// channels with flow control through a bounded queue per subscriber.
+func (r *Reconciler) newFanoutHandler(ctx context.Context, wsc *v1beta1.WebSocketChannel, config *multichannelfanout.ChannelConfig, dispatcher *healthTrackingDispatcher, flowControl *flowControlConfig) (fanout.MessageHandler, error) {
+	logger := logging.FromContext(ctx).Desugar()
+	reporter := r.reporter.forChannel(wsc.Name)
+	if !wsc.Spec.Durable {
+		if flowControl != nil {
+			handler := newFlowControlMessageHandler(logger, dispatcher, reporter, wsc.Namespace, flowControl)
+			handler.SetSubscriptions(ctx, config.FanoutConfig.Subscriptions)
+			return handler, nil
+		}
+		return fanout.NewFanoutMessageHandler(logger, dispatcher, config.FanoutConfig, reporter)
+	}
+
+	if r.walDir == "" {
+		return nil, errors.New("the channel is durable, but the dispatcher has no write-ahead log directory, see WAL_DIR")
+	}
+	log, err := wal.Open(r.walPath(wsc.Namespace, wsc.Name), wal.DefaultSegmentSize, wal.DefaultFlushInterval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the write-ahead log: %w", err)
+	}
+	handler := newDurableMessageHandler(logger, log, dispatcher, reporter, wsc.Namespace)
+	handler.SetSubscriptions(ctx, config.FanoutConfig.Subscriptions)
+	return handler, nil
+}
+
+// walPath returns the directory of the write-ahead log of a durable channel.
+func (r *Reconciler) walPath(namespace, name string) string {
+	return filepath.Join(r.walDir, namespace, name)
+}
+
+// reconcileExternalHost registers the handler of the channel under the host it is exposed at, as
+// the Ingress or HTTPRoute exposing it forwards the original Host header. A host already routed to
+// another channel is not taken over.
+func (r *Reconciler) reconcileExternalHost(wsc *v1beta1.WebSocketChannel, hostName string) error {
+	var externalHost string
+	if wsc.Spec.Exposure != nil {
+		externalHost = wsc.Spec.Exposure.Host
+	}
+
+	r.externalHostsLock.Lock()
+	defer r.externalHostsLock.Unlock()
+	previous, ok := r.externalHosts[hostName]
+	if ok && previous != externalHost {
+		r.multiChannelMessageHandler.DeleteChannelHandler(previous)
+		delete(r.externalHosts, hostName)
+	}
+	if externalHost == "" {
+		return nil
+	}
+	if previous != externalHost && r.multiChannelMessageHandler.GetChannelHandler(externalHost) != nil {
+		return fmt.Errorf("the external host %q is already routed to another channel", externalHost)
+	}
+	r.multiChannelMessageHandler.SetChannelHandler(externalHost, r.multiChannelMessageHandler.GetChannelHandler(hostName))
+	r.externalHosts[hostName] = externalHost
 	return nil
 }
 
-func (r *Reconciler) patchSubscriberStatus(ctx context.Context, wsc *v1alpha1.WebSocketChannel) error {
+func (r *Reconciler) deleteExternalHost(hostName string) {
+	r.externalHostsLock.Lock()
+	defer r.externalHostsLock.Unlock()
+	if externalHost, ok := r.externalHosts[hostName]; ok {
+		r.multiChannelMessageHandler.DeleteChannelHandler(externalHost)
+		delete(r.externalHosts, hostName)
+	}
+}
+
+func (r *Reconciler) patchSubscriberStatus(ctx context.Context, wsc *v1beta1.WebSocketChannel) error {
 	after := wsc.DeepCopy()
 
+	var health map[string]subscriberHealth
+	refreshed := false
+	if wsc.Status.Address != nil && wsc.Status.Address.URL != nil {
+		hostName := wsc.Status.Address.URL.Host
+		if tracker := r.getHealthTracker(hostName); tracker != nil {
+			var remote []map[string]subscriberHealth
+			var next time.Duration
+			version := tracker.version()
+			remote, refreshed, next = r.statsCollector.get(ctx, hostName, version)
+			health = mergeHealth(tracker.snapshot(), remote)
+			// Refresh the statistics of the subscribers, even if nothing else triggers a reconcile:
+			// every statsRefreshInterval while they change, and after statsMaxAge otherwise. A
+			// delivery of this replica refreshes statistics that stopped changing right away.
+			if len(wsc.Spec.Subscribers) > 0 && r.enqueueAfter != nil {
+				if next > statsRefreshInterval {
+					tracker.notifyOnChangeSince(version)
+				}
+				r.enqueueAfter(wsc, next)
+			}
+		}
+	}
+
 	after.Status.Subscribers = make([]eventingduckv1.SubscriberStatus, 0)
 	for _, sub := range wsc.Spec.Subscribers {
-		after.Status.Subscribers = append(after.Status.Subscribers, eventingduckv1.SubscriberStatus{
-			UID:                sub.UID,
-			ObservedGeneration: sub.Generation,
-			Ready:              corev1.ConditionTrue,
-		})
+		after.Status.Subscribers = append(after.Status.Subscribers, subscriberStatus(health, sub))
 	}
+	after.Status.SubscriberStats = subscriberStats(wsc, health, refreshed)
+
 	jsonPatch, err := duck.CreatePatch(wsc, after)
 	if err != nil {
 		return fmt.Errorf("creating JSON patch: %w", err)
@@ -126,7 +321,132 @@ func (r *Reconciler) patchSubscriberStatus(ctx context.Context, wsc *v1alpha1.We
 	return nil
 }
 
-func newConfigForWebSocketChannel(wsc *v1alpha1.WebSocketChannel) (*multichannelfanout.ChannelConfig, error) {
+// mergeHealth aggregates the health of every subscriber observed by this replica and the other ones.
+func mergeHealth(local map[string]subscriberHealth, remote []map[string]subscriberHealth) map[string]subscriberHealth {
+	observed := make(map[string][]subscriberHealth, len(local))
+	for key, h := range local {
+		observed[key] = append(observed[key], h)
+	}
+	for _, replica := range remote {
+		for key, h := range replica {
+			observed[key] = append(observed[key], h)
+		}
+	}
+	health := make(map[string]subscriberHealth, len(observed))
+	for key, hs := range observed {
+		health[key] = aggregateHealth(hs)
+	}
+	return health
+}
+
+// subscriberStatus reports the readiness of a subscriber based on the delivery health observed by
+// the dispatcher replicas. A nil health means the dispatcher has not configured the channel yet.
+func subscriberStatus(health map[string]subscriberHealth, sub eventingduckv1.SubscriberSpec) eventingduckv1.SubscriberStatus {
+	status := eventingduckv1.SubscriberStatus{
+		UID:                sub.UID,
+		ObservedGeneration: sub.Generation,
+	}
+	if health == nil {
+		status.Ready = corev1.ConditionUnknown
+		status.Message = "The dispatcher has not configured the channel yet"
+		return status
+	}
+
+	status.Ready, status.Message = health[specSubscriberKey(sub)].readiness()
+	return status
+}
+
+// subscriberStats reports the delivery statistics of every subscriber. Unless the health was
+// just collected from all replicas, the statistics already in the status are kept, so they
+// are patched at most once per statsRefreshInterval.
+func subscriberStats(wsc *v1beta1.WebSocketChannel, health map[string]subscriberHealth, refreshed bool) []v1beta1.SubscriberDeliveryStats {
+	if !refreshed {
+		previous := make(map[types.UID]v1beta1.SubscriberDeliveryStats, len(wsc.Status.SubscriberStats))
+		for _, stats := range wsc.Status.SubscriberStats {
+			previous[stats.UID] = stats
+		}
+		var kept []v1beta1.SubscriberDeliveryStats
+		for _, sub := range wsc.Spec.Subscribers {
+			if stats, ok := previous[sub.UID]; ok {
+				kept = append(kept, stats)
+			}
+		}
+		return kept
+	}
+
+	stats := make([]v1beta1.SubscriberDeliveryStats, 0, len(wsc.Spec.Subscribers))
+	for _, sub := range wsc.Spec.Subscribers {
+		h := health[specSubscriberKey(sub)]
+		s := v1beta1.SubscriberDeliveryStats{
+			UID:                 sub.UID,
+			LastFailureReason:   h.LastFailureReason,
+			ConsecutiveFailures: int32(h.ConsecutiveFailures),
+			DeadLettered:        h.DeadLettered,
+		}
+		if !h.LastSuccess.IsZero() {
+			s.LastSuccessTime = &metav1.Time{Time: h.LastSuccess}
+		}
+		if !h.LastFailure.IsZero() {
+			s.LastFailureTime = &metav1.Time{Time: h.LastFailure}
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// specSubscriberKey returns the key the health of a subscriber of the channel spec is tracked with.
+func specSubscriberKey(sub eventingduckv1.SubscriberSpec) string {
+	var subscriber, reply *url.URL
+	if sub.SubscriberURI != nil {
+		subscriber = sub.SubscriberURI.URL()
+	}
+	if sub.ReplyURI != nil {
+		reply = sub.ReplyURI.URL()
+	}
+	return subscriberKey(subscriber, reply)
+}
+
+func subscriberKeys(subs []fanout.Subscription) sets.String {
+	keys := sets.NewString()
+	for _, sub := range subs {
+		keys.Insert(subscriberKey(sub.Subscriber, sub.Reply))
+	}
+	return keys
+}
+
+func (r *Reconciler) getHealthTracker(hostName string) *healthTracker {
+	r.healthTrackersLock.RLock()
+	defer r.healthTrackersLock.RUnlock()
+	return r.healthTrackers[hostName]
+}
+
+// getOrCreateHealthTracker returns the subscriber health tracker of the channel. Whenever the
+// readiness of one of its subscribers changes, the channel is enqueued to update its status.
+func (r *Reconciler) getOrCreateHealthTracker(wsc *v1beta1.WebSocketChannel) *healthTracker {
+	hostName := wsc.Status.Address.URL.Host
+	key := types.NamespacedName{Namespace: wsc.Namespace, Name: wsc.Name}
+
+	r.healthTrackersLock.Lock()
+	defer r.healthTrackersLock.Unlock()
+	if tracker, ok := r.healthTrackers[hostName]; ok {
+		return tracker
+	}
+	tracker := newHealthTracker(func() {
+		if r.enqueueKey != nil {
+			r.enqueueKey(key)
+		}
+	})
+	r.healthTrackers[hostName] = tracker
+	return tracker
+}
+
+func (r *Reconciler) deleteHealthTracker(hostName string) {
+	r.healthTrackersLock.Lock()
+	defer r.healthTrackersLock.Unlock()
+	delete(r.healthTrackers, hostName)
+}
+
+func newConfigForWebSocketChannel(ctx context.Context, wsc *v1beta1.WebSocketChannel) (*multichannelfanout.ChannelConfig, error) {
 	subs := make([]fanout.Subscription, len(wsc.Spec.Subscribers))
 
 	for i, sub := range wsc.Spec.Subscribers {
@@ -134,6 +454,9 @@ func newConfigForWebSocketChannel(wsc *v1alpha1.WebSocketChannel) (*multichannel
 		if err != nil {
 			return nil, err
 		}
+		if err := applyChannelDelivery(ctx, wsc, conf); err != nil {
+			return nil, err
+		}
 		subs[i] = *conf
 	}
 
@@ -148,6 +471,41 @@ func newConfigForWebSocketChannel(wsc *v1alpha1.WebSocketChannel) (*multichannel
 	}, nil
 }
 
+// applyChannelDelivery falls back to the delivery options of the channel, or the cluster-wide
+// ones, for subscribers that don't configure their own dead letter sink or retries. Failed
+// deliveries routed to the dead letter sink carry the knativeerrorcode and knativeerrordata extensions.
+func applyChannelDelivery(ctx context.Context, wsc *v1beta1.WebSocketChannel, sub *fanout.Subscription) error {
+	if sub.DeadLetter == nil && wsc.Status.DeadLetterSinkURI != nil {
+		sub.DeadLetter = wsc.Status.DeadLetterSinkURI.URL()
+	}
+	delivery := wsc.Spec.Delivery
+	if delivery == nil {
+		delivery = config.FromContextOrDefaults(ctx).Channel.Delivery
+	}
+	if sub.RetryConfig == nil && delivery != nil {
+		rc, err := kncloudevents.RetryConfigFromDeliverySpec(*delivery)
+		if err != nil {
+			return err
+		}
+		sub.RetryConfig = &rc
+	}
+	return nil
+}
+
+// deleteWAL deletes the write-ahead log of a durable channel once every subscriber was sent all
+// its events. The channel no longer accepts events, but its deliveries go on until then.
+func (r *Reconciler) deleteWAL(hostName string) {
+	handler, ok := r.multiChannelMessageHandler.GetChannelHandler(hostName).(*channelHandler)
+	if !ok {
+		return
+	}
+	durable, ok := handler.MessageHandler.(*durableMessageHandler)
+	if !ok {
+		return
+	}
+	durable.deleteWhenDrained(drainRequeueInterval)
+}
+
 func (r *Reconciler) deleteFunc(obj interface{}) {
 	if obj == nil {
 		return
@@ -156,13 +514,22 @@ func (r *Reconciler) deleteFunc(obj interface{}) {
 	if err != nil {
 		return
 	}
-	wsc, ok := acc.(*v1alpha1.WebSocketChannel)
+	wsc, ok := acc.(*v1beta1.WebSocketChannel)
 	if !ok || wsc == nil {
 		return
 	}
 	if wsc.Status.Address != nil && wsc.Status.Address.URL != nil {
 		if hostName := wsc.Status.Address.URL.Host; hostName != "" {
-			r.multiChannelMessageHandler.DeleteChannelHandler(hostName)
+			r.deleteChannel(hostName)
 		}
 	}
 }
+
+func (r *Reconciler) deleteChannel(hostName string) {
+	r.deleteWAL(hostName)
+	r.deleteExternalHost(hostName)
+	r.multiChannelMessageHandler.DeleteChannelHandler(hostName)
+	r.deleteHealthTracker(hostName)
+	r.statsCollector.delete(hostName)
+	r.statusHandler.Delete(hostName)
+}
@@ -0,0 +1,80 @@
+package dispatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aliok/websocket-channel/pkg/apis/channels/v1beta1"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/channel/fanout"
+	"knative.dev/eventing/pkg/channel/multichannelfanout"
+)
+
+func TestReconcileExternalHost(t *testing.T) {
+	const (
+		hostA    = "a-kn-channel.ns.svc.cluster.local"
+		hostB    = "b-kn-channel.ns.svc.cluster.local"
+		external = "events.example.com"
+	)
+	r := &Reconciler{
+		multiChannelMessageHandler: multichannelfanout.NewMessageHandler(context.Background(), zap.NewNop(), channel.NewMessageDispatcher(zap.NewNop()), nil),
+		externalHosts:              make(map[string]string),
+	}
+	handlers := make(map[string]fanout.MessageHandler)
+	for _, host := range []string{hostA, hostB} {
+		handler, err := fanout.NewFanoutMessageHandler(zap.NewNop(), channel.NewMessageDispatcher(zap.NewNop()), fanout.Config{}, nil)
+		if err != nil {
+			t.Fatal("NewFanoutMessageHandler() =", err)
+		}
+		handlers[host] = handler
+		r.multiChannelMessageHandler.SetChannelHandler(host, handler)
+	}
+	exposed := func(name, host string) *v1beta1.WebSocketChannel {
+		wsc := &v1beta1.WebSocketChannel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name}}
+		if host != "" {
+			wsc.Spec.Exposure = &v1beta1.WebSocketChannelExposure{Host: host}
+		}
+		return wsc
+	}
+	routedTo := func(host string) fanout.MessageHandler {
+		return r.multiChannelMessageHandler.GetChannelHandler(host)
+	}
+
+	if err := r.reconcileExternalHost(exposed("a", external), hostA); err != nil {
+		t.Fatal("reconcileExternalHost(a) =", err)
+	}
+	if routedTo(external) != handlers[hostA] {
+		t.Fatal("The external host is not routed to channel a")
+	}
+	// Reconciling again keeps the route.
+	if err := r.reconcileExternalHost(exposed("a", external), hostA); err != nil {
+		t.Fatal("reconcileExternalHost(a) =", err)
+	}
+
+	// Hosts routed to another channel are not taken over.
+	if err := r.reconcileExternalHost(exposed("b", external), hostB); err == nil {
+		t.Error("reconcileExternalHost(b) = nil, want an error for the external host of channel a")
+	}
+	if err := r.reconcileExternalHost(exposed("b", hostA), hostB); err == nil {
+		t.Error("reconcileExternalHost(b) = nil, want an error for the internal host of channel a")
+	}
+	if routedTo(external) != handlers[hostA] || routedTo(hostA) != handlers[hostA] {
+		t.Fatal("The hosts of channel a were taken over by channel b")
+	}
+
+	// Once channel a is no longer exposed, its external host is free again.
+	if err := r.reconcileExternalHost(exposed("a", ""), hostA); err != nil {
+		t.Fatal("reconcileExternalHost(a) =", err)
+	}
+	if routedTo(external) != nil {
+		t.Fatal("The external host is still routed after channel a is no longer exposed")
+	}
+	if err := r.reconcileExternalHost(exposed("b", external), hostB); err != nil {
+		t.Fatal("reconcileExternalHost(b) =", err)
+	}
+	if routedTo(external) != handlers[hostB] {
+		t.Error("The external host is not routed to channel b")
+	}
+}
@@ -0,0 +1,182 @@
+package dispatcher
+
+import (
+	"context"
+	"log"
+	nethttp "net/http"
+	"strconv"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/metrics"
+	"knative.dev/pkg/metrics/metricskey"
+)
+
+var (
+	// eventCountM is a counter which records the number of events received and dispatched
+	// by the WebSocket channel.
+	eventCountM = stats.Int64(
+		"websocket_channel_event_count",
+		"Number of events dispatched by the WebSocket channel",
+		stats.UnitDimensionless,
+	)
+
+	// dispatchTimeInMsecM records the time spent dispatching an event to the subscribers of
+	// a WebSocket channel, in milliseconds.
+	dispatchTimeInMsecM = stats.Float64(
+		"websocket_channel_event_dispatch_latencies",
+		"The time spent dispatching an event from a WebSocket channel",
+		stats.UnitMilliseconds,
+	)
+
+	// expiredCountM is a counter which records the number of events of a WebSocket channel
+	// that expired before they could be delivered.
+	expiredCountM = stats.Int64(
+		"websocket_channel_event_expired_count",
+		"Number of events of the WebSocket channel that expired before they could be delivered",
+		stats.UnitDimensionless,
+	)
+
+	namespaceKey         = tag.MustNewKey(metricskey.LabelNamespaceName)
+	channelKey           = tag.MustNewKey("channel_name")
+	eventTypeKey         = tag.MustNewKey(metricskey.LabelEventType)
+	responseCodeKey      = tag.MustNewKey(metricskey.LabelResponseCode)
+	responseCodeClassKey = tag.MustNewKey(metricskey.LabelResponseCodeClass)
+)
+
+func init() {
+	tagKeys := []tag.Key{
+		namespaceKey,
+		channelKey,
+		eventTypeKey,
+		responseCodeKey,
+		responseCodeClassKey,
+		channel.UniqueTagKey,
+		channel.ContainerTagKey,
+	}
+
+	err := metrics.RegisterResourceView(
+		&view.View{
+			Description: eventCountM.Description(),
+			Measure:     eventCountM,
+			Aggregation: view.Count(),
+			TagKeys:     tagKeys,
+		},
+		&view.View{
+			Description: dispatchTimeInMsecM.Description(),
+			Measure:     dispatchTimeInMsecM,
+			Aggregation: view.Distribution(metrics.Buckets125(1, 10000)...),
+			TagKeys:     tagKeys,
+		},
+		&view.View{
+			Description: expiredCountM.Description(),
+			Measure:     expiredCountM,
+			Aggregation: view.Count(),
+			TagKeys: []tag.Key{
+				namespaceKey,
+				channelKey,
+				eventTypeKey,
+				channel.UniqueTagKey,
+				channel.ContainerTagKey,
+			},
+		},
+	)
+	if err != nil {
+		log.Print("failed to register opencensus views, " + err.Error())
+	}
+}
+
+// statsReporter is a channel.StatsReporter like the one of eventing, which also tags every
+// measurement with the name of the channel. Measurements are only reported through the
+// reporters of the channels, see forChannel.
+type statsReporter struct {
+	container  string
+	uniqueName string
+	channel    string
+}
+
+var _ channel.StatsReporter = (*statsReporter)(nil)
+
+func newStatsReporter(container, uniqueName string) *statsReporter {
+	return &statsReporter{
+		container:  container,
+		uniqueName: uniqueName,
+	}
+}
+
+// forChannel returns a reporter tagging its measurements with the given channel name.
+func (r *statsReporter) forChannel(name string) *statsReporter {
+	return &statsReporter{
+		container:  r.container,
+		uniqueName: r.uniqueName,
+		channel:    name,
+	}
+}
+
+// ReportEventCount captures the event count.
+func (r *statsReporter) ReportEventCount(args *channel.ReportArgs, responseCode int) error {
+	ctx, err := r.generateTag(args, responseCode)
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, eventCountM.M(1))
+	return nil
+}
+
+// ReportEventDispatchTime captures dispatch times.
+func (r *statsReporter) ReportEventDispatchTime(args *channel.ReportArgs, responseCode int, d time.Duration) error {
+	ctx, err := r.generateTag(args, responseCode)
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, dispatchTimeInMsecM.M(float64(d/time.Millisecond)))
+	return nil
+}
+
+// ReportEventExpired captures an event that expired before it could be delivered.
+func (r *statsReporter) ReportEventExpired(args *channel.ReportArgs) error {
+	ctx, err := tag.New(
+		context.Background(),
+		tag.Insert(namespaceKey, args.Ns),
+		tag.Insert(channelKey, r.channel),
+		tag.Insert(eventTypeKey, args.EventType),
+		tag.Insert(channel.ContainerTagKey, r.container),
+		tag.Insert(channel.UniqueTagKey, r.uniqueName))
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, expiredCountM.M(1))
+	return nil
+}
+
+// reportDispatch reports the outcome of the delivery of an event to a single subscriber, like the
+// fanout of eventing does for the deliveries to all subscribers.
+func reportDispatch(reporter channel.StatsReporter, args *channel.ReportArgs, info *channel.DispatchExecutionInfo, err error) {
+	if info != nil && info.Time > channel.NoDuration {
+		code := info.ResponseCode
+		if code <= channel.NoResponse {
+			code = nethttp.StatusInternalServerError
+		}
+		_ = reporter.ReportEventDispatchTime(args, code, info.Time)
+	}
+	if err != nil {
+		channel.ReportEventCountMetricsForDispatchError(err, reporter, args)
+	} else if info != nil {
+		_ = reporter.ReportEventCount(args, info.ResponseCode)
+	}
+}
+
+func (r *statsReporter) generateTag(args *channel.ReportArgs, responseCode int) (context.Context, error) {
+	return tag.New(
+		context.Background(),
+		tag.Insert(namespaceKey, args.Ns),
+		tag.Insert(channelKey, r.channel),
+		tag.Insert(eventTypeKey, args.EventType),
+		tag.Insert(responseCodeKey, strconv.Itoa(responseCode)),
+		tag.Insert(responseCodeClassKey, metrics.ResponseCodeClass(responseCode)),
+		tag.Insert(channel.ContainerTagKey, r.container),
+		tag.Insert(channel.UniqueTagKey, r.uniqueName))
+}
@@ -0,0 +1,550 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/binding/buffering"
+	"github.com/cloudevents/sdk-go/v2/binding/spec"
+	"github.com/cloudevents/sdk-go/v2/binding/transformer"
+	"go.opencensus.io/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/channel/attributes"
+	"knative.dev/eventing/pkg/kncloudevents"
+	"knative.dev/eventing/pkg/tracing"
+)
+
+// failureThreshold is the number of consecutive failed deliveries after which a subscriber
+// is reported as not ready.
+const failureThreshold = 3
+
+// maxHeldDeliveries is the number of deliveries held for a paused subscriber. The deliveries
+// beyond it are skipped rather than held, so a paused subscriber can't grow the memory of the
+// dispatcher without bound.
+const maxHeldDeliveries = 1000
+
+// errTooManyHeld is returned by waitResumed when maxHeldDeliveries are held for the paused
+// subscriber already.
+var errTooManyHeld = errors.New("too many deliveries held for the paused subscriber")
+
+// subscriberHealth is the delivery health of a single subscriber, as observed by this dispatcher.
+type subscriberHealth struct {
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastFailure         time.Time `json:"lastFailure"`
+	LastFailureReason   string    `json:"lastFailureReason,omitempty"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	DeadLettered        int64     `json:"deadLettered"`
+}
+
+// readiness maps the delivery health to the Ready status and message of a SubscriberStatus.
+func (h subscriberHealth) readiness() (corev1.ConditionStatus, string) {
+	switch {
+	case h.ConsecutiveFailures == 0:
+		return corev1.ConditionTrue, ""
+	case h.ConsecutiveFailures < failureThreshold:
+		return corev1.ConditionUnknown, fmt.Sprintf("%d consecutive deliveries failed, last at %s: %s",
+			h.ConsecutiveFailures, h.LastFailure.Format(time.RFC3339), h.LastFailureReason)
+	default:
+		return corev1.ConditionFalse, fmt.Sprintf("%d consecutive deliveries failed, last at %s: %s",
+			h.ConsecutiveFailures, h.LastFailure.Format(time.RFC3339), h.LastFailureReason)
+	}
+}
+
+// healthTracker records the delivery health of the subscribers of a single channel.
+// Subscribers are keyed by their destination, see subscriberKey.
+type healthTracker struct {
+	mu     sync.RWMutex
+	health map[string]*subscriberHealth
+
+	// changes is the number of deliveries recorded so far.
+	changes uint64
+	// notify is whether onChange is called on the next recorded delivery, see notifyOnChangeSince.
+	notify bool
+
+	// onChange is called when the readiness of a subscriber changes, and on the delivery
+	// awaited by notifyOnChangeSince.
+	onChange func()
+}
+
+func newHealthTracker(onChange func()) *healthTracker {
+	return &healthTracker{
+		health:   make(map[string]*subscriberHealth),
+		onChange: onChange,
+	}
+}
+
+// subscriberKey returns the key the health of a subscriber is tracked with: its subscriber URL,
+// or its reply URL when there is no subscriber.
+func subscriberKey(subscriber, reply *url.URL) string {
+	if subscriber != nil {
+		return subscriber.String()
+	}
+	if reply != nil {
+		return reply.String()
+	}
+	return ""
+}
+
+func (t *healthTracker) record(key string, err error) {
+	if key == "" {
+		return
+	}
+
+	t.mu.Lock()
+	h, ok := t.health[key]
+	if !ok {
+		h = &subscriberHealth{}
+		t.health[key] = h
+	}
+	before, _ := h.readiness()
+	if err == nil {
+		h.LastSuccess = time.Now()
+		h.ConsecutiveFailures = 0
+	} else {
+		h.LastFailure = time.Now()
+		h.LastFailureReason = err.Error()
+		h.ConsecutiveFailures++
+	}
+	after, _ := h.readiness()
+	notify := before != after || t.notify
+	t.changes++
+	t.notify = false
+	t.mu.Unlock()
+
+	if notify && t.onChange != nil {
+		t.onChange()
+	}
+}
+
+// recordDeadLettered records that an event for the subscriber with the given key was sent to
+// the dead letter sink.
+func (t *healthTracker) recordDeadLettered(key string) {
+	if key == "" {
+		return
+	}
+
+	t.mu.Lock()
+	h, ok := t.health[key]
+	if !ok {
+		h = &subscriberHealth{}
+		t.health[key] = h
+	}
+	h.DeadLettered++
+	notify := t.notify
+	t.changes++
+	t.notify = false
+	t.mu.Unlock()
+
+	if notify && t.onChange != nil {
+		t.onChange()
+	}
+}
+
+// version returns the number of deliveries recorded so far, to tell whether the health changed.
+func (t *healthTracker) version() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.changes
+}
+
+// notifyOnChangeSince makes the next recorded delivery call onChange, or calls it right away if
+// a delivery was recorded since the given version.
+func (t *healthTracker) notifyOnChangeSince(version uint64) {
+	t.mu.Lock()
+	changed := t.changes != version
+	t.notify = !changed
+	t.mu.Unlock()
+
+	if changed && t.onChange != nil {
+		t.onChange()
+	}
+}
+
+// snapshot returns a copy of the health of all subscribers a delivery has been attempted to.
+func (t *healthTracker) snapshot() map[string]subscriberHealth {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	health := make(map[string]subscriberHealth, len(t.health))
+	for key, h := range t.health {
+		health[key] = *h
+	}
+	return health
+}
+
+// retain drops the health of subscribers whose key is not in keys.
+func (t *healthTracker) retain(keys sets.String) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.health {
+		if !keys.Has(key) {
+			delete(t.health, key)
+		}
+	}
+}
+
+// healthTrackingDispatcher is a channel.MessageDispatcher recording the outcome of every
+// delivery in a healthTracker.
+//
+// The delegate dispatcher sends events to the dead letter sink itself, hiding failures of
+// the subscriber. So the dead letter sink is handled here instead.
+type healthTrackingDispatcher struct {
+	channel.MessageDispatcher
+	tracker *healthTracker
+
+	// dispatching is the number of deliveries in flight, including their retries.
+	dispatching int64
+
+	// paused holds the subscribers paused through the admin API, keyed by subscriber key.
+	pausedLock sync.RWMutex
+	paused     map[string]*pausedSubscriber
+
+	// expiry holds the *expiryConfig of the channel.
+	expiry    atomic.Value
+	reporter  *statsReporter
+	namespace string
+
+	// batchers holds the batcher of every subscriber receiving its events in batches, keyed by subscriber key.
+	batchersLock sync.RWMutex
+	batchers     map[string]*batcher
+}
+
+// pausedSubscriber is a subscriber paused through the admin API.
+type pausedSubscriber struct {
+	// resumed is closed when the subscriber is resumed.
+	resumed chan struct{}
+
+	// held is the number of deliveries waiting for the subscriber to be resumed.
+	held int32
+}
+
+var _ channel.MessageDispatcher = (*healthTrackingDispatcher)(nil)
+
+func newHealthTrackingDispatcher(delegate channel.MessageDispatcher, tracker *healthTracker, reporter *statsReporter, namespace string) *healthTrackingDispatcher {
+	d := &healthTrackingDispatcher{
+		MessageDispatcher: delegate,
+		tracker:           tracker,
+		paused:            make(map[string]*pausedSubscriber),
+		reporter:          reporter,
+		namespace:         namespace,
+		batchers:          make(map[string]*batcher),
+	}
+	d.setExpiry(&expiryConfig{})
+	return d
+}
+
+// setBatching sets the subscribers receiving their events in batches. The events pending for a
+// subscriber that doesn't anymore are sent right away.
+func (d *healthTrackingDispatcher) setBatching(configs map[string]batchConfig) {
+	d.batchersLock.Lock()
+	defer d.batchersLock.Unlock()
+	for key, config := range configs {
+		if b, ok := d.batchers[key]; ok {
+			b.setConfig(config)
+		} else {
+			d.batchers[key] = newBatcher(d, key, config)
+		}
+	}
+	for key, b := range d.batchers {
+		if _, ok := configs[key]; !ok {
+			delete(d.batchers, key)
+			go b.flushPending()
+		}
+	}
+}
+
+func (d *healthTrackingDispatcher) getBatcher(key string) *batcher {
+	d.batchersLock.RLock()
+	defer d.batchersLock.RUnlock()
+	return d.batchers[key]
+}
+
+// setExpiry sets when the events of the channel expire, for the deliveries started from now on.
+func (d *healthTrackingDispatcher) setExpiry(expiry *expiryConfig) {
+	d.expiry.Store(expiry)
+}
+
+func (d *healthTrackingDispatcher) getExpiry() *expiryConfig {
+	return d.expiry.Load().(*expiryConfig)
+}
+
+// setPaused pauses or resumes the deliveries to the subscriber with the given key. The deliveries
+// to a paused subscriber are held until it is resumed, see waitResumed.
+func (d *healthTrackingDispatcher) setPaused(key string, paused bool) {
+	d.pausedLock.Lock()
+	defer d.pausedLock.Unlock()
+	p, ok := d.paused[key]
+	switch {
+	case paused && !ok:
+		d.paused[key] = &pausedSubscriber{resumed: make(chan struct{})}
+	case !paused && ok:
+		close(p.resumed)
+		delete(d.paused, key)
+	}
+}
+
+func (d *healthTrackingDispatcher) isPaused(key string) bool {
+	d.pausedLock.RLock()
+	defer d.pausedLock.RUnlock()
+	_, ok := d.paused[key]
+	return ok
+}
+
+// waitResumed holds a delivery to the subscriber with the given key while it is paused. It returns
+// when the subscriber is resumed or the event expires, errTooManyHeld right away when
+// maxHeldDeliveries are held for it already, or the error of the context when the delivery is
+// cancelled first.
+func (d *healthTrackingDispatcher) waitResumed(ctx context.Context, key string, deadline time.Time, expires bool) error {
+	d.pausedLock.RLock()
+	p, ok := d.paused[key]
+	d.pausedLock.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if atomic.AddInt32(&p.held, 1) > maxHeldDeliveries {
+		atomic.AddInt32(&p.held, -1)
+		return errTooManyHeld
+	}
+	defer atomic.AddInt32(&p.held, -1)
+
+	var expired <-chan time.Time
+	if expires {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-p.resumed:
+	case <-expired:
+	case <-ctx.Done():
+		return fmt.Errorf("subscriber %s is paused: %w", key, ctx.Err())
+	}
+	return nil
+}
+
+func (d *healthTrackingDispatcher) DispatchMessage(ctx context.Context, message cloudevents.Message, additionalHeaders nethttp.Header, destination *url.URL, reply *url.URL, deadLetter *url.URL) (*channel.DispatchExecutionInfo, error) {
+	return d.DispatchMessageWithRetries(ctx, message, additionalHeaders, destination, reply, deadLetter, nil)
+}
+
+// inFlight returns the number of deliveries in flight, including their retries.
+func (d *healthTrackingDispatcher) inFlight() int64 {
+	return atomic.LoadInt64(&d.dispatching)
+}
+
+func (d *healthTrackingDispatcher) DispatchMessageWithRetries(ctx context.Context, message cloudevents.Message, additionalHeaders nethttp.Header, destination *url.URL, reply *url.URL, deadLetter *url.URL, config *kncloudevents.RetryConfig) (*channel.DispatchExecutionInfo, error) {
+	atomic.AddInt64(&d.dispatching, 1)
+	defer atomic.AddInt64(&d.dispatching, -1)
+
+	key := subscriberKey(destination, reply)
+
+	// Group the requests to the subscriber, its retries and the dead letter sink under one span.
+	ctx, span := trace.StartSpan(ctx, "websocketchannel.dispatch", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	if span.IsRecordingEvents() {
+		span.AddAttributes(
+			tracing.MessagingSystemAttribute,
+			tracing.MessagingProtocolHTTP,
+			trace.StringAttribute(tracing.MessagingDestinationAttributeName, key),
+		)
+	}
+
+	// Events that expired are not delivered anymore, and not retried once they expire.
+	expiry := d.getExpiry()
+	deadline, eventType, expires := expiry.deadline(ctx, message)
+
+	// Deliveries to a paused subscriber are held until it is resumed or the event expires, the
+	// ones beyond maxHeldDeliveries are skipped. Deliveries skipped on purpose are not failures of
+	// the subscriber.
+	dispatch := d.MessageDispatcher.DispatchMessageWithRetries
+	record := d.tracker.record
+	skipped := false
+	if err := d.waitResumed(ctx, key, deadline, expires); err == errTooManyHeld {
+		dispatch = skipPaused
+		record = func(string, error) {}
+		skipped = true
+	} else if err != nil {
+		_ = message.Finish(nil)
+		setSpanStatus(span, err)
+		return nil, err
+	}
+
+	if expires && !time.Now().Before(deadline) {
+		defer message.Finish(nil)
+		return d.expire(ctx, message, additionalHeaders, key, eventType, deadLetter, config, expiry.deadLetter)
+	}
+
+	if b := d.getBatcher(key); b != nil && destination != nil && !skipped {
+		info, err := d.batch(ctx, b, message, additionalHeaders, destination, reply, deadLetter, config, deadline, expires, expiry)
+		setSpanStatus(span, err)
+		return info, err
+	}
+
+	deliveryConfig := config
+	if expires {
+		deliveryConfig = retryUntil(config, deadline)
+	}
+
+	if deadLetter == nil {
+		info, err := dispatch(ctx, message, additionalHeaders, destination, reply, nil, deliveryConfig)
+		record(key, err)
+		setSpanStatus(span, err)
+		if err != nil && expires && !time.Now().Before(deadline) {
+			_ = d.reporter.ReportEventExpired(&channel.ReportArgs{Ns: d.namespace, EventType: eventType})
+			return info, fmt.Errorf("%w: %v", errEventExpired, err)
+		}
+		return info, err
+	}
+
+	// The message is needed again for the dead letter sink, so it is finished here rather than by the delegate.
+	defer message.Finish(nil)
+
+	info, err := dispatch(ctx, unfinishableMessage{message}, additionalHeaders, destination, reply, nil, deliveryConfig)
+	record(key, err)
+	setSpanStatus(span, err)
+	if err == nil {
+		return info, nil
+	}
+	if expires && !time.Now().Before(deadline) {
+		return d.expire(ctx, message, additionalHeaders, key, eventType, deadLetter, config, expiry.deadLetter)
+	}
+
+	// Send the original message to the dead letter sink with the knative error extensions.
+	var transformers binding.Transformers
+	if info != nil {
+		transformers = attributes.KnativeErrorTransformers(info.ResponseCode, string(info.ResponseBody))
+	}
+	deadLetterMessage, copyErr := buffering.CopyMessage(ctx, message, transformers...)
+	if copyErr != nil {
+		return info, fmt.Errorf("unable to complete request to %s (%v) nor to buffer it for %s (%v)", destination, err, deadLetter, copyErr)
+	}
+	deadLetterInfo, deadLetterErr := d.MessageDispatcher.DispatchMessageWithRetries(ctx, deadLetterMessage, additionalHeaders, deadLetter, nil, nil, config)
+	if deadLetterErr != nil {
+		return deadLetterInfo, fmt.Errorf("unable to complete request to either %s (%v) or %s (%v)", destination, err, deadLetter, deadLetterErr)
+	}
+	d.tracker.recordDeadLettered(key)
+	return deadLetterInfo, nil
+}
+
+// batch delivers the event in the next batch for the subscriber, see batcher.
+func (d *healthTrackingDispatcher) batch(ctx context.Context, b *batcher, message binding.Message, additionalHeaders nethttp.Header, destination *url.URL, reply *url.URL, deadLetter *url.URL, config *kncloudevents.RetryConfig, deadline time.Time, expires bool, expiry *expiryConfig) (*channel.DispatchExecutionInfo, error) {
+	event, err := binding.ToEvent(ctx, message)
+	_ = message.Finish(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the event for a batch: %w", err)
+	}
+
+	e := &batchedEvent{
+		ctx:   ctx,
+		event: event,
+		target: batchTarget{
+			destination:       destination,
+			reply:             reply,
+			deadLetter:        deadLetter,
+			config:            config,
+			additionalHeaders: additionalHeaders,
+		},
+		result:   make(chan batchResult, 1),
+		deadline: deadline,
+		expires:  expires,
+	}
+	if expiry != nil {
+		e.deadLetterExpired = expiry.deadLetter
+	}
+	result := b.add(e)
+	return result.info, result.err
+}
+
+// expire counts an event that expired before it could be delivered, and sends it to the dead letter
+// sink if the channel asks for it. It returns errEventExpired if the event is dropped.
+func (d *healthTrackingDispatcher) expire(ctx context.Context, message binding.Message, additionalHeaders nethttp.Header, key, eventType string, deadLetter *url.URL, config *kncloudevents.RetryConfig, sendToDeadLetter bool) (*channel.DispatchExecutionInfo, error) {
+	_ = d.reporter.ReportEventExpired(&channel.ReportArgs{Ns: d.namespace, EventType: eventType})
+	if deadLetter == nil || !sendToDeadLetter {
+		return nil, fmt.Errorf("%w before it could be delivered to %s", errEventExpired, key)
+	}
+
+	info, err := d.sendToDeadLetter(ctx, message, additionalHeaders, key, deadLetter, config, errorCodeTransformers(expiredErrorCode)...)
+	if err != nil {
+		return info, fmt.Errorf("%w and could not be sent to %s (%v)", errEventExpired, deadLetter, err)
+	}
+	return info, nil
+}
+
+// sendToDeadLetter sends a copy of the message, transformed by the given transformers, to the dead
+// letter sink of the subscriber with the given key, without trying the subscriber first.
+func (d *healthTrackingDispatcher) sendToDeadLetter(ctx context.Context, message binding.Message, additionalHeaders nethttp.Header, key string, deadLetter *url.URL, config *kncloudevents.RetryConfig, transformers ...binding.Transformer) (*channel.DispatchExecutionInfo, error) {
+	deadLetterMessage, err := buffering.CopyMessage(ctx, message, transformers...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to buffer the event: %w", err)
+	}
+	info, err := d.MessageDispatcher.DispatchMessageWithRetries(ctx, deadLetterMessage, additionalHeaders, deadLetter, nil, nil, config)
+	if err != nil {
+		return info, err
+	}
+	d.tracker.recordDeadLettered(key)
+	return info, nil
+}
+
+// errorCodeTransformers set the knativeerrorcode of an event sent to the dead letter sink for
+// another reason than a failure of the subscriber.
+func errorCodeTransformers(code string) binding.Transformers {
+	return binding.Transformers{
+		transformer.AddExtension(attributes.KnativeErrorCodeExtensionKey, code),
+	}
+}
+
+// skipPaused stands in for the delegate dispatcher while a subscriber is paused.
+func skipPaused(_ context.Context, message cloudevents.Message, _ nethttp.Header, destination *url.URL, reply *url.URL, _ *url.URL, _ *kncloudevents.RetryConfig) (*channel.DispatchExecutionInfo, error) {
+	_ = message.Finish(nil)
+	return nil, fmt.Errorf("subscriber %s is paused", subscriberKey(destination, reply))
+}
+
+// setSpanStatus marks the span of a delivery as failed if the subscriber could not be reached.
+func setSpanStatus(span *trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+}
+
+// unfinishableMessage is a binding.Message ignoring Finish calls, so it can be dispatched more than once.
+// It is a binding.MessageMetadataReader like the message it wraps, which writing binary messages
+// with transformers requires.
+type unfinishableMessage struct {
+	binding.Message
+}
+
+var (
+	_ binding.MessageMetadataReader = unfinishableMessage{}
+	_ binding.MessageWrapper        = unfinishableMessage{}
+)
+
+func (unfinishableMessage) Finish(error) error {
+	return nil
+}
+
+func (m unfinishableMessage) GetAttribute(k spec.Kind) (spec.Attribute, interface{}) {
+	if reader, ok := m.Message.(binding.MessageMetadataReader); ok {
+		return reader.GetAttribute(k)
+	}
+	return nil, nil
+}
+
+func (m unfinishableMessage) GetExtension(name string) interface{} {
+	if reader, ok := m.Message.(binding.MessageMetadataReader); ok {
+		return reader.GetExtension(name)
+	}
+	return nil
+}
+
+func (m unfinishableMessage) GetWrappedMessage() binding.Message {
+	return m.Message
+}
@@ -0,0 +1,239 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/binding/buffering"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	"knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/kncloudevents"
+)
+
+// fakeDispatcher is a channel.MessageDispatcher recording the destinations of the events it
+// dispatches. The deliveries to the destinations in fail fail with a 500.
+type fakeDispatcher struct {
+	mu         sync.Mutex
+	dispatched []string
+	fail       map[string]bool
+}
+
+var _ channel.MessageDispatcher = (*fakeDispatcher)(nil)
+
+func (f *fakeDispatcher) DispatchMessage(ctx context.Context, message cloudevents.Message, additionalHeaders nethttp.Header, destination *url.URL, reply *url.URL, deadLetter *url.URL) (*channel.DispatchExecutionInfo, error) {
+	return f.DispatchMessageWithRetries(ctx, message, additionalHeaders, destination, reply, deadLetter, nil)
+}
+
+func (f *fakeDispatcher) DispatchMessageWithRetries(_ context.Context, message cloudevents.Message, _ nethttp.Header, destination *url.URL, _ *url.URL, _ *url.URL, _ *kncloudevents.RetryConfig) (*channel.DispatchExecutionInfo, error) {
+	_ = message.Finish(nil)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dispatched = append(f.dispatched, destination.String())
+	if f.fail[destination.String()] {
+		return &channel.DispatchExecutionInfo{ResponseCode: nethttp.StatusInternalServerError}, errors.New("500 Internal Server Error")
+	}
+	return &channel.DispatchExecutionInfo{ResponseCode: nethttp.StatusAccepted}, nil
+}
+
+func (f *fakeDispatcher) destinations() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.dispatched...)
+}
+
+func testStatsReporter() *statsReporter {
+	return newStatsReporter("dispatcher", "test").forChannel("channel")
+}
+
+// newTestDispatcher returns a healthTrackingDispatcher of a channel in the namespace ns.
+func newTestDispatcher(delegate channel.MessageDispatcher, tracker *healthTracker) *healthTrackingDispatcher {
+	return newHealthTrackingDispatcher(delegate, tracker, testStatsReporter(), "ns")
+}
+
+func testMessage(id string) binding.Message {
+	event := cloudevents.NewEvent()
+	event.SetID(id)
+	event.SetType("test.type")
+	event.SetSource("test-source")
+	return binding.ToMessage(&event)
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal("Parse() =", err)
+	}
+	return u
+}
+
+// heldDeliveries returns the number of deliveries held for the paused subscriber with the given key.
+func heldDeliveries(d *healthTrackingDispatcher, key string) int32 {
+	d.pausedLock.RLock()
+	defer d.pausedLock.RUnlock()
+	if p, ok := d.paused[key]; ok {
+		return atomic.LoadInt32(&p.held)
+	}
+	return 0
+}
+
+// waitFor polls condition until it holds, or fails the test after a few seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPausedSubscriberHoldsDeliveriesUntilResumed(t *testing.T) {
+	fake := &fakeDispatcher{}
+	d := newTestDispatcher(fake, newHealthTracker(nil))
+	subscriber := mustParseURL(t, "http://subscriber.ns.svc.cluster.local/")
+	key := subscriberKey(subscriber, nil)
+
+	d.setPaused(key, true)
+	if !d.isPaused(key) {
+		t.Fatal("isPaused() = false after pausing")
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := d.DispatchMessageWithRetries(context.Background(), testMessage("1"), nil, subscriber, nil, nil, nil)
+		errs <- err
+	}()
+	waitFor(t, "the delivery to be held", func() bool { return heldDeliveries(d, key) == 1 })
+	if got := fake.destinations(); len(got) != 0 {
+		t.Fatal("Delivered to a paused subscriber:", got)
+	}
+
+	d.setPaused(key, false)
+	if err := <-errs; err != nil {
+		t.Fatal("DispatchMessageWithRetries() =", err)
+	}
+	if got := fake.destinations(); len(got) != 1 || got[0] != subscriber.String() {
+		t.Errorf("Delivered to %v, want [%s]", got, subscriber)
+	}
+	if d.isPaused(key) {
+		t.Error("isPaused() = true after resuming")
+	}
+}
+
+func TestPausedSubscriberBoundsHeldDeliveries(t *testing.T) {
+	fake := &fakeDispatcher{}
+	tracker := newHealthTracker(nil)
+	d := newTestDispatcher(fake, tracker)
+	subscriber := mustParseURL(t, "http://subscriber.ns.svc.cluster.local/")
+	deadLetter := mustParseURL(t, "http://dls.ns.svc.cluster.local/")
+	key := subscriberKey(subscriber, nil)
+
+	d.setPaused(key, true)
+	var wg sync.WaitGroup
+	for i := 0; i < maxHeldDeliveries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = d.DispatchMessageWithRetries(context.Background(), testMessage("held"), nil, subscriber, nil, nil, nil)
+		}()
+	}
+	waitFor(t, "the deliveries to be held", func() bool { return heldDeliveries(d, key) == maxHeldDeliveries })
+
+	// The delivery beyond the bound is sent to the dead letter sink right away.
+	if _, err := d.DispatchMessageWithRetries(context.Background(), testMessage("skipped"), nil, subscriber, nil, deadLetter, nil); err != nil {
+		t.Fatal("DispatchMessageWithRetries() =", err)
+	}
+	if got := fake.destinations(); len(got) != 1 || got[0] != deadLetter.String() {
+		t.Errorf("Delivered to %v, want [%s]", got, deadLetter)
+	}
+	// Without a dead letter sink, it is dropped.
+	if _, err := d.DispatchMessageWithRetries(context.Background(), testMessage("dropped"), nil, subscriber, nil, nil, nil); err == nil {
+		t.Error("DispatchMessageWithRetries() = nil, wanted an error for the dropped event")
+	}
+	// Skipping deliveries is not a failure of the subscriber.
+	if h, ok := tracker.snapshot()[key]; ok && h.ConsecutiveFailures != 0 {
+		t.Errorf("ConsecutiveFailures = %d, want 0", h.ConsecutiveFailures)
+	}
+
+	d.setPaused(key, false)
+	wg.Wait()
+	if got := len(fake.destinations()); got != maxHeldDeliveries+1 {
+		t.Errorf("Delivered %d events, want %d", got, maxHeldDeliveries+1)
+	}
+}
+
+func TestPausedSubscriberDeliveryCancelled(t *testing.T) {
+	fake := &fakeDispatcher{}
+	d := newTestDispatcher(fake, newHealthTracker(nil))
+	subscriber := mustParseURL(t, "http://subscriber.ns.svc.cluster.local/")
+	key := subscriberKey(subscriber, nil)
+	d.setPaused(key, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := d.DispatchMessageWithRetries(ctx, testMessage("1"), nil, subscriber, nil, nil, nil)
+		errs <- err
+	}()
+	waitFor(t, "the delivery to be held", func() bool { return heldDeliveries(d, key) == 1 })
+	cancel()
+
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("DispatchMessageWithRetries() = %v, want %v", err, context.Canceled)
+	}
+	if got := heldDeliveries(d, key); got != 0 {
+		t.Errorf("heldDeliveries() = %d after the delivery was cancelled", got)
+	}
+	if got := fake.destinations(); len(got) != 0 {
+		t.Error("Delivered to a paused subscriber:", got)
+	}
+}
+
+func TestDispatchBinaryMessageWithDeadLetter(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(nethttp.HandlerFunc(func(response nethttp.ResponseWriter, request *nethttp.Request) {
+		received <- request.Header.Get("Ce-Id")
+		response.WriteHeader(nethttp.StatusAccepted)
+	}))
+	defer server.Close()
+
+	request := httptest.NewRequest(nethttp.MethodPost, "/", strings.NewReader(`{"hello":"world"}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Ce-Specversion", "1.0")
+	request.Header.Set("Ce-Id", "1")
+	request.Header.Set("Ce-Type", "test.type")
+	request.Header.Set("Ce-Source", "test-source")
+	message, err := buffering.CopyMessage(context.Background(), cehttp.NewMessageFromHttpRequest(request))
+	if err != nil {
+		t.Fatal("CopyMessage() =", err)
+	}
+
+	// Deliveries to subscribers with a dead letter sink wrap the message. The dispatcher writes
+	// binary messages with transformers when the span is sampled, which read the attributes of
+	// the wrapped message.
+	ctx, span := trace.StartSpan(context.Background(), "test", trace.WithSampler(trace.AlwaysSample()))
+	defer span.End()
+	d := newTestDispatcher(channel.NewMessageDispatcher(zap.NewNop()), newHealthTracker(nil))
+	deadLetter := mustParseURL(t, server.URL+"/dls")
+	if _, err := d.DispatchMessageWithRetries(ctx, message, nil, mustParseURL(t, server.URL), nil, deadLetter, nil); err != nil {
+		t.Fatal("DispatchMessageWithRetries() =", err)
+	}
+	if got := <-received; got != "1" {
+		t.Errorf("Ce-Id = %q, want 1", got)
+	}
+}
@@ -0,0 +1,254 @@
+package dispatcher
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliok/websocket-channel/pkg/channel/status"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/equality"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// statsPath is the path dispatchers serve the delivery health of the subscribers of a
+	// channel on, next to status.Path. The channel is selected by its host name in the
+	// channel query parameter. Requests have to carry the admin token as a bearer token.
+	statsPath = "/subscribers"
+
+	// dispatcherName is the name of the dispatcher Service, whose endpoints are the dispatcher replicas.
+	dispatcherName = "websocket-ch-dispatcher"
+
+	// statsRefreshInterval is the minimum time between two collections of the subscriber
+	// statistics of a channel from all replicas, and so between two updates of status.subscriberStats.
+	statsRefreshInterval = 10 * time.Second
+
+	// statsMaxAge is the time after which the subscriber statistics of a channel are collected
+	// again when they did not change, to pick up deliveries of the other replicas.
+	statsMaxAge = 5 * time.Minute
+
+	// statsTimeout is the time a single dispatcher replica gets to answer a statistics request.
+	statsTimeout = 2 * time.Second
+)
+
+// replicaStats is the delivery health of the subscribers of a channel as observed by a single
+// dispatcher replica, keyed by subscriber key.
+type replicaStats struct {
+	Replica     string                      `json:"replica"`
+	Subscribers map[string]subscriberHealth `json:"subscribers"`
+}
+
+// statsHandler serves the delivery health of the subscribers of the channels of this replica to
+// the requests that carry the given token as a bearer token.
+func (r *Reconciler) statsHandler(replica, token string) nethttp.Handler {
+	return nethttp.HandlerFunc(func(response nethttp.ResponseWriter, request *nethttp.Request) {
+		if !authorized(request, token) {
+			response.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		if request.Method != nethttp.MethodGet {
+			response.WriteHeader(nethttp.StatusMethodNotAllowed)
+			return
+		}
+		tracker := r.getHealthTracker(request.URL.Query().Get("channel"))
+		if tracker == nil {
+			response.WriteHeader(nethttp.StatusNotFound)
+			return
+		}
+
+		body, err := json.Marshal(replicaStats{Replica: replica, Subscribers: tracker.snapshot()})
+		if err != nil {
+			response.WriteHeader(nethttp.StatusInternalServerError)
+			return
+		}
+		response.Header().Set("Content-Type", "application/json")
+		_, _ = response.Write(body)
+	})
+}
+
+// authorized returns whether the request carries the given token as a bearer token. No request
+// is authorized with an empty token.
+func authorized(request *nethttp.Request, token string) bool {
+	presented := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
+
+// statsCollector collects the delivery health of the subscribers of a channel from the other
+// dispatcher replicas. The collected health is cached per channel for statsRefreshInterval, or
+// statsMaxAge when it did not change.
+type statsCollector struct {
+	client    *nethttp.Client
+	endpoints corev1listers.EndpointsNamespaceLister
+	replica   string
+	token     string
+
+	mu       sync.Mutex
+	channels map[string]*collectedStats
+}
+
+// collectedStats is the delivery health of the subscribers of a channel collected from the other replicas.
+type collectedStats struct {
+	collected time.Time
+	replicas  []map[string]subscriberHealth
+	// version is the version of the health tracker of this replica at the time of the collection.
+	version uint64
+	// changed is whether the health observed by any replica changed since the previous collection.
+	changed bool
+}
+
+// maxAge returns the time after which the collected health has to be collected again, given the
+// current version of the health tracker of this replica.
+func (s *collectedStats) maxAge(version uint64) time.Duration {
+	if s.changed || s.version != version {
+		return statsRefreshInterval
+	}
+	return statsMaxAge
+}
+
+// newStatsCollector returns a statsCollector that finds the other replicas in the endpoints of the
+// dispatcher Service. Without a token, the statistics of the other replicas are not collected.
+func newStatsCollector(endpoints corev1listers.EndpointsNamespaceLister, replica, token string) *statsCollector {
+	return &statsCollector{
+		client:    &nethttp.Client{Timeout: statsTimeout},
+		endpoints: endpoints,
+		replica:   replica,
+		token:     token,
+		channels:  make(map[string]*collectedStats),
+	}
+}
+
+// get returns the health observed by the other replicas for the channel with the given host
+// name, collecting it again if the cached one is too old, see collectedStats.maxAge. version is
+// the current version of the health tracker of the channel on this replica. get also returns
+// whether the health was collected again, and when it has to be collected next.
+func (c *statsCollector) get(ctx context.Context, host string, version uint64) ([]map[string]subscriberHealth, bool, time.Duration) {
+	c.mu.Lock()
+	cached, ok := c.channels[host]
+	c.mu.Unlock()
+	if ok {
+		if maxAge, age := cached.maxAge(version), time.Since(cached.collected); age < maxAge {
+			return cached.replicas, false, maxAge - age
+		}
+	}
+
+	collected := &collectedStats{
+		collected: time.Now(),
+		replicas:  c.collect(ctx, host),
+		version:   version,
+	}
+	collected.changed = !ok || cached.version != version || !equality.Semantic.DeepEqual(cached.replicas, collected.replicas)
+	c.mu.Lock()
+	c.channels[host] = collected
+	c.mu.Unlock()
+	return collected.replicas, true, collected.maxAge(version)
+}
+
+// delete drops the health collected for the channel with the given host name.
+func (c *statsCollector) delete(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.channels, host)
+}
+
+// collect requests the health of the subscribers of the channel from every other ready
+// dispatcher replica. Replicas that fail to answer are left out.
+func (c *statsCollector) collect(ctx context.Context, host string) []map[string]subscriberHealth {
+	logger := logging.FromContext(ctx)
+	if c.token == "" {
+		return nil
+	}
+
+	endpoints, err := c.endpoints.Get(dispatcherName)
+	if err != nil {
+		logger.Warnw("Failed to get the dispatcher endpoints, using the subscriber statistics of this replica only", zap.Error(err))
+		return nil
+	}
+	var ips []string
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.TargetRef != nil && address.TargetRef.Name == c.replica {
+				continue
+			}
+			ips = append(ips, address.IP)
+		}
+	}
+
+	var wg sync.WaitGroup
+	stats := make([]*replicaStats, len(ips))
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			s, err := c.collectReplica(ctx, ip, host)
+			if err != nil {
+				logger.Debugw("Failed to collect subscriber statistics", zap.String("replica", ip), zap.Error(err))
+				return
+			}
+			stats[i] = s
+		}(i, ip)
+	}
+	wg.Wait()
+
+	var replicas []map[string]subscriberHealth
+	for _, s := range stats {
+		if s != nil && s.Replica != c.replica {
+			replicas = append(replicas, s.Subscribers)
+		}
+	}
+	return replicas
+}
+
+func (c *statsCollector) collectReplica(ctx context.Context, ip, host string) (*replicaStats, error) {
+	target := "http://" + net.JoinHostPort(ip, strconv.Itoa(status.Port)) + statsPath + "?channel=" + url.QueryEscape(host)
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != nethttp.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	stats := &replicaStats{}
+	if err := json.NewDecoder(resp.Body).Decode(stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// aggregateHealth merges the health of a subscriber observed by several replicas. Consecutive
+// failures are only counted on replicas that have seen failures after the last success of any replica.
+func aggregateHealth(observed []subscriberHealth) subscriberHealth {
+	var aggregated subscriberHealth
+	for _, h := range observed {
+		if h.LastSuccess.After(aggregated.LastSuccess) {
+			aggregated.LastSuccess = h.LastSuccess
+		}
+		if h.LastFailure.After(aggregated.LastFailure) {
+			aggregated.LastFailure = h.LastFailure
+			aggregated.LastFailureReason = h.LastFailureReason
+		}
+		aggregated.DeadLettered += h.DeadLettered
+	}
+	for _, h := range observed {
+		if h.LastFailure.After(aggregated.LastSuccess) && h.ConsecutiveFailures > aggregated.ConsecutiveFailures {
+			aggregated.ConsecutiveFailures = h.ConsecutiveFailures
+		}
+	}
+	return aggregated
+}